@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+type auditContextKey struct{}
+
+// auditEntry collects what a handler knows about the event being audited.
+type auditEntry struct {
+	actorID *uuid.UUID
+	target  string
+	details map[string]interface{}
+}
+
+// audited wraps next so that every request to it is recorded in the audit log
+// as action, like "video.delete", with the result taken from the response
+// status.
+//
+// The actor is the authenticated user, or whoever the handler names with
+// auditActor. The target defaults to the path value named after the part of
+// action before the last dot, so "video.delete" targets "video:{videoID}".
+// Without such a path value it's the actor's own account. Handlers can name
+// another with auditTarget.
+func (cfg *apiConfig) audited(action string, next http.Handler) http.Handler {
+	resource := action[:max(strings.LastIndex(action, "."), 0)]
+	if i := strings.LastIndex(resource, "."); i >= 0 {
+		resource = resource[i+1:]
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entry := &auditEntry{}
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		defer func() {
+			// Requests that blow up are recorded too, as failures.
+			if p := recover(); p != nil {
+				cfg.recordAuditEvent(r, action, resource, entry, http.StatusInternalServerError)
+				panic(p)
+			}
+		}()
+		next.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), auditContextKey{}, entry)))
+		cfg.recordAuditEvent(r, action, resource, entry, recorder.status)
+	})
+}
+
+func (cfg *apiConfig) recordAuditEvent(r *http.Request, action, resource string, entry *auditEntry, status int) {
+	target := entry.target
+	if target == "" && resource != "" && r.PathValue(resource+"ID") != "" {
+		target = resource + ":" + r.PathValue(resource+"ID")
+	}
+	if target == "" && entry.actorID != nil {
+		target = "user:" + entry.actorID.String()
+	}
+
+	params := database.CreateAuditEventParams{
+		ActorID:   entry.actorID,
+		Action:    action,
+		Target:    target,
+		IP:        clientIP(r),
+		UserAgent: r.UserAgent(),
+		Result:    auditResult(status),
+		Status:    status,
+	}
+	if len(entry.details) > 0 {
+		data, err := json.Marshal(entry.details)
+		if err != nil {
+			requestLogger(r).Error("Couldn't encode details of audit event", "action", action, "error", err)
+		}
+		params.Details = data
+	}
+
+	// The response has gone out, so failing to record it can only be logged.
+	err := cfg.db.CreateAuditEvent(params)
+	if err != nil {
+		requestLogger(r).Error("Couldn't record audit event", "action", action, "target", target, "error", err)
+	}
+}
+
+func auditResult(status int) string {
+	switch {
+	case status < 400:
+		return database.AuditResultSuccess
+	case status == http.StatusUnauthorized, status == http.StatusForbidden, status == http.StatusTooManyRequests:
+		return database.AuditResultDenied
+	default:
+		return database.AuditResultFailure
+	}
+}
+
+func requestAuditEntry(r *http.Request) *auditEntry {
+	entry, _ := r.Context().Value(auditContextKey{}).(*auditEntry)
+	return entry
+}
+
+// auditActor records who is acting in an audited request that isn't
+// authenticated with requireAuth, like a login.
+func auditActor(r *http.Request, userID uuid.UUID) {
+	if entry := requestAuditEntry(r); entry != nil {
+		entry.actorID = &userID
+	}
+}
+
+// auditTarget overrides what an audited request is recorded as acting on.
+func auditTarget(r *http.Request, target string) {
+	if entry := requestAuditEntry(r); entry != nil {
+		entry.target = target
+	}
+}
+
+// auditDetail adds a detail to the audit event for the request.
+func auditDetail(r *http.Request, key string, value interface{}) {
+	if entry := requestAuditEntry(r); entry != nil {
+		if entry.details == nil {
+			entry.details = map[string]interface{}{}
+		}
+		entry.details[key] = value
+	}
+}
+
+// statusRecorder remembers the status code written through it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func TestAudited(t *testing.T) {
+	cfg := newTestAPIConfig(t)
+	user := testUser(t, cfg, "alice@example.com")
+	videoID := uuid.New()
+
+	mux := http.NewServeMux()
+	mux.Handle("DELETE /api/videos/{videoID}", cfg.audited("video.delete", cfg.requireAuth(auth.ScopeVideosWrite, func(w http.ResponseWriter, r *http.Request) {
+		auditDetail(r, "title", "Demo")
+		w.WriteHeader(http.StatusNoContent)
+	})))
+	mux.Handle("POST /api/crash", cfg.audited("crash.run", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})))
+
+	req := httptest.NewRequest("DELETE", "/api/videos/"+videoID.String(), nil)
+	req.Header.Set("Authorization", "Bearer "+testAccessToken(t, cfg, user.ID, auth.UserScopes))
+	mux.ServeHTTP(httptest.NewRecorder(), req)
+	mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("DELETE", "/api/videos/"+videoID.String(), nil))
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("audited swallowed the handler's panic")
+			}
+		}()
+		mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/crash", nil))
+	}()
+
+	events, err := cfg.db.ListAuditEvents(database.ListAuditEventsParams{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("got %d events, want 3: %+v", len(events), events)
+	}
+	// Most recent first.
+	crashed, denied, deleted := events[0], events[1], events[2]
+	if deleted.ActorID == nil || *deleted.ActorID != user.ID ||
+		deleted.Target != "video:"+videoID.String() ||
+		deleted.Result != database.AuditResultSuccess || deleted.Status != http.StatusNoContent ||
+		string(deleted.Details) != `{"title":"Demo"}` {
+		t.Errorf("delete recorded as %+v", deleted)
+	}
+	if denied.ActorID != nil || denied.Result != database.AuditResultDenied || denied.Status != http.StatusUnauthorized {
+		t.Errorf("unauthenticated delete recorded as %+v", denied)
+	}
+	if crashed.Action != "crash.run" || crashed.Result != database.AuditResultFailure || crashed.Status != http.StatusInternalServerError {
+		t.Errorf("panic recorded as %+v", crashed)
+	}
+}
+
+// brokenWriter fails every write, like a connection the client dropped.
+type brokenWriter struct {
+	*httptest.ResponseRecorder
+}
+
+func (w brokenWriter) Write([]byte) (int, error) {
+	return 0, errors.New("connection reset by peer")
+}
+
+func TestAuditExportAbortsOnError(t *testing.T) {
+	cfg := newTestAPIConfig(t)
+	admin := testUser(t, cfg, "admin@example.com")
+	if err := cfg.db.SetUserRole(admin.ID, database.UserRoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	err := cfg.db.CreateAuditEvent(database.CreateAuditEventParams{Action: "video.delete", Result: database.AuditResultSuccess, Status: 204})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := cfg.audited("admin.audit_events.view", cfg.requireAuth(auth.ScopeAccount, requireAdmin(cfg.handlerAdminAuditEvents)))
+	req := httptest.NewRequest("GET", "/admin/audit_events?format=jsonl", nil)
+	req.Header.Set("Authorization", "Bearer "+testAccessToken(t, cfg, admin.ID, auth.UserScopes))
+	func() {
+		defer func() {
+			// The server closes the connection without logging a stack.
+			if p := recover(); p != http.ErrAbortHandler {
+				t.Errorf("export panicked with %v, want http.ErrAbortHandler", p)
+			}
+		}()
+		handler.ServeHTTP(brokenWriter{httptest.NewRecorder()}, req)
+	}()
+
+	events, err := cfg.db.ListAuditEvents(database.ListAuditEventsParams{Action: "admin.audit_events.view"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Result != database.AuditResultFailure ||
+		string(events[0].Details) != `{"error":"connection reset by peer","export":true}` {
+		t.Errorf("aborted export recorded as %+v", events)
+	}
+}
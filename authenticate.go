@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+type authContextKey struct{}
+
+// credentials are who a request is authenticated as and what it may do.
+type credentials struct {
+	User   database.User
+	Scopes []string
+}
+
+// requireAuth wraps next so that it only runs for requests authenticated with
+// a JWT ("Authorization: Bearer ...") or an API key ("Authorization: ApiKey
+// ...") granting scope. next can get the caller with requestUser.
+func (cfg *apiConfig) requireAuth(scope string, next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		creds, err := cfg.authenticatedUser(r)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Couldn't authenticate request", err)
+			return
+		}
+		auditActor(r, creds.User.ID)
+		logUser(r, creds.User.ID)
+		if !slices.Contains(creds.Scopes, scope) {
+			respondWithError(w, http.StatusForbidden, "Credentials don't allow this action", nil)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), authContextKey{}, creds)
+		next(w, r.WithContext(ctx))
+	})
+}
+
+// requireAdmin wraps a handler behind requireAuth so that it only runs for
+// admins.
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if requestUser(r).Role != database.UserRoleAdmin {
+			respondWithError(w, http.StatusForbidden, "Only admins can do that", nil)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// requireVerifiedEmail wraps a handler behind requireAuth so that it only runs
+// for users who have verified their email address.
+func requireVerifiedEmail(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if requestUser(r).EmailVerifiedAt == nil {
+			respondWithError(w, http.StatusForbidden, "Verify your email address first", nil)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// requestUser returns the user a request was authenticated as by requireAuth.
+func requestUser(r *http.Request) database.User {
+	creds, _ := r.Context().Value(authContextKey{}).(credentials)
+	return creds.User
+}
+
+// authenticatedUser identifies the user making the request. Handlers where
+// credentials are optional can call it directly instead of using requireAuth.
+func (cfg *apiConfig) authenticatedUser(r *http.Request) (credentials, error) {
+	var userID uuid.UUID
+	var scopes []string
+	if strings.HasPrefix(r.Header.Get("Authorization"), "ApiKey ") {
+		key, err := auth.GetAPIKey(r.Header)
+		if err != nil {
+			return credentials{}, err
+		}
+		userID, scopes, err = cfg.validateAPIKey(key)
+		if err != nil {
+			return credentials{}, err
+		}
+	} else {
+		token, err := auth.GetBearerToken(r.Header)
+		if err != nil {
+			return credentials{}, err
+		}
+		userID, scopes, err = auth.ValidateJWT(token, cfg.jwtKeys)
+		if err != nil {
+			return credentials{}, err
+		}
+	}
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		return credentials{}, err
+	}
+	if user == nil {
+		return credentials{}, errors.New("user no longer exists")
+	}
+	if user.DisabledAt != nil {
+		return credentials{}, errors.New("user is disabled")
+	}
+
+	return credentials{User: *user, Scopes: scopes}, nil
+}
+
+func (cfg *apiConfig) validateAPIKey(key string) (uuid.UUID, []string, error) {
+	apiKey, err := cfg.activeAPIKey(key)
+	if err != nil {
+		return uuid.Nil, nil, err
+	}
+
+	err = cfg.db.TouchAPIKey(apiKey.ID)
+	if err != nil {
+		return uuid.Nil, nil, err
+	}
+	return apiKey.UserID, apiKey.Scopes, nil
+}
+
+// activeAPIKey looks up key, failing unless it exists and is neither revoked
+// nor expired.
+func (cfg *apiConfig) activeAPIKey(key string) (database.APIKey, error) {
+	apiKey, err := cfg.db.GetAPIKeyByHash(auth.HashAPIKey(key))
+	if err != nil {
+		return database.APIKey{}, err
+	}
+	if apiKey.ID == uuid.Nil || apiKey.RevokedAt != nil {
+		return database.APIKey{}, errors.New("invalid API key")
+	}
+	if apiKey.ExpiresAt != nil && time.Now().UTC().After(*apiKey.ExpiresAt) {
+		return database.APIKey{}, errors.New("API key has expired")
+	}
+	return apiKey, nil
+}
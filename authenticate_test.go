@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+)
+
+func TestRequireAuth(t *testing.T) {
+	cfg := newTestAPIConfig(t)
+	user := testUser(t, cfg, "alice@example.com")
+	readOnly := testAccessToken(t, cfg, user.ID, []string{auth.ScopeVideosRead})
+	legacy := testAccessToken(t, cfg, user.ID, nil)
+	apiKey := testAPIKey(t, cfg, user.ID, auth.APIKeyScopes, nil)
+
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		if requestUser(r).ID != user.ID {
+			t.Errorf("requestUser = %v, want %v", requestUser(r).ID, user.ID)
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+
+	tests := []struct {
+		name          string
+		authorization string
+		scope         string
+		wantStatus    int
+	}{
+		{"no credentials", "", auth.ScopeVideosRead, http.StatusUnauthorized},
+		{"bad token", "Bearer nope", auth.ScopeVideosRead, http.StatusUnauthorized},
+		{"bad API key", "ApiKey tubely_nope", auth.ScopeVideosRead, http.StatusUnauthorized},
+		{"token with scope", "Bearer " + readOnly, auth.ScopeVideosRead, http.StatusOK},
+		{"token without scope", "Bearer " + readOnly, auth.ScopeVideosWrite, http.StatusForbidden},
+		{"token from before scopes", "Bearer " + legacy, auth.ScopeAccount, http.StatusOK},
+		{"API key with scope", "ApiKey " + apiKey, auth.ScopeVideosWrite, http.StatusOK},
+		{"API key managing the account", "ApiKey " + apiKey, auth.ScopeAccount, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			cfg.requireAuth(tt.scope, ok).ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+		})
+	}
+
+	// API keys can't be given the account scope either.
+	mux := http.NewServeMux()
+	mux.Handle("POST /api/api_keys", cfg.requireAuth(auth.ScopeAccount, cfg.handlerAPIKeyCreate))
+	req := httptest.NewRequest("POST", "/api/api_keys", strings.NewReader(`{"name":"ci","scopes":["account"]}`))
+	req.Header.Set("Authorization", "Bearer "+legacy)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("creating an API key with the account scope: status %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestValidateAPIKey(t *testing.T) {
+	cfg := newTestAPIConfig(t)
+	user := testUser(t, cfg, "alice@example.com")
+
+	req := httptest.NewRequest("POST", "/api/api_keys", strings.NewReader(`{"name":"ci","scopes":["videos:read"]}`))
+	req.Header.Set("Authorization", "Bearer "+testAccessToken(t, cfg, user.ID, nil))
+	rec := httptest.NewRecorder()
+	cfg.requireAuth(auth.ScopeAccount, cfg.handlerAPIKeyCreate).ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create: status %d: %s", rec.Code, rec.Body)
+	}
+	var created struct {
+		Key string `json:"key"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &created)
+	if !strings.HasPrefix(created.Key, "tubely_") {
+		t.Fatalf("created key %q", created.Key)
+	}
+	if got, _ := cfg.db.GetAPIKeyByHash(created.Key); got.UserID == user.ID {
+		t.Error("API key was stored in plain text")
+	}
+	stored, err := cfg.db.GetAPIKeyByHash(auth.HashAPIKey(created.Key))
+	if err != nil || stored.UserID != user.ID || stored.KeyPrefix != auth.APIKeyHint(created.Key) {
+		t.Fatalf("stored key = %+v, %v", stored, err)
+	}
+
+	userID, scopes, err := cfg.validateAPIKey(created.Key)
+	if err != nil || userID != user.ID || !slices.Equal(scopes, []string{auth.ScopeVideosRead}) {
+		t.Errorf("validateAPIKey = %v, %v, %v", userID, scopes, err)
+	}
+	if used, _ := cfg.db.GetAPIKey(stored.ID); used.LastUsedAt == nil {
+		t.Error("using the key didn't record when it was last used")
+	}
+
+	if err := cfg.db.RevokeAPIKey(stored.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := cfg.validateAPIKey(created.Key); err == nil {
+		t.Error("revoked key was accepted")
+	}
+
+	expiredAt := time.Now().UTC().Add(-time.Minute)
+	expired := testAPIKey(t, cfg, user.ID, auth.APIKeyScopes, &expiredAt)
+	if _, _, err := cfg.validateAPIKey(expired); err == nil {
+		t.Error("expired key was accepted")
+	}
+	if _, _, err := cfg.validateAPIKey("tubely_unknown"); err == nil {
+		t.Error("unknown key was accepted")
+	}
+}
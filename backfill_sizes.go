@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// runBackfillSizes implements `tubely backfill-sizes`, which records the size
+// of videos uploaded before sizes were, so they count towards storage quotas.
+// It looks each file up in S3 and is safe to run again, or while the server is
+// running. It reads the same settings as the server.
+func runBackfillSizes(args []string) error {
+	flags := flag.NewFlagSet("backfill-sizes", flag.ContinueOnError)
+	settings, err := loadCommandConfig(flags, args, os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		return errors.New("usage: tubely backfill-sizes [--config file] [--setting value ...]")
+	}
+
+	db, err := database.NewClient(settings.Database.Path)
+	if err != nil {
+		return fmt.Errorf("couldn't connect to database: %w", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	s3Cfg, err := awsconfig.LoadDefaultConfig(ctx, awsconfig.WithRegion(settings.S3.Region))
+	if err != nil {
+		return fmt.Errorf("couldn't create S3 config: %w", err)
+	}
+	s3Client := s3.NewFromConfig(s3Cfg)
+
+	cfg := &apiConfig{
+		db:               db,
+		s3Bucket:         settings.S3.Bucket,
+		s3CfDistribution: settings.S3.CFDistribution,
+	}
+	objectSize := func(ctx context.Context, key string) (int64, error) {
+		out, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket: &cfg.s3Bucket,
+			Key:    &key,
+		})
+		if err != nil {
+			return 0, err
+		}
+		return aws.ToInt64(out.ContentLength), nil
+	}
+
+	updated, skipped, err := cfg.backfillVideoSizes(ctx, objectSize)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Recorded the size of %d videos, skipped %d\n", updated, skipped)
+	return nil
+}
+
+// backfillVideoSizes records the size of every video with a file but no size,
+// using objectSize to look up the stored object. Videos whose files aren't in
+// the bucket, or can't be looked up, are logged and skipped.
+func (cfg *apiConfig) backfillVideoSizes(ctx context.Context, objectSize func(ctx context.Context, key string) (int64, error)) (updated, skipped int, err error) {
+	videos, err := cfg.db.GetVideosWithoutSize()
+	if err != nil {
+		return 0, 0, fmt.Errorf("couldn't list videos: %w", err)
+	}
+	for _, video := range videos {
+		key, ok := cfg.videoObjectKey(*video.VideoURL)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Skipping video %s: %s isn't in the bucket\n", video.ID, *video.VideoURL)
+			skipped++
+			continue
+		}
+		size, err := objectSize(ctx, key)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Skipping video %s: %v\n", video.ID, err)
+			skipped++
+			continue
+		}
+		err = cfg.db.SetVideoSize(video.ID, size)
+		if err != nil {
+			return updated, skipped, fmt.Errorf("couldn't record size of video %s: %w", video.ID, err)
+		}
+		updated++
+	}
+	return updated, skipped, nil
+}
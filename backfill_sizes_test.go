@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestBackfillVideoSizes(t *testing.T) {
+	cfg := newTestAPIConfig(t)
+	cfg.s3CfDistribution = "cdn.example.com"
+	owner := testUser(t, cfg, "owner@example.com")
+	newVideo := func(videoURL string, sizeBytes int64) database.Video {
+		t.Helper()
+		video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "Demo", UserID: owner.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if videoURL != "" {
+			if video, err = cfg.db.UpdateVideoFile(video.ID, videoURL, sizeBytes); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return video
+	}
+	old := newVideo("https://cdn.example.com/landscape/old.mp4", 0)
+	sized := newVideo("https://cdn.example.com/landscape/new.mp4", 50)
+	missing := newVideo("https://cdn.example.com/landscape/gone.mp4", 0)
+	elsewhere := newVideo("https://elsewhere.example.com/old.mp4", 0)
+	newVideo("", 0)
+
+	var looked []string
+	objectSize := func(ctx context.Context, key string) (int64, error) {
+		looked = append(looked, key)
+		if key == "landscape/gone.mp4" {
+			return 0, errors.New("not found")
+		}
+		return 1234, nil
+	}
+	updated, skipped, err := cfg.backfillVideoSizes(context.Background(), objectSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated != 1 || skipped != 2 {
+		t.Errorf("updated %d and skipped %d, want 1 and 2", updated, skipped)
+	}
+	if len(looked) != 2 {
+		t.Errorf("looked up %v, want only the videos in the bucket without a size", looked)
+	}
+
+	tests := []struct {
+		video database.Video
+		want  int64
+	}{
+		{old, 1234},
+		{sized, 50},
+		{missing, 0},
+		{elsewhere, 0},
+	}
+	for _, tt := range tests {
+		got, err := cfg.db.GetVideo(tt.video.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.SizeBytes != tt.want {
+			t.Errorf("%s: size %d, want %d", *tt.video.VideoURL, got.SizeBytes, tt.want)
+		}
+		if !got.UpdatedAt.Equal(tt.video.UpdatedAt) {
+			t.Errorf("%s: updated_at changed from %v to %v", *tt.video.VideoURL, tt.video.UpdatedAt, got.UpdatedAt)
+		}
+	}
+}
@@ -0,0 +1,437 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// config is how the server is set up. Settings are read from the YAML file
+// given with --config or TUBELY_CONFIG, then from environment variables, then
+// from command line flags, each overriding the ones before. Every setting has
+// an environment variable, named by its env tag, and a flag named after its
+// place in the file, like --s3.bucket.
+//
+// Settings are checked by the rule in their validate tag, see setting.check,
+// and the ones tagged secret are redacted when the config is printed.
+type config struct {
+	Server        serverSettings      `yaml:"server"`
+	Database      databaseSettings    `yaml:"database"`
+	S3            s3Settings          `yaml:"s3"`
+	Auth          authSettings        `yaml:"auth"`
+	Tokens        tokenSettings       `yaml:"tokens"`
+	OIDC          oidcSettings        `yaml:"oidc"`
+	Mail          mailSettings        `yaml:"mail"`
+	Uploads       uploadSettings      `yaml:"uploads"`
+	Quota         quotaSettings       `yaml:"quota"`
+	LoginThrottle loginThrottlePolicy `yaml:"login_throttle"`
+	RateLimits    rateLimitSettings   `yaml:"rate_limits"`
+	Logging       loggingSettings     `yaml:"logging"`
+	Metrics       metricsSettings     `yaml:"metrics"`
+	Tracing       tracingSettings     `yaml:"tracing"`
+}
+
+type serverSettings struct {
+	Port            string        `yaml:"port" env:"PORT" validate:"required"`
+	Platform        string        `yaml:"platform" env:"PLATFORM" validate:"required"`
+	FilepathRoot    string        `yaml:"filepath_root" env:"FILEPATH_ROOT" validate:"required"`
+	AssetsRoot      string        `yaml:"assets_root" env:"ASSETS_ROOT" validate:"required"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" validate:"positive"`
+	// ShutdownDelay is how long a stopped server keeps serving, failing
+	// /readyz, so load balancers stop sending it requests first.
+	ShutdownDelay time.Duration `yaml:"shutdown_delay" env:"SHUTDOWN_DELAY" validate:"nonnegative"`
+}
+
+type databaseSettings struct {
+	Path string `yaml:"path" env:"DB_PATH" validate:"required"`
+}
+
+type s3Settings struct {
+	Bucket         string `yaml:"bucket" env:"S3_BUCKET" validate:"required"`
+	Region         string `yaml:"region" env:"S3_REGION" validate:"required"`
+	CFDistribution string `yaml:"cf_distribution" env:"S3_CF_DISTRO" validate:"required"`
+}
+
+type authSettings struct {
+	JWTKeysDir string `yaml:"jwt_keys_dir" env:"JWT_KEYS_DIR" validate:"required"`
+	// JWTSecret is optional, it only keeps HS256 tokens issued before the
+	// switch to asymmetric keys working until they expire.
+	JWTSecret string `yaml:"jwt_secret" env:"JWT_SECRET" secret:"true"`
+}
+
+// tokenSettings are how long the tokens the server hands out last.
+type tokenSettings struct {
+	// AccessTTL is for access tokens from logging in, RefreshedAccessTTL for
+	// ones from refreshing a session.
+	AccessTTL             time.Duration `yaml:"access_ttl" env:"ACCESS_TOKEN_TTL" validate:"positive"`
+	RefreshedAccessTTL    time.Duration `yaml:"refreshed_access_ttl" env:"REFRESHED_ACCESS_TOKEN_TTL" validate:"positive"`
+	RefreshTTL            time.Duration `yaml:"refresh_ttl" env:"REFRESH_TOKEN_TTL" validate:"positive"`
+	EmailVerificationTTL  time.Duration `yaml:"email_verification_ttl" env:"EMAIL_VERIFICATION_TTL" validate:"positive"`
+	PasswordResetTTL      time.Duration `yaml:"password_reset_ttl" env:"PASSWORD_RESET_TTL" validate:"positive"`
+	TwoFactorChallengeTTL time.Duration `yaml:"two_factor_challenge_ttl" env:"TWO_FACTOR_CHALLENGE_TTL" validate:"positive"`
+	OIDCLoginTTL          time.Duration `yaml:"oidc_login_ttl" env:"OIDC_LOGIN_TTL" validate:"positive"`
+}
+
+// oidcSettings configure single sign-on, which is only enabled when Issuer
+// is set.
+type oidcSettings struct {
+	Issuer       string `yaml:"issuer" env:"OIDC_ISSUER"`
+	ClientID     string `yaml:"client_id" env:"OIDC_CLIENT_ID"`
+	ClientSecret string `yaml:"client_secret" env:"OIDC_CLIENT_SECRET" secret:"true"`
+	RedirectURL  string `yaml:"redirect_url" env:"OIDC_REDIRECT_URL"`
+}
+
+type mailSettings struct {
+	Mailer       string `yaml:"mailer" env:"MAILER" validate:"oneof=smtp file log"`
+	From         string `yaml:"from" env:"MAIL_FROM"`
+	File         string `yaml:"file" env:"MAIL_FILE"`
+	SMTPAddr     string `yaml:"smtp_addr" env:"SMTP_ADDR"`
+	SMTPUsername string `yaml:"smtp_username" env:"SMTP_USERNAME"`
+	SMTPPassword string `yaml:"smtp_password" env:"SMTP_PASSWORD" secret:"true"`
+}
+
+type uploadSettings struct {
+	MaxThumbnailBytes int64 `yaml:"max_thumbnail_bytes" env:"UPLOAD_MAX_THUMBNAIL_BYTES" validate:"positive"`
+}
+
+type metricsSettings struct {
+	// Token is the bearer token required to scrape /metrics, if set.
+	Token string `yaml:"token" env:"METRICS_TOKEN" secret:"true"`
+}
+
+func defaultConfig() config {
+	return config{
+		Server: serverSettings{
+			ShutdownTimeout: defaultShutdownTimeout,
+		},
+		Tokens: tokenSettings{
+			AccessTTL:             30 * 24 * time.Hour,
+			RefreshedAccessTTL:    time.Hour,
+			RefreshTTL:            60 * 24 * time.Hour,
+			EmailVerificationTTL:  48 * time.Hour,
+			PasswordResetTTL:      time.Hour,
+			TwoFactorChallengeTTL: 5 * time.Minute,
+			OIDCLoginTTL:          10 * time.Minute,
+		},
+		Mail: mailSettings{
+			Mailer: "log",
+			From:   "Tubely <no-reply@localhost>",
+		},
+		Uploads: uploadSettings{
+			MaxThumbnailBytes: 10 << 20,
+		},
+		Quota:         defaultQuotaSettings,
+		LoginThrottle: defaultLoginThrottlePolicy,
+		RateLimits:    defaultRateLimitSettings,
+		Logging: loggingSettings{
+			Level: "info",
+		},
+		Tracing: tracingSettings{
+			ServiceName: "tubely",
+		},
+	}
+}
+
+// errInvalidConfig is returned, wrapped, when settings were read but are
+// wrong, rather than not being readable at all.
+var errInvalidConfig = errors.New("invalid configuration")
+
+// loadConfig reads the config from the file, environment and command line
+// args, in the order they override each other. If anything is wrong with it,
+// the error lists every problem, and the config returned is as far as it got.
+func loadConfig(name string, args []string, getenv func(string) string) (config, error) {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	cfg, err := loadCommandConfig(flags, args, getenv)
+	if (err == nil || errors.Is(err, errInvalidConfig)) && flags.NArg() > 0 {
+		return cfg, fmt.Errorf("unexpected argument %q", flags.Arg(0))
+	}
+	return cfg, err
+}
+
+// loadCommandConfig is loadConfig for commands that take arguments of their
+// own. flags has the command's own flags defined on it, and the arguments
+// after the flags are left in flags.Args().
+func loadCommandConfig(flags *flag.FlagSet, args []string, getenv func(string) string) (config, error) {
+	cfg := defaultConfig()
+
+	configPath := flags.String("config", getenv("TUBELY_CONFIG"), "YAML `file` to read settings from, overrides TUBELY_CONFIG")
+	type flagValue struct{ name, value string }
+	flagValues := []flagValue{}
+	for _, s := range cfg.settings() {
+		flags.Func(s.path, "overrides "+s.env, func(value string) error {
+			flagValues = append(flagValues, flagValue{s.path, value})
+			return nil
+		})
+	}
+	if err := flags.Parse(args); err != nil {
+		return cfg, err
+	}
+
+	problems := []string{}
+	if *configPath != "" {
+		problems = append(problems, cfg.readFile(*configPath)...)
+	}
+	settings := map[string]setting{}
+	for _, s := range cfg.settings() {
+		settings[s.path] = s
+		if value := getenv(s.env); value != "" {
+			if err := s.set(value); err != nil {
+				problems = append(problems, s.env+" "+err.Error())
+			}
+		}
+	}
+	for _, f := range flagValues {
+		if err := settings[f.name].set(f.value); err != nil {
+			problems = append(problems, "--"+f.name+" "+err.Error())
+		}
+	}
+	problems = append(problems, cfg.validate()...)
+
+	if len(problems) > 0 {
+		return cfg, fmt.Errorf("%w: %s", errInvalidConfig, strings.Join(problems, "; "))
+	}
+	return cfg, nil
+}
+
+// readFile reads settings from a YAML file, returning what's wrong with it.
+// Settings it doesn't know about are mistakes, not ignored.
+func (c *config) readFile(path string) []string {
+	f, err := os.Open(path)
+	if err != nil {
+		return []string{fmt.Sprintf("couldn't read config file: %v", err)}
+	}
+	defer f.Close()
+
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+	err = decoder.Decode(c)
+	var typeErr *yaml.TypeError
+	switch {
+	case err == nil, errors.Is(err, io.EOF):
+		return nil
+	case errors.As(err, &typeErr):
+		problems := make([]string, len(typeErr.Errors))
+		for i, e := range typeErr.Errors {
+			problems[i] = path + ": " + e
+		}
+		return problems
+	default:
+		return []string{path + ": " + strings.TrimPrefix(err.Error(), "yaml: ")}
+	}
+}
+
+// validate returns every problem with the settings.
+func (c *config) validate() []string {
+	problems := []string{}
+	for _, s := range c.settings() {
+		if problem := s.check(); problem != "" {
+			problems = append(problems, problem)
+		}
+	}
+
+	if c.OIDC.Issuer != "" {
+		for _, path := range []string{"oidc.client_id", "oidc.redirect_url"} {
+			if s := c.setting(path); s.value.String() == "" {
+				problems = append(problems, s.label()+" must be set when oidc.issuer is")
+			}
+		}
+	}
+
+	switch c.Mail.Mailer {
+	case "smtp":
+		if c.Mail.SMTPAddr == "" {
+			problems = append(problems, c.setting("mail.smtp_addr").label()+" must be set for the smtp mailer")
+		}
+	case "file":
+		if c.Mail.File == "" {
+			problems = append(problems, c.setting("mail.file").label()+" must be set for the file mailer")
+		}
+	}
+	// The log and file mailers never deliver anything, so outside development
+	// users would never get their verification or password reset emails.
+	if c.Mail.Mailer != "smtp" && c.Server.Platform != "dev" {
+		problems = append(problems, c.setting("mail.mailer").label()+` must be smtp unless server.platform is "dev"`)
+	}
+	return problems
+}
+
+// setting is one configurable value.
+type setting struct {
+	// path is where the setting goes in the config file, like "s3.bucket".
+	path   string
+	env    string
+	rule   string
+	secret bool
+	value  reflect.Value
+}
+
+// settings lists every setting in c, in the order they appear in the file.
+func (c *config) settings() []setting {
+	settings := []setting{}
+	sections := reflect.ValueOf(c).Elem()
+	for i := 0; i < sections.NumField(); i++ {
+		section := sections.Field(i)
+		sectionName := sections.Type().Field(i).Tag.Get("yaml")
+		for j := 0; j < section.NumField(); j++ {
+			field := section.Type().Field(j)
+			settings = append(settings, setting{
+				path:   sectionName + "." + field.Tag.Get("yaml"),
+				env:    field.Tag.Get("env"),
+				rule:   field.Tag.Get("validate"),
+				secret: field.Tag.Get("secret") == "true",
+				value:  section.Field(j),
+			})
+		}
+	}
+	return settings
+}
+
+// setting returns the setting at path, which must exist.
+func (c *config) setting(path string) setting {
+	for _, s := range c.settings() {
+		if s.path == path {
+			return s
+		}
+	}
+	panic("no setting " + path)
+}
+
+// label names the setting in problems, by both ways of setting it.
+func (s setting) label() string {
+	return fmt.Sprintf("%s (%s)", s.path, s.env)
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// set parses value into the setting. The error describes what the value
+// should look like.
+func (s setting) set(value string) error {
+	switch {
+	case s.value.Type() == durationType:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return errors.New(`must be a duration like "30s"`)
+		}
+		s.value.SetInt(int64(d))
+	case s.value.Kind() == reflect.String:
+		s.value.SetString(value)
+	case s.value.Kind() == reflect.Int || s.value.Kind() == reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, s.value.Type().Bits())
+		if err != nil {
+			return errors.New("must be an integer")
+		}
+		s.value.SetInt(n)
+	default:
+		panic("can't set " + s.path + " of type " + s.value.Type().String())
+	}
+	return nil
+}
+
+// check applies the setting's validate rule, returning the problem if it's
+// broken.
+func (s setting) check() string {
+	switch s.rule {
+	case "":
+		return ""
+	case "required":
+		if s.value.String() == "" {
+			return s.label() + " must be set"
+		}
+	case "positive":
+		if s.value.Int() <= 0 {
+			return s.label() + " must be positive"
+		}
+	case "nonnegative":
+		if s.value.Int() < 0 {
+			return s.label() + " must not be negative"
+		}
+	case "rate_limit":
+		if _, err := parseRateLimit(s.value.String()); err != nil {
+			return s.label() + ` must be "off" or requests per period like "10/1m"`
+		}
+	case "log_level":
+		if _, err := parseLogLevel(s.value.String()); err != nil {
+			return s.label() + " must be debug, info, warn or error"
+		}
+	case "headers":
+		if _, err := parseOTLPHeaders(s.value.String()); err != nil {
+			return s.label() + ` must be headers like "name=value,name=value"`
+		}
+	default:
+		choices, ok := strings.CutPrefix(s.rule, "oneof=")
+		if !ok {
+			panic("unknown rule " + s.rule + " for " + s.path)
+		}
+		if !slices.Contains(strings.Fields(choices), s.value.String()) {
+			return s.label() + " must be one of " + strings.Join(strings.Fields(choices), ", ")
+		}
+	}
+	return ""
+}
+
+// redactedValue is printed in place of secrets that are set.
+const redactedValue = "[redacted]"
+
+// print writes c as a config file, with secrets redacted and each setting's
+// environment variable in a comment.
+func (c *config) print(w io.Writer) error {
+	doc := &yaml.Node{Kind: yaml.MappingNode}
+	var section *yaml.Node
+	lastSection := ""
+	for _, s := range c.settings() {
+		sectionName, key, _ := strings.Cut(s.path, ".")
+		if sectionName != lastSection {
+			section = &yaml.Node{Kind: yaml.MappingNode}
+			doc.Content = append(doc.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: sectionName}, section)
+			lastSection = sectionName
+		}
+
+		value := &yaml.Node{Kind: yaml.ScalarNode, LineComment: s.env}
+		switch {
+		case s.secret && !s.value.IsZero():
+			value.Tag, value.Value = "!!str", redactedValue
+		case s.value.Type() == durationType:
+			value.Tag, value.Value = "!!str", time.Duration(s.value.Int()).String()
+		case s.value.Kind() == reflect.String:
+			value.Tag, value.Value = "!!str", s.value.String()
+		default:
+			value.Tag, value.Value = "!!int", strconv.FormatInt(s.value.Int(), 10)
+		}
+		section.Content = append(section.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, value)
+	}
+
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(doc); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// runConfig implements `tubely config print`, which shows the config the
+// server would run with and any problems with it.
+func runConfig(args []string) error {
+	if len(args) == 0 || args[0] != "print" {
+		return errors.New("usage: tubely config print [--config file] [--setting value ...]")
+	}
+	cfg, err := loadConfig("config print", args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
+	}
+	if err != nil && !errors.Is(err, errInvalidConfig) {
+		return err
+	}
+	if printErr := cfg.print(os.Stdout); printErr != nil {
+		return printErr
+	}
+	return err
+}
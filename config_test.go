@@ -0,0 +1,176 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// requiredEnv sets every required setting, so tests only need to give the
+// ones they're about.
+var requiredEnv = map[string]string{
+	"PORT":          "8091",
+	"PLATFORM":      "dev",
+	"FILEPATH_ROOT": "./app",
+	"ASSETS_ROOT":   "./assets",
+	"DB_PATH":       "tubely.db",
+	"S3_BUCKET":     "tubely",
+	"S3_REGION":     "us-east-1",
+	"S3_CF_DISTRO":  "cdn.example.com",
+	"JWT_KEYS_DIR":  "./keys",
+}
+
+func testGetenv(env map[string]string) func(string) string {
+	return func(name string) string {
+		if value, ok := env[name]; ok {
+			return value
+		}
+		return requiredEnv[name]
+	}
+}
+
+func writeConfigFile(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "tubely.yaml")
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := writeConfigFile(t, `
+server:
+  port: "8000"
+  shutdown_timeout: 45s
+tokens:
+  access_ttl: 2h
+rate_limits:
+  auth: 5/1m
+`)
+	tests := []struct {
+		name     string
+		args     []string
+		env      map[string]string
+		wantPort string
+	}{
+		{"file", []string{"--config", path}, map[string]string{"PORT": ""}, "8000"},
+		{"file from env", nil, map[string]string{"TUBELY_CONFIG": path, "PORT": ""}, "8000"},
+		{"env over file", []string{"--config", path}, map[string]string{"PORT": "8001"}, "8001"},
+		{"flag over env", []string{"--config", path, "--server.port", "8002"}, map[string]string{"PORT": "8001"}, "8002"},
+	}
+	for _, tt := range tests {
+		cfg, err := loadConfig("tubely", tt.args, testGetenv(tt.env))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if cfg.Server.Port != tt.wantPort {
+			t.Errorf("%s: port %q, want %q", tt.name, cfg.Server.Port, tt.wantPort)
+		}
+		if cfg.Server.ShutdownTimeout != 45*time.Second || cfg.Tokens.AccessTTL != 2*time.Hour {
+			t.Errorf("%s: durations from the file not read, got %+v", tt.name, cfg)
+		}
+		if cfg.RateLimits.Auth != "5/1m" || cfg.RateLimits.Upload != defaultRateLimitSettings.Upload {
+			t.Errorf("%s: rate limits %+v", tt.name, cfg.RateLimits)
+		}
+	}
+}
+
+func TestLoadConfigReportsEveryProblem(t *testing.T) {
+	path := writeConfigFile(t, `
+server:
+  shutdown_timeout: soon
+quota:
+  max_videos: -1
+mail:
+  mailer: smtp
+`)
+	env := map[string]string{
+		"S3_BUCKET":        "",
+		"LOG_LEVEL":        "loud",
+		"RATE_LIMIT_API":   "lots",
+		"QUOTA_MAX_VIDEOS": "",
+		"SHUTDOWN_DELAY":   "-5s",
+	}
+	_, err := loadConfig("tubely", []string{"--config", path, "--tokens.refresh_ttl", "60"}, testGetenv(env))
+	if err == nil {
+		t.Fatal("loadConfig succeeded")
+	}
+	for _, want := range []string{
+		"line 3: cannot unmarshal",
+		"quota.max_videos (QUOTA_MAX_VIDEOS) must not be negative",
+		"server.shutdown_delay (SHUTDOWN_DELAY) must not be negative",
+		"mail.smtp_addr (SMTP_ADDR) must be set for the smtp mailer",
+		"s3.bucket (S3_BUCKET) must be set",
+		"logging.level (LOG_LEVEL) must be debug, info, warn or error",
+		`rate_limits.api (RATE_LIMIT_API) must be "off" or requests per period`,
+		`--tokens.refresh_ttl must be a duration`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error doesn't mention %q: %v", want, err)
+		}
+	}
+}
+
+func TestLoadConfigRequiresSMTPOutsideDev(t *testing.T) {
+	tests := []struct {
+		platform string
+		mailer   string
+		wantErr  bool
+	}{
+		{"dev", "log", false},
+		{"dev", "file", false},
+		{"prod", "log", true},
+		{"prod", "file", true},
+		{"prod", "smtp", false},
+	}
+	for _, tt := range tests {
+		env := map[string]string{
+			"PLATFORM":  tt.platform,
+			"MAILER":    tt.mailer,
+			"MAIL_FILE": "mail.txt",
+			"SMTP_ADDR": "smtp.example.com:587",
+		}
+		_, err := loadConfig("tubely", nil, testGetenv(env))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s with the %s mailer: got error %v, want error %v", tt.platform, tt.mailer, err, tt.wantErr)
+		}
+		if err != nil && !strings.Contains(err.Error(), "mail.mailer (MAILER) must be smtp") {
+			t.Errorf("%s with the %s mailer: error doesn't mention the mailer: %v", tt.platform, tt.mailer, err)
+		}
+	}
+}
+
+func TestConfigPrintRedactsSecrets(t *testing.T) {
+	env := map[string]string{
+		"JWT_SECRET":                 "hunter2",
+		"OTEL_EXPORTER_OTLP_HEADERS": "authorization=Bearer abc123",
+	}
+	cfg, err := loadConfig("tubely", nil, testGetenv(env))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	if err := cfg.print(&out); err != nil {
+		t.Fatal(err)
+	}
+	printed := out.String()
+	for _, secret := range []string{"hunter2", "abc123"} {
+		if strings.Contains(printed, secret) {
+			t.Errorf("printed config contains secret %q:\n%s", secret, printed)
+		}
+	}
+	for _, want := range []string{
+		"jwt_secret: '[redacted]' # JWT_SECRET",
+		"smtp_password: \"\" # SMTP_PASSWORD",
+		"access_ttl: 720h0m0s # ACCESS_TOKEN_TTL",
+		"port: \"8091\" # PORT",
+	} {
+		if !strings.Contains(printed, want) {
+			t.Errorf("printed config doesn't contain %q:\n%s", want, printed)
+		}
+	}
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+func (cfg *apiConfig) handlerAdminUsersList(w http.ResponseWriter, r *http.Request) {
+	limit, offset, err := pageParams(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	search := r.URL.Query().Get("q")
+
+	users, err := cfg.db.SearchUsers(database.SearchUsersParams{
+		Query:  search,
+		Limit:  limit,
+		Offset: offset,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve users", err)
+		return
+	}
+
+	auditTarget(r, "users")
+	auditDetail(r, "q", search)
+	respondWithJSON(w, http.StatusOK, users)
+}
+
+func (cfg *apiConfig) handlerAdminUserGet(w http.ResponseWriter, r *http.Request) {
+	user, ok := cfg.userFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	usage, err := cfg.db.GetUserStorageUsage(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve storage usage", err)
+		return
+	}
+	overrides, err := cfg.db.GetUserQuota(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve quota", err)
+		return
+	}
+	memberships, err := cfg.db.GetOrgsForUser(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve organizations", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, struct {
+		database.User
+		Usage          database.StorageUsage    `json:"usage"`
+		Quota          quota                    `json:"quota"`
+		QuotaOverrides database.UserQuota       `json:"quota_overrides"`
+		Orgs           []database.OrgMembership `json:"orgs"`
+	}{
+		User:           user,
+		Usage:          usage,
+		Quota:          cfg.defaultQuota.withOverrides(overrides),
+		QuotaOverrides: overrides,
+		Orgs:           memberships,
+	})
+}
+
+// handlerAdminUserUpdate changes a user's role, disables their account or
+// replaces their quota overrides. Admins can't change their own role or
+// disable themselves, so there's always an admin left.
+func (cfg *apiConfig) handlerAdminUserUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Role     *string             `json:"role"`
+		Disabled *bool               `json:"disabled"`
+		Quota    *database.UserQuota `json:"quota"`
+	}
+
+	user, ok := cfg.userFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Role != nil {
+		auditDetail(r, "role", *params.Role)
+	}
+	if params.Disabled != nil {
+		auditDetail(r, "disabled", *params.Disabled)
+	}
+	if params.Quota != nil {
+		auditDetail(r, "quota", params.Quota)
+	}
+	if params.Role == nil && params.Disabled == nil && params.Quota == nil {
+		respondWithError(w, http.StatusBadRequest, "Nothing to change, give a role, disabled or quota", nil)
+		return
+	}
+	if params.Role != nil && *params.Role != database.UserRoleUser && *params.Role != database.UserRoleAdmin {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Role must be %q or %q", database.UserRoleUser, database.UserRoleAdmin), nil)
+		return
+	}
+	if params.Quota != nil && !validUserQuota(*params.Quota) {
+		respondWithError(w, http.StatusBadRequest, "Quotas can't be negative", nil)
+		return
+	}
+	if user.ID == requestUser(r).ID && (params.Role != nil || params.Disabled != nil) {
+		respondWithError(w, http.StatusBadRequest, "You can't change your own role or disable yourself", nil)
+		return
+	}
+
+	if params.Role != nil {
+		err = cfg.db.SetUserRole(user.ID, *params.Role)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update role", err)
+			return
+		}
+	}
+	if params.Disabled != nil {
+		err = cfg.db.SetUserDisabled(user.ID, *params.Disabled)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update account", err)
+			return
+		}
+	}
+	if params.Quota != nil {
+		err = cfg.db.SetUserQuota(user.ID, *params.Quota)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update quota", err)
+			return
+		}
+	}
+
+	updated, err := cfg.db.GetUser(user.ID)
+	if err != nil || updated == nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve user", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, updated)
+}
+
+// handlerAdminOrgUpdate sets an organization's quotas. Org admins can't change
+// them, since they'd just raise their own limits.
+func (cfg *apiConfig) handlerAdminOrgUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		MaxVideos       *int   `json:"max_videos"`
+		MaxStorageBytes *int64 `json:"max_storage_bytes"`
+	}
+
+	orgID, err := uuid.Parse(r.PathValue("orgID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid organization ID", err)
+		return
+	}
+	org, err := cfg.db.GetOrg(orgID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve organization", err)
+		return
+	}
+	if org.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Organization not found", nil)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.MaxVideos == nil && params.MaxStorageBytes == nil {
+		respondWithError(w, http.StatusBadRequest, "Nothing to change, give max_videos or max_storage_bytes", nil)
+		return
+	}
+	if params.MaxVideos != nil {
+		auditDetail(r, "max_videos", *params.MaxVideos)
+		if *params.MaxVideos < 0 {
+			respondWithError(w, http.StatusBadRequest, "Quotas can't be negative", nil)
+			return
+		}
+		org.MaxVideos = *params.MaxVideos
+	}
+	if params.MaxStorageBytes != nil {
+		auditDetail(r, "max_storage_bytes", *params.MaxStorageBytes)
+		if *params.MaxStorageBytes < 0 {
+			respondWithError(w, http.StatusBadRequest, "Quotas can't be negative", nil)
+			return
+		}
+		org.MaxStorageBytes = *params.MaxStorageBytes
+	}
+
+	org, err = cfg.db.UpdateOrg(org)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update organization", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, org)
+}
+
+// handlerAdminVideoGet shows any video, whoever it belongs to.
+func (cfg *apiConfig) handlerAdminVideoGet(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.videoFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+// handlerAdminVideoDelete deletes any video along with its files, for taking
+// down content that breaks the rules.
+func (cfg *apiConfig) handlerAdminVideoDelete(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.videoFromPath(w, r)
+	if !ok {
+		return
+	}
+	auditDetail(r, "title", video.Title)
+	auditDetail(r, "user_id", video.UserID)
+	auditDetail(r, "org_id", video.OrgID)
+
+	err := cfg.deleteVideoFiles(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, "Couldn't delete video files, try again", err)
+		return
+	}
+	err = cfg.db.DeleteVideo(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete video", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerAdminStorage reports how much space videos take up in total and
+// which users take up the most.
+func (cfg *apiConfig) handlerAdminStorage(w http.ResponseWriter, r *http.Request) {
+	limit, _, err := pageParams(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	total, err := cfg.db.GetStorageUsage()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve storage usage", err)
+		return
+	}
+	topUsers, err := cfg.db.GetTopStorageUsers(limit)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve storage usage", err)
+		return
+	}
+
+	auditTarget(r, "storage")
+	respondWithJSON(w, http.StatusOK, struct {
+		Total    database.StorageUsage       `json:"total"`
+		TopUsers []database.UserStorageUsage `json:"top_users"`
+	}{
+		Total:    total,
+		TopUsers: topUsers,
+	})
+}
+
+func validUserQuota(q database.UserQuota) bool {
+	return (q.MaxVideos == nil || *q.MaxVideos >= 0) &&
+		(q.MaxStorageBytes == nil || *q.MaxStorageBytes >= 0) &&
+		(q.MaxFileBytes == nil || *q.MaxFileBytes >= 0) &&
+		(q.MaxDurationSeconds == nil || *q.MaxDurationSeconds >= 0)
+}
+
+// userFromPath loads the user named in the path. It writes the error response
+// itself and returns false if the handler should stop.
+func (cfg *apiConfig) userFromPath(w http.ResponseWriter, r *http.Request) (database.User, bool) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return database.User{}, false
+	}
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve user", err)
+		return database.User{}, false
+	}
+	if user == nil {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return database.User{}, false
+	}
+	return *user, true
+}
+
+// videoFromPath loads the video named in the path without checking who may
+// see it. It writes the error response itself and returns false if the
+// handler should stop.
+func (cfg *apiConfig) videoFromPath(w http.ResponseWriter, r *http.Request) (database.Video, bool) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return database.Video{}, false
+	}
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return database.Video{}, false
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return database.Video{}, false
+	}
+	return video, true
+}
+
+// pageParams reads the limit and offset query parameters of a list endpoint.
+func pageParams(r *http.Request) (limit, offset int, err error) {
+	query := r.URL.Query()
+	limit = defaultPageSize
+	if value := query.Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			return 0, 0, fmt.Errorf("Limit must be between 1 and %d", maxPageSize)
+		}
+	}
+	if value := query.Get("offset"); value != "" {
+		offset, err = strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			return 0, 0, errors.New("Offset must be a non-negative integer")
+		}
+	}
+	return limit, offset, nil
+}
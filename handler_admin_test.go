@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestAdminAPI(t *testing.T) {
+	cfg := newTestAPIConfig(t)
+	admin := testUser(t, cfg, "admin@example.com")
+	if err := cfg.db.SetUserRole(admin.ID, database.UserRoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	user := testUser(t, cfg, "user@example.com")
+	adminToken := testAccessToken(t, cfg, admin.ID, auth.UserScopes)
+	userToken := testAccessToken(t, cfg, user.ID, auth.UserScopes)
+	userKey := testAPIKey(t, cfg, user.ID, auth.APIKeyScopes, nil)
+	userRefreshToken := testRefreshToken(t, cfg, user.ID, time.Now().UTC().Add(time.Hour))
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /admin/users", cfg.requireAuth(auth.ScopeAccount, requireAdmin(cfg.handlerAdminUsersList)))
+	mux.Handle("PATCH /admin/users/{userID}", cfg.requireAuth(auth.ScopeAccount, requireAdmin(cfg.handlerAdminUserUpdate)))
+	mux.Handle("GET /api/videos", cfg.requireAuth(auth.ScopeVideosRead, cfg.handlerVideosRetrieve))
+	mux.HandleFunc("POST /api/refresh", cfg.handlerRefresh)
+	do := func(method, path, authorization, body string) int {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", authorization)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	tests := []struct {
+		name          string
+		method, path  string
+		authorization string
+		body          string
+		want          int
+	}{
+		{"admin lists users", "GET", "/admin/users", "Bearer " + adminToken, "", http.StatusOK},
+		{"user lists users", "GET", "/admin/users", "Bearer " + userToken, "", http.StatusForbidden},
+		{"user disables admin", "PATCH", "/admin/users/" + admin.ID.String(), "Bearer " + userToken, `{"disabled": true}`, http.StatusForbidden},
+		{"admin disables self", "PATCH", "/admin/users/" + admin.ID.String(), "Bearer " + adminToken, `{"disabled": true}`, http.StatusBadRequest},
+		{"admin demotes self", "PATCH", "/admin/users/" + admin.ID.String(), "Bearer " + adminToken, `{"role": "user"}`, http.StatusBadRequest},
+		{"access token before disabling", "GET", "/api/videos", "Bearer " + userToken, "", http.StatusOK},
+		{"API key before disabling", "GET", "/api/videos", "ApiKey " + userKey, "", http.StatusOK},
+		{"admin disables user", "PATCH", "/admin/users/" + user.ID.String(), "Bearer " + adminToken, `{"disabled": true}`, http.StatusOK},
+		{"access token after disabling", "GET", "/api/videos", "Bearer " + userToken, "", http.StatusUnauthorized},
+		{"API key after disabling", "GET", "/api/videos", "ApiKey " + userKey, "", http.StatusUnauthorized},
+		{"refresh token after disabling", "POST", "/api/refresh", "Bearer " + userRefreshToken, "", http.StatusUnauthorized},
+		{"admin still works", "GET", "/admin/users", "Bearer " + adminToken, "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		if got := do(tt.method, tt.path, tt.authorization, tt.body); got != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	updated, err := cfg.db.GetUser(admin.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Role != database.UserRoleAdmin || updated.DisabledAt != nil {
+		t.Errorf("admin changed their own account: %+v", updated)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const maxAPIKeyNameLength = 100
+
+func (cfg *apiConfig) handlerAPIKeyCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name      string     `json:"name"`
+		Scopes    []string   `json:"scopes"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	type response struct {
+		database.APIKey
+		// Key is only ever returned here, we only keep its hash.
+		Key string `json:"key"`
+	}
+
+	userID := requestUser(r).ID
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	params.Name = strings.TrimSpace(params.Name)
+	if params.Name == "" {
+		respondWithError(w, http.StatusBadRequest, "Name is required", nil)
+		return
+	}
+	if utf8.RuneCountInString(params.Name) > maxAPIKeyNameLength {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Name must be at most %d characters", maxAPIKeyNameLength), nil)
+		return
+	}
+	if len(params.Scopes) == 0 {
+		params.Scopes = auth.APIKeyScopes
+	}
+	for _, scope := range params.Scopes {
+		if !slices.Contains(auth.APIKeyScopes, scope) {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Unknown scope %q, API keys can have %s", scope, strings.Join(auth.APIKeyScopes, ", ")), nil)
+			return
+		}
+	}
+	if params.ExpiresAt != nil && params.ExpiresAt.Before(time.Now()) {
+		respondWithError(w, http.StatusBadRequest, "Expiry must be in the future", nil)
+		return
+	}
+
+	key, err := auth.MakeAPIKey()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create API key", err)
+		return
+	}
+
+	var expiresAt *time.Time
+	if params.ExpiresAt != nil {
+		utc := params.ExpiresAt.UTC()
+		expiresAt = &utc
+	}
+
+	apiKey, err := cfg.db.CreateAPIKey(database.CreateAPIKeyParams{
+		UserID:    userID,
+		Name:      params.Name,
+		KeyHash:   auth.HashAPIKey(key),
+		KeyPrefix: auth.APIKeyHint(key),
+		Scopes:    params.Scopes,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save API key", err)
+		return
+	}
+	auditTarget(r, "api_key:"+apiKey.ID.String())
+	auditDetail(r, "scopes", apiKey.Scopes)
+
+	respondWithJSON(w, http.StatusCreated, response{
+		APIKey: apiKey,
+		Key:    key,
+	})
+}
+
+func (cfg *apiConfig) handlerAPIKeysList(w http.ResponseWriter, r *http.Request) {
+	userID := requestUser(r).ID
+
+	keys, err := cfg.db.GetAPIKeys(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve API keys", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, keys)
+}
+
+func (cfg *apiConfig) handlerAPIKeyRevoke(w http.ResponseWriter, r *http.Request) {
+	keyID, err := uuid.Parse(r.PathValue("keyID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid API key ID", err)
+		return
+	}
+	auditTarget(r, "api_key:"+keyID.String())
+
+	userID := requestUser(r).ID
+
+	apiKey, err := cfg.db.GetAPIKey(keyID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get API key", err)
+		return
+	}
+	if apiKey.ID == uuid.Nil || apiKey.UserID != userID {
+		respondWithError(w, http.StatusNotFound, "API key not found", nil)
+		return
+	}
+
+	err = cfg.db.RevokeAPIKey(keyID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke API key", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// handlerAdminAuditEvents searches the audit log, most recent events first.
+// With format=jsonl it exports every matching event as JSON Lines instead of
+// a page of them.
+func (cfg *apiConfig) handlerAdminAuditEvents(w http.ResponseWriter, r *http.Request) {
+	params, err := auditEventFilters(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+		params.Limit, params.Offset, err = pageParams(r)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error(), err)
+			return
+		}
+		events, err := cfg.db.ListAuditEvents(params)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve audit events", err)
+			return
+		}
+		respondWithJSON(w, http.StatusOK, events)
+
+	case "jsonl":
+		if r.URL.Query().Has("limit") || r.URL.Query().Has("offset") {
+			params.Limit, params.Offset, err = pageParams(r)
+			if err != nil {
+				respondWithError(w, http.StatusBadRequest, err.Error(), err)
+				return
+			}
+		}
+
+		auditDetail(r, "export", true)
+		w.Header().Set("Content-Type", "application/jsonl")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="audit-%s.jsonl"`, time.Now().UTC().Format("20060102T150405Z")))
+		encoder := json.NewEncoder(w)
+		err = cfg.db.EachAuditEvent(params, func(e database.AuditEvent) error {
+			return encoder.Encode(e)
+		})
+		if err != nil {
+			// The status has gone out with the first line, all we can do is
+			// break off the response so the client can tell it's incomplete.
+			requestLogger(r).Error("Couldn't export audit events", "error", err)
+			auditDetail(r, "error", err.Error())
+			panic(http.ErrAbortHandler)
+		}
+
+	default:
+		respondWithError(w, http.StatusBadRequest, `Format must be "json" or "jsonl"`, nil)
+	}
+}
+
+// auditEventFilters reads the audit log filters from the query string.
+func auditEventFilters(r *http.Request) (database.ListAuditEventsParams, error) {
+	query := r.URL.Query()
+	params := database.ListAuditEventsParams{
+		Action: query.Get("action"),
+		Target: query.Get("target"),
+		Result: query.Get("result"),
+	}
+
+	if actor := query.Get("actor"); actor != "" {
+		actorID, err := uuid.Parse(actor)
+		if err != nil {
+			return database.ListAuditEventsParams{}, errors.New("Actor must be a user ID")
+		}
+		params.ActorID = &actorID
+	}
+
+	switch params.Result {
+	case "", database.AuditResultSuccess, database.AuditResultDenied, database.AuditResultFailure:
+	default:
+		return database.ListAuditEventsParams{}, fmt.Errorf("Result must be %q, %q or %q",
+			database.AuditResultSuccess, database.AuditResultDenied, database.AuditResultFailure)
+	}
+
+	for _, bound := range []struct {
+		name string
+		dst  *time.Time
+	}{
+		{"since", &params.Since},
+		{"until", &params.Until},
+	} {
+		if value := query.Get(bound.name); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return database.ListAuditEventsParams{}, fmt.Errorf("%s must be a time like 2006-01-02T15:04:05Z", strings.ToUpper(bound.name[:1])+bound.name[1:])
+			}
+			*bound.dst = t
+		}
+	}
+
+	return params, nil
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+var videoRoleRank = map[string]int{
+	database.VideoRoleViewer: 1,
+	database.VideoRoleEditor: 2,
+	database.VideoRoleOwner:  3,
+}
+
+// videoRole returns the role userID holds on video, or "" if they have none.
+// Personal videos are owned by their creator. Videos in an organization's
+// library are owned by its admins and editable by its members instead. On top
+// of that, a video can be shared with collaborators.
+func (cfg *apiConfig) videoRole(video database.Video, userID uuid.UUID) (string, error) {
+	if video.OrgID == nil && video.UserID == userID {
+		return database.VideoRoleOwner, nil
+	}
+
+	role := ""
+	if video.OrgID != nil {
+		member, err := cfg.db.GetOrgMember(*video.OrgID, userID)
+		if err != nil {
+			return "", err
+		}
+		switch member.Role {
+		case database.OrgRoleAdmin:
+			role = database.VideoRoleOwner
+		case database.OrgRoleMember:
+			role = database.VideoRoleEditor
+		}
+	}
+
+	collaborator, err := cfg.db.GetVideoCollaborator(video.ID, userID)
+	if err != nil {
+		return "", err
+	}
+	if videoRoleRank[collaborator.Role] > videoRoleRank[role] {
+		role = collaborator.Role
+	}
+	return role, nil
+}
+
+// hasVideoRole reports whether role is at least as privileged as required.
+func hasVideoRole(role, required string) bool {
+	return role != "" && videoRoleRank[role] >= videoRoleRank[required]
+}
+
+func (cfg *apiConfig) handlerVideoCollaboratorsList(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	userID := requestUser(r).ID
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	role, err := cfg.videoRole(video, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check video permissions", err)
+		return
+	}
+	if !hasVideoRole(role, database.VideoRoleViewer) {
+		respondWithError(w, http.StatusForbidden, "You don't have access to this video", nil)
+		return
+	}
+
+	collaborators, err := cfg.db.GetVideoCollaborators(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve collaborators", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, collaborators)
+}
+
+func (cfg *apiConfig) handlerVideoCollaboratorInvite(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string `json:"email"`
+		Role  string `json:"role"`
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	userID := requestUser(r).ID
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	auditDetail(r, "email", params.Email)
+	auditDetail(r, "role", params.Role)
+	if _, ok := videoRoleRank[params.Role]; !ok {
+		respondWithError(w, http.StatusBadRequest, "Role must be one of viewer, editor or owner", nil)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	role, err := cfg.videoRole(video, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check video permissions", err)
+		return
+	}
+	if !hasVideoRole(role, database.VideoRoleOwner) {
+		respondWithError(w, http.StatusForbidden, "Only owners can share this video", nil)
+		return
+	}
+
+	invitee, err := cfg.db.GetUserByEmail(strings.TrimSpace(params.Email))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't look up user", err)
+		return
+	}
+	if invitee.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "No user with that email", nil)
+		return
+	}
+	if video.OrgID == nil && invitee.ID == video.UserID {
+		respondWithError(w, http.StatusBadRequest, "The video's creator is always an owner", nil)
+		return
+	}
+
+	collaborator, err := cfg.db.SetVideoCollaborator(videoID, invitee.ID, params.Role)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't share video", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, collaborator)
+}
+
+func (cfg *apiConfig) handlerVideoCollaboratorRevoke(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+	collaboratorID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+	auditDetail(r, "user_id", collaboratorID)
+
+	userID := requestUser(r).ID
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+
+	// Collaborators can always remove themselves, anything else needs owner.
+	if collaboratorID != userID {
+		role, err := cfg.videoRole(video, userID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check video permissions", err)
+			return
+		}
+		if !hasVideoRole(role, database.VideoRoleOwner) {
+			respondWithError(w, http.StatusForbidden, "Only owners can revoke access to this video", nil)
+			return
+		}
+	}
+
+	err = cfg.db.DeleteVideoCollaborator(videoID, collaboratorID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke access", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerVideosShared(w http.ResponseWriter, r *http.Request) {
+	userID := requestUser(r).ID
+
+	videos, err := cfg.db.GetSharedVideos(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, videos)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// readinessTimeout is how long each readiness check gets before it counts as
+// failed.
+const readinessTimeout = 3 * time.Second
+
+// readinessCacheTTL is how long a readiness report is reused for. Checks fork
+// ffmpeg and ffprobe and call S3, and anyone can probe /readyz, so how often
+// they run is bounded here rather than by the rate limiter, which could turn
+// away the orchestrator's own probes.
+const readinessCacheTTL = 5 * time.Second
+
+// readinessCheck checks that something the server depends on works.
+type readinessCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+type checkResult struct {
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+type readinessReport struct {
+	Status string                 `json:"status"`
+	Checks map[string]checkResult `json:"checks,omitempty"`
+}
+
+// readinessCache runs the readiness checks at most once per
+// readinessCacheTTL, however many probes there are.
+type readinessCache struct {
+	checks []readinessCheck
+
+	mu        sync.Mutex
+	report    readinessReport
+	checkedAt time.Time
+}
+
+func newReadinessCache(checks []readinessCheck) *readinessCache {
+	return &readinessCache{checks: checks}
+}
+
+// get returns the last report, running the checks again first if it's out of
+// date. Callers arriving while they run wait for the result.
+func (c *readinessCache) get(ctx context.Context) readinessReport {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.checkedAt.IsZero() || time.Since(c.checkedAt) >= readinessCacheTTL {
+		// The report is shared, so one prober hanging up mustn't fail it.
+		c.report = runReadinessChecks(context.WithoutCancel(ctx), c.checks, readinessTimeout)
+		c.checkedAt = time.Now()
+	}
+	return c.report
+}
+
+// handlerHealthz tells the orchestrator the process is up. It doesn't look at
+// dependencies, so a database outage doesn't get the server restarted.
+func (cfg *apiConfig) handlerHealthz(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, http.StatusOK, struct {
+		Status string `json:"status"`
+	}{
+		Status: "ok",
+	})
+}
+
+// handlerReadyz tells the orchestrator whether the server can handle
+// requests, with a 503 if not or if it's shutting down. Only requests with the
+// metrics token see how each check went, since their errors can describe the
+// infrastructure.
+func (cfg *apiConfig) handlerReadyz(w http.ResponseWriter, r *http.Request) {
+	if cfg.jobs.isStopping() {
+		respondWithJSON(w, http.StatusServiceUnavailable, readinessReport{Status: "shutting_down"})
+		return
+	}
+	report := cfg.readiness.get(r.Context())
+	status := http.StatusOK
+	if report.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+	if !cfg.hasMetricsToken(r) {
+		report = readinessReport{Status: report.Status}
+	}
+	respondWithJSON(w, status, report)
+}
+
+// readinessChecks lists everything that has to work to serve requests.
+func (cfg *apiConfig) readinessChecks() []readinessCheck {
+	return []readinessCheck{
+		{"database", func(ctx context.Context) error {
+			return cfg.db.Ping(ctx)
+		}},
+		{"ffmpeg", func(ctx context.Context) error {
+			return checkCommand(ctx, "ffmpeg")
+		}},
+		{"ffprobe", func(ctx context.Context) error {
+			return checkCommand(ctx, "ffprobe")
+		}},
+		{"assets", func(ctx context.Context) error {
+			return checkWritable(cfg.assetsRoot)
+		}},
+		{"bucket", func(ctx context.Context) error {
+			_, err := cfg.s3Client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: &cfg.s3Bucket})
+			return err
+		}},
+	}
+}
+
+// runReadinessChecks runs the checks concurrently, giving each up to timeout.
+func runReadinessChecks(ctx context.Context, checks []readinessCheck, timeout time.Duration) readinessReport {
+	report := readinessReport{Status: "ok", Checks: map[string]checkResult{}}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for _, c := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			err := runCheck(ctx, c.check, timeout)
+			result := checkResult{
+				Status:    "ok",
+				LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if err != nil {
+				result.Status = "error"
+				result.Error = err.Error()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[c.name] = result
+			if err != nil {
+				report.Status = "unavailable"
+			}
+		}()
+	}
+	wg.Wait()
+	return report
+}
+
+// runCheck runs check, giving up after timeout even if check doesn't watch
+// its context.
+func runCheck(ctx context.Context, check func(ctx context.Context) error, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- check(ctx)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("timed out after %s", timeout)
+		}
+		return ctx.Err()
+	}
+}
+
+// checkCommand checks that a program is on the PATH and runs.
+func checkCommand(ctx context.Context, name string) error {
+	if _, err := exec.LookPath(name); err != nil {
+		return fmt.Errorf("%s isn't on the PATH", name)
+	}
+	return runTraced(ctx, exec.CommandContext(ctx, name, "-version"))
+}
+
+// checkWritable checks that files can be created in dir.
+func checkWritable(dir string) error {
+	f, err := os.CreateTemp(dir, ".readyz-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
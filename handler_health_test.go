@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRunReadinessChecks(t *testing.T) {
+	ok := func(ctx context.Context) error { return nil }
+	broken := func(ctx context.Context) error { return errors.New("connection refused") }
+	// Hangs without watching its context, like a stuck dependency might.
+	hung := func(ctx context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	}
+
+	tests := []struct {
+		name       string
+		checks     []readinessCheck
+		wantStatus string
+		wantErrors map[string]string
+	}{
+		{
+			name:       "all ok",
+			checks:     []readinessCheck{{"database", ok}, {"bucket", ok}},
+			wantStatus: "ok",
+			wantErrors: map[string]string{"database": "", "bucket": ""},
+		},
+		{
+			name:       "one broken",
+			checks:     []readinessCheck{{"database", ok}, {"bucket", broken}},
+			wantStatus: "unavailable",
+			wantErrors: map[string]string{"database": "", "bucket": "connection refused"},
+		},
+		{
+			name:       "timed out",
+			checks:     []readinessCheck{{"ffmpeg", hung}},
+			wantStatus: "unavailable",
+			wantErrors: map[string]string{"ffmpeg": "timed out after 50ms"},
+		},
+	}
+	for _, tt := range tests {
+		report := runReadinessChecks(context.Background(), tt.checks, 50*time.Millisecond)
+		if report.Status != tt.wantStatus {
+			t.Errorf("%s: status %q, want %q", tt.name, report.Status, tt.wantStatus)
+		}
+		if len(report.Checks) != len(tt.wantErrors) {
+			t.Errorf("%s: got checks %v", tt.name, report.Checks)
+		}
+		for name, wantErr := range tt.wantErrors {
+			if got := report.Checks[name].Error; got != wantErr {
+				t.Errorf("%s: %s error %q, want %q", tt.name, name, got, wantErr)
+			}
+		}
+	}
+}
+
+func TestCheckWritable(t *testing.T) {
+	dir := t.TempDir()
+	if err := checkWritable(dir); err != nil {
+		t.Errorf("checkWritable(%q) = %v", dir, err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("checkWritable left %d files behind", len(entries))
+	}
+	if err := checkWritable(filepath.Join(dir, "missing")); err == nil {
+		t.Error("checkWritable succeeded for a missing directory")
+	}
+}
+
+func TestHandlerReadyz(t *testing.T) {
+	var runs atomic.Int32
+	broken := func(ctx context.Context) error {
+		runs.Add(1)
+		return errors.New("dial tcp 10.0.0.5:443: connection refused")
+	}
+	cfg := &apiConfig{
+		jobs:         newJobTracker(),
+		readiness:    newReadinessCache([]readinessCheck{{"bucket", broken}}),
+		metricsToken: "scrape",
+	}
+
+	probe := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/readyz", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		cfg.handlerReadyz(rec, req)
+		return rec
+	}
+	rec := probe("")
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+	if strings.Contains(rec.Body.String(), "10.0.0.5") {
+		t.Errorf("report without the token shows check errors: %s", rec.Body)
+	}
+	rec = probe("scrape")
+	if !strings.Contains(rec.Body.String(), "10.0.0.5") {
+		t.Errorf("report with the token doesn't show check errors: %s", rec.Body)
+	}
+	if n := runs.Load(); n != 1 {
+		t.Errorf("checks ran %d times for two probes, want once", n)
+	}
+
+	cfg.jobs.stop()
+	if rec := probe(""); rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "shutting_down") {
+		t.Errorf("while stopping: status %d: %s", rec.Code, rec.Body)
+	}
+}
@@ -2,22 +2,24 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
+	"math"
 	"net/http"
+	"slices"
+	"strconv"
 	"time"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
 )
 
 func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
 		Password string `json:"password"`
 		Email    string `json:"email"`
-	}
-	type response struct {
-		database.User
-		Token        string `json:"token"`
-		RefreshToken string `json:"refresh_token"`
+		// Scopes optionally narrows what the issued tokens can do.
+		Scopes []string `json:"scopes"`
 	}
 
 	decoder := json.NewDecoder(r.Body)
@@ -28,26 +30,129 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	scopes := auth.UserScopes
+	if len(params.Scopes) > 0 {
+		for _, scope := range params.Scopes {
+			if !slices.Contains(auth.UserScopes, scope) {
+				respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Unknown scope %q", scope), nil)
+				return
+			}
+		}
+		scopes = params.Scopes
+	}
+
+	failure := database.CreateLoginFailureParams{
+		Email:     params.Email,
+		IP:        clientIP(r),
+		UserAgent: r.UserAgent(),
+	}
+
+	auditDetail(r, "email", params.Email)
+	if !cfg.checkLoginThrottle(w, r, failure) {
+		return
+	}
+
 	user, err := cfg.db.GetUserByEmail(params.Email)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't look up user", err)
+		return
+	}
+	if user.ID == uuid.Nil {
+		failure.Reason = database.LoginFailureUnknownUser
+		cfg.respondWithLoginFailure(w, r, failure, nil)
 		return
 	}
+	failure.UserID = &user.ID
+	auditActor(r, user.ID)
 
+	// Users who only log in through single sign-on have no password hash,
+	// which fails to parse here and is treated as a wrong password.
 	match, err := auth.CheckPasswordHash(params.Password, user.Password)
+	if err != nil || !match {
+		failure.Reason = database.LoginFailureBadPassword
+		cfg.respondWithLoginFailure(w, r, failure, err)
+		return
+	}
+
+	factor, err := cfg.db.GetTOTPFactor(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't look up two-factor authentication", err)
+		return
+	}
+	if factor.ConfirmedAt != nil {
+		// Failures are only cleared once the second factor is in too, or
+		// knowing the password would reset the budget for guessing codes.
+		auditDetail(r, "two_factor_required", true)
+		cfg.respondWithTwoFactorChallenge(w, user, scopes)
+		return
+	}
+
+	err = cfg.recordLoginSuccess(user.Email)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't record login", err)
 		return
 	}
-	if !match {
-		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password", nil)
+
+	cfg.respondWithNewSession(w, r, user, scopes)
+}
+
+// checkLoginThrottle responds with a 429 and returns false if logging in has
+// to wait because of earlier failures.
+func (cfg *apiConfig) checkLoginThrottle(w http.ResponseWriter, r *http.Request, failure database.CreateLoginFailureParams) bool {
+	retryAfter, err := cfg.loginRetryAfter(failure.Email, failure.IP)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check login attempts", err)
+		return false
+	}
+	if retryAfter <= 0 {
+		return true
+	}
+
+	failure.Reason = database.LoginFailureThrottled
+	auditDetail(r, "reason", failure.Reason)
+	if err := cfg.recordLoginFailure(failure); err != nil {
+		requestLogger(r).Error("Couldn't record login failure", "error", err)
+	}
+	// The same response whether the account or the IP address is held off,
+	// so it doesn't reveal which accounts exist.
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	respondWithError(w, http.StatusTooManyRequests, "Too many login attempts, try again later", nil)
+	return false
+}
+
+func (cfg *apiConfig) respondWithLoginFailure(w http.ResponseWriter, r *http.Request, failure database.CreateLoginFailureParams, err error) {
+	auditDetail(r, "reason", failure.Reason)
+	recordErr := cfg.recordLoginFailure(failure)
+	if recordErr != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't record login failure", recordErr)
+		return
+	}
+	if failure.Reason == database.LoginFailureBadSecondFactor {
+		respondWithError(w, http.StatusUnauthorized, "Incorrect two-factor code", err)
+		return
+	}
+	respondWithError(w, http.StatusUnauthorized, "Incorrect email or password", err)
+}
+
+type loginResponse struct {
+	database.User
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// respondWithNewSession starts a session for a user who has just proven who
+// they are and responds with its access and refresh tokens.
+func (cfg *apiConfig) respondWithNewSession(w http.ResponseWriter, r *http.Request, user database.User, scopes []string) {
+	if user.DisabledAt != nil {
+		respondWithError(w, http.StatusForbidden, "Your account has been disabled", nil)
 		return
 	}
 
 	accessToken, err := auth.MakeJWT(
 		user.ID,
-		cfg.jwtSecret,
-		time.Hour*24*30,
+		cfg.jwtKeys,
+		cfg.tokens.AccessTTL,
+		scopes,
 	)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create access JWT", err)
@@ -63,14 +168,17 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 	_, err = cfg.db.CreateRefreshToken(database.CreateRefreshTokenParams{
 		UserID:    user.ID,
 		Token:     refreshToken,
-		ExpiresAt: time.Now().UTC().Add(time.Hour * 24 * 60),
+		ExpiresAt: time.Now().UTC().Add(cfg.tokens.RefreshTTL),
+		UserAgent: r.UserAgent(),
+		IP:        clientIP(r),
+		Scopes:    scopes,
 	})
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't save refresh token", err)
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, response{
+	respondWithJSON(w, http.StatusOK, loginResponse{
 		User:         user,
 		Token:        accessToken,
 		RefreshToken: refreshToken,
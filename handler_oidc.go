@@ -0,0 +1,274 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/oidc"
+	"github.com/google/uuid"
+)
+
+var (
+	// errIdentityNeedsLink is returned by userForIdentity when the identity
+	// provider account's email belongs to a user with a password or two-factor
+	// authentication, who has to log in and link it themselves.
+	errIdentityNeedsLink = errors.New("user must link their identity provider account while logged in")
+	// errIdentityLinkedElsewhere is returned by linkIdentity when the identity
+	// provider account already belongs to another user.
+	errIdentityLinkedElsewhere = errors.New("identity provider account is linked to another user")
+)
+
+// oidcStateCookie holds the state of the login a browser started, so that the
+// callback only completes logins in the browser they were started in. Without
+// it, someone could get a victim's browser to finish their own login, or link
+// the victim's identity provider account to their user.
+const oidcStateCookie = "tubely_oidc_state"
+
+// handlerOIDCLogin starts logging in with the identity provider by redirecting
+// the browser to it.
+func (cfg *apiConfig) handlerOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if cfg.oidc == nil {
+		respondWithError(w, http.StatusNotFound, "Single sign-on isn't configured", nil)
+		return
+	}
+
+	authURL, status, err := cfg.newOIDCLogin(w, r, uuid.Nil)
+	if err != nil {
+		respondWithError(w, status, "Couldn't start login", err)
+		return
+	}
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// handlerOIDCLink starts linking an identity provider account to the logged
+// in user. It responds with the provider URL to send the browser to, and the
+// callback links whichever account logs in there.
+func (cfg *apiConfig) handlerOIDCLink(w http.ResponseWriter, r *http.Request) {
+	if cfg.oidc == nil {
+		respondWithError(w, http.StatusNotFound, "Single sign-on isn't configured", nil)
+		return
+	}
+
+	authURL, status, err := cfg.newOIDCLogin(w, r, requestUser(r).ID)
+	if err != nil {
+		respondWithError(w, status, "Couldn't start linking", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, struct {
+		URL string `json:"url"`
+	}{
+		URL: authURL,
+	})
+}
+
+// newOIDCLogin records a pending login, linking to linkUserID unless it's
+// uuid.Nil, ties it to the browser with oidcStateCookie and returns the
+// provider URL it starts at. On failure it also returns the status to respond
+// with.
+func (cfg *apiConfig) newOIDCLogin(w http.ResponseWriter, r *http.Request, linkUserID uuid.UUID) (string, int, error) {
+	req, err := oidc.NewAuthRequest()
+	if err != nil {
+		return "", http.StatusInternalServerError, err
+	}
+	authURL, err := cfg.oidc.AuthCodeURL(r.Context(), req)
+	if err != nil {
+		return "", http.StatusBadGateway, err
+	}
+
+	err = cfg.db.CreateOIDCLogin(database.OIDCLogin{
+		State:        req.State,
+		ExpiresAt:    time.Now().UTC().Add(cfg.tokens.OIDCLoginTTL),
+		Nonce:        req.Nonce,
+		CodeVerifier: req.CodeVerifier,
+		LinkUserID:   linkUserID,
+	})
+	if err != nil {
+		return "", http.StatusInternalServerError, err
+	}
+
+	http.SetCookie(w, oidcCookie(req.State, int(cfg.tokens.OIDCLoginTTL/time.Second)))
+	return authURL, 0, nil
+}
+
+// oidcCookie returns oidcStateCookie set to state, or deleting it if maxAge
+// is negative. It's Lax so it comes along when the provider redirects back.
+func oidcCookie(state string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/api/oidc/callback",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// handlerOIDCCallback finishes logging in once the identity provider
+// redirects back, responding with the same tokens as handlerLogin.
+func (cfg *apiConfig) handlerOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if cfg.oidc == nil {
+		respondWithError(w, http.StatusNotFound, "Single sign-on isn't configured", nil)
+		return
+	}
+
+	query := r.URL.Query()
+	state := query.Get("state")
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		respondWithError(w, http.StatusBadRequest, "Login wasn't started in this browser", nil)
+		return
+	}
+	http.SetCookie(w, oidcCookie("", -1))
+
+	login, err := cfg.db.ConsumeOIDCLogin(state)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't look up login", err)
+		return
+	}
+	if login.State == "" {
+		respondWithError(w, http.StatusBadRequest, "Login has expired or was already completed", nil)
+		return
+	}
+	if providerErr := query.Get("error"); providerErr != "" {
+		respondWithError(w, http.StatusUnauthorized, "The identity provider didn't log you in: "+providerErr, nil)
+		return
+	}
+
+	claims, err := cfg.oidc.Exchange(r.Context(), query.Get("code"), oidc.AuthRequest{
+		State:        login.State,
+		Nonce:        login.Nonce,
+		CodeVerifier: login.CodeVerifier,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't verify your identity", err)
+		return
+	}
+
+	var user database.User
+	if login.LinkUserID != uuid.Nil {
+		user, err = cfg.linkIdentity(claims, login.LinkUserID)
+	} else {
+		user, err = cfg.userForIdentity(claims)
+	}
+	if errors.Is(err, errIdentityNeedsLink) {
+		respondWithError(w, http.StatusConflict, "An account with your email already exists, log in to it and link your identity provider account from there", nil)
+		return
+	}
+	if errors.Is(err, errIdentityLinkedElsewhere) {
+		respondWithError(w, http.StatusConflict, "Your identity provider account is linked to another user", nil)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't look up user", err)
+		return
+	}
+	if user.ID == uuid.Nil {
+		respondWithError(w, http.StatusForbidden, "Your identity provider account has no verified email", nil)
+		return
+	}
+	auditActor(r, user.ID)
+
+	// Two-factor authentication only guards password logins, the identity
+	// provider is responsible for its own. Accounts with two-factor
+	// authentication are only reached this way once their user linked the
+	// identity provider account while logged in.
+	cfg.respondWithNewSession(w, r, user, auth.UserScopes)
+}
+
+// userForIdentity finds the user an identity provider account belongs to. The
+// first time an account logs in it is linked to the user with the same email,
+// who is created if needed. It returns an empty User if the account can't be
+// linked because its email isn't verified, and errIdentityNeedsLink if the
+// user has a password or two-factor authentication, since logging in as them
+// would then bypass both.
+func (cfg *apiConfig) userForIdentity(claims oidc.Claims) (database.User, error) {
+	userID, err := cfg.db.GetUserIDByIdentity(claims.Issuer, claims.Subject)
+	if err != nil {
+		return database.User{}, err
+	}
+	if userID != uuid.Nil {
+		user, err := cfg.db.GetUser(userID)
+		if err != nil || user == nil {
+			return database.User{}, err
+		}
+		return *user, nil
+	}
+
+	email := strings.TrimSpace(claims.Email)
+	if email == "" || !claims.EmailVerified {
+		return database.User{}, nil
+	}
+
+	user, err := cfg.db.GetUserByEmail(email)
+	if err != nil {
+		return database.User{}, err
+	}
+	if user.ID == uuid.Nil {
+		// Users who only ever log in through the identity provider have no
+		// password, and an empty hash never matches one.
+		created, err := cfg.db.CreateUser(database.CreateUserParams{
+			Email:    email,
+			Password: "",
+		})
+		if err != nil {
+			return database.User{}, err
+		}
+		user = *created
+	} else {
+		factor, err := cfg.db.GetTOTPFactor(user.ID)
+		if err != nil {
+			return database.User{}, err
+		}
+		if user.Password != "" || factor.ConfirmedAt != nil {
+			return database.User{}, errIdentityNeedsLink
+		}
+	}
+
+	err = cfg.db.LinkIdentity(claims.Issuer, claims.Subject, user.ID)
+	if err != nil {
+		return database.User{}, err
+	}
+	// The provider vouches for the address, so there's no need to send our
+	// own verification email.
+	err = cfg.db.MarkEmailVerified(user.ID, user.Email)
+	if err != nil {
+		return database.User{}, err
+	}
+	updated, err := cfg.db.GetUser(user.ID)
+	if err != nil || updated == nil {
+		return database.User{}, err
+	}
+	return *updated, nil
+}
+
+// linkIdentity links an identity provider account to the user who started
+// linking it while logged in, whatever its email.
+func (cfg *apiConfig) linkIdentity(claims oidc.Claims, userID uuid.UUID) (database.User, error) {
+	linkedID, err := cfg.db.GetUserIDByIdentity(claims.Issuer, claims.Subject)
+	if err != nil {
+		return database.User{}, err
+	}
+	if linkedID != uuid.Nil && linkedID != userID {
+		return database.User{}, errIdentityLinkedElsewhere
+	}
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil || user == nil {
+		return database.User{}, err
+	}
+	if linkedID == uuid.Nil {
+		err = cfg.db.LinkIdentity(claims.Issuer, claims.Subject, userID)
+		if err != nil {
+			return database.User{}, err
+		}
+	}
+	return *user, nil
+}
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/oidc"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	mockClientID    = "tubely"
+	mockRedirectURL = "http://tubely.test/api/oidc/callback"
+)
+
+// mockProvider is a minimal OpenID Connect provider that logs in whichever
+// account is set on it without asking.
+type mockProvider struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu      sync.Mutex
+	subject string
+	email   string
+	// emailVerified is left out of ID tokens if nil.
+	emailVerified *bool
+	// Pending authorization codes and the requests they were issued for.
+	codes map[string]url.Values
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockProvider{key: key, codes: map[string]url.Values{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.URL,
+			"authorization_endpoint": m.URL + "/authorize",
+			"token_endpoint":         m.URL + "/token",
+			"jwks_uri":               m.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "mock-key",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("GET /authorize", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if query.Get("client_id") != mockClientID || query.Get("code_challenge_method") != "S256" {
+			http.Error(w, "bad authorization request", http.StatusBadRequest)
+			return
+		}
+		code, err := auth.MakeRefreshToken()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		m.mu.Lock()
+		m.codes[code] = query
+		m.mu.Unlock()
+
+		redirect := query.Get("redirect_uri") + "?" + url.Values{
+			"code":  {code},
+			"state": {query.Get("state")},
+		}.Encode()
+		http.Redirect(w, r, redirect, http.StatusFound)
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		authReq, ok := m.codes[r.FormValue("code")]
+		delete(m.codes, r.FormValue("code"))
+		challenge := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		if !ok ||
+			r.FormValue("grant_type") != "authorization_code" ||
+			r.FormValue("client_id") != mockClientID ||
+			r.FormValue("redirect_uri") != authReq.Get("redirect_uri") ||
+			base64.RawURLEncoding.EncodeToString(challenge[:]) != authReq.Get("code_challenge") {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+
+		claims := jwt.MapClaims{
+			"iss":   m.URL,
+			"aud":   mockClientID,
+			"sub":   m.subject,
+			"email": m.email,
+			"nonce": authReq.Get("nonce"),
+			"iat":   time.Now().Unix(),
+			"exp":   time.Now().Add(time.Minute).Unix(),
+		}
+		if m.emailVerified != nil {
+			claims["email_verified"] = *m.emailVerified
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "mock-key"
+		idToken, err := token.SignedString(key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"access_token": "unused",
+			"token_type":   "Bearer",
+			"id_token":     idToken,
+		})
+	})
+
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+	return m
+}
+
+func (m *mockProvider) setAccount(subject, email string, emailVerified bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.subject, m.email, m.emailVerified = subject, email, &emailVerified
+}
+
+// setAccountWithoutEmailVerified logs in an account whose ID tokens have no
+// email_verified claim.
+func (m *mockProvider) setAccountWithoutEmailVerified(subject, email string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.subject, m.email, m.emailVerified = subject, email, nil
+}
+
+func newOIDCTestConfig(t *testing.T, provider *mockProvider) *apiConfig {
+	cfg := newTestAPIConfig(t)
+	cfg.oidc = oidc.NewProvider(oidc.Config{
+		Issuer:      provider.URL,
+		ClientID:    mockClientID,
+		RedirectURL: mockRedirectURL,
+	})
+	return cfg
+}
+
+// oidcCallback is where the provider sends the browser back to, along with
+// the cookie the browser got when it started the login.
+type oidcCallback struct {
+	url    *url.URL
+	cookie *http.Cookie
+}
+
+// startOIDCLogin runs the login endpoint and follows its redirect through the
+// provider, returning the callback the browser would be sent back to.
+func startOIDCLogin(t *testing.T, cfg *apiConfig) oidcCallback {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	cfg.handlerOIDCLogin(rec, httptest.NewRequest(http.MethodGet, "/api/oidc/login", nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("login: status %d: %s", rec.Code, rec.Body)
+	}
+	return authorizeOIDCLogin(t, rec.Header().Get("Location"), rec.Result().Cookies())
+}
+
+// startOIDCLink starts linking an identity provider account to userID like
+// startOIDCLogin.
+func startOIDCLink(t *testing.T, cfg *apiConfig, userID uuid.UUID) oidcCallback {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/api/oidc/link", nil)
+	req.Header.Set("Authorization", "Bearer "+testAccessToken(t, cfg, userID, auth.UserScopes))
+	rec := httptest.NewRecorder()
+	cfg.requireAuth(auth.ScopeAccount, cfg.handlerOIDCLink).ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("link: status %d: %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		URL string `json:"url"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return authorizeOIDCLogin(t, resp.URL, rec.Result().Cookies())
+}
+
+// authorizeOIDCLogin logs in at the provider's authURL, keeping the cookie
+// the browser got when it started the login.
+func authorizeOIDCLogin(t *testing.T, authURL string, cookies []*http.Cookie) oidcCallback {
+	t.Helper()
+	var cookie *http.Cookie
+	for _, c := range cookies {
+		if c.Name == oidcStateCookie {
+			cookie = c
+		}
+	}
+	if cookie == nil || !cookie.HttpOnly || !cookie.Secure || cookie.SameSite != http.SameSiteLaxMode {
+		t.Fatalf("login cookie = %+v", cookie)
+	}
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize: status %d", resp.StatusCode)
+	}
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return oidcCallback{url: callback, cookie: cookie}
+}
+
+func finishOIDCLogin(cfg *apiConfig, callback oidcCallback) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, callback.url.String(), nil)
+	if callback.cookie != nil {
+		req.AddCookie(callback.cookie)
+	}
+	rec := httptest.NewRecorder()
+	cfg.handlerOIDCCallback(rec, req)
+	return rec
+}
+
+func oidcLoginUser(t *testing.T, cfg *apiConfig) loginResponse {
+	t.Helper()
+	rec := finishOIDCLogin(cfg, startOIDCLogin(t, cfg))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("callback: status %d: %s", rec.Code, rec.Body)
+	}
+	var resp loginResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	userID, _, err := auth.ValidateJWT(resp.Token, cfg.jwtKeys)
+	if err != nil || userID != resp.ID {
+		t.Fatalf("access token is for %v (%v), want %v", userID, err, resp.ID)
+	}
+	if resp.RefreshToken == "" {
+		t.Fatal("no refresh token")
+	}
+	return resp
+}
+
+func TestOIDCLoginProvisionsAndLinksUsers(t *testing.T) {
+	provider := newMockProvider(t)
+	cfg := newOIDCTestConfig(t, provider)
+
+	provider.setAccount("alice-sub", "alice@example.com", true)
+	first := oidcLoginUser(t, cfg)
+	if first.Email != "alice@example.com" || first.EmailVerifiedAt == nil {
+		t.Errorf("provisioned user has email %q verified at %v", first.Email, first.EmailVerifiedAt)
+	}
+	again := oidcLoginUser(t, cfg)
+	if again.ID != first.ID {
+		t.Errorf("second login got user %v, want %v", again.ID, first.ID)
+	}
+
+	// Once linked, the account is found by subject even if its email changes.
+	provider.setAccount("alice-sub", "alice@new.example.com", true)
+	if renamed := oidcLoginUser(t, cfg); renamed.ID != first.ID {
+		t.Errorf("login after email change got user %v, want %v", renamed.ID, first.ID)
+	}
+
+	provider.setAccount("eve-sub", "bob@example.com", false)
+	if rec := finishOIDCLogin(cfg, startOIDCLogin(t, cfg)); rec.Code != http.StatusForbidden {
+		t.Errorf("unverified email: status %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	provider.setAccountWithoutEmailVerified("eve-sub", "carol@example.com")
+	if rec := finishOIDCLogin(cfg, startOIDCLogin(t, cfg)); rec.Code != http.StatusForbidden {
+		t.Errorf("email_verified left out: status %d, want %d", rec.Code, http.StatusForbidden)
+	}
+}
+
+func TestOIDCLoginOnlyLinksProtectedAccountsWhenLoggedIn(t *testing.T) {
+	provider := newMockProvider(t)
+	cfg := newOIDCTestConfig(t, provider)
+
+	withPassword, err := cfg.db.CreateUser(database.CreateUserParams{Email: "bob@example.com", Password: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	withTOTP, err := cfg.db.CreateUser(database.CreateUserParams{Email: "dave@example.com", Password: ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.db.SetTOTPFactor(withTOTP.ID, "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.db.ConfirmTOTPFactor(withTOTP.ID, 1, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, user := range []*database.User{withPassword, withTOTP} {
+		provider.setAccount(user.Email+"-sub", user.Email, true)
+		if rec := finishOIDCLogin(cfg, startOIDCLogin(t, cfg)); rec.Code != http.StatusConflict {
+			t.Errorf("%s: login by existing email got status %d, want %d", user.Email, rec.Code, http.StatusConflict)
+		}
+
+		if rec := finishOIDCLogin(cfg, startOIDCLink(t, cfg, user.ID)); rec.Code != http.StatusOK {
+			t.Fatalf("%s: link: status %d: %s", user.Email, rec.Code, rec.Body)
+		}
+		if linked := oidcLoginUser(t, cfg); linked.ID != user.ID {
+			t.Errorf("%s: login once linked got user %v, want %v", user.Email, linked.ID, user.ID)
+		}
+	}
+
+	// An account can't be moved to another user by linking it again.
+	provider.setAccount(withPassword.Email+"-sub", withPassword.Email, true)
+	if rec := finishOIDCLogin(cfg, startOIDCLink(t, cfg, withTOTP.ID)); rec.Code != http.StatusConflict {
+		t.Errorf("linking another user's account: status %d, want %d", rec.Code, http.StatusConflict)
+	}
+}
+
+func TestOIDCCallbackRejectsReplaysAndMismatchedCodes(t *testing.T) {
+	provider := newMockProvider(t)
+	cfg := newOIDCTestConfig(t, provider)
+	provider.setAccount("alice-sub", "alice@example.com", true)
+
+	callback := startOIDCLogin(t, cfg)
+	if rec := finishOIDCLogin(cfg, callback); rec.Code != http.StatusOK {
+		t.Fatalf("callback: status %d: %s", rec.Code, rec.Body)
+	}
+	if rec := finishOIDCLogin(cfg, callback); rec.Code != http.StatusBadRequest {
+		t.Errorf("replayed callback: status %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	// A code issued for one login can't complete another, since the PKCE
+	// verifier doesn't match the challenge it was issued for.
+	first := startOIDCLogin(t, cfg)
+	second := startOIDCLogin(t, cfg)
+	mixedURL := *second.url
+	query := second.url.Query()
+	query.Set("code", first.url.Query().Get("code"))
+	mixedURL.RawQuery = query.Encode()
+	mixed := oidcCallback{url: &mixedURL, cookie: second.cookie}
+	if rec := finishOIDCLogin(cfg, mixed); rec.Code != http.StatusUnauthorized {
+		t.Errorf("mismatched code: status %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+
+	unknownURL := *callback.url
+	unknownURL.RawQuery = url.Values{"code": {"x"}, "state": {"unknown"}}.Encode()
+	unknown := oidcCallback{url: &unknownURL, cookie: &http.Cookie{Name: oidcStateCookie, Value: "unknown"}}
+	if rec := finishOIDCLogin(cfg, unknown); rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown state: status %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestOIDCCallbackOnlyCompletesInTheBrowserThatStarted(t *testing.T) {
+	provider := newMockProvider(t)
+	cfg := newOIDCTestConfig(t, provider)
+	attacker := testUser(t, cfg, "mallory@example.com")
+	provider.setAccount("alice-sub", "alice@example.com", true)
+
+	// The attacker starts linking and gets the victim's browser, which
+	// doesn't have the attacker's cookie, to come back from the provider.
+	link := startOIDCLink(t, cfg, attacker.ID)
+	for name, cookie := range map[string]*http.Cookie{
+		"no cookie":    nil,
+		"other cookie": startOIDCLogin(t, cfg).cookie,
+	} {
+		if rec := finishOIDCLogin(cfg, oidcCallback{url: link.url, cookie: cookie}); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want %d", name, rec.Code, http.StatusBadRequest)
+		}
+	}
+	if userID, _ := cfg.db.GetUserIDByIdentity(provider.URL, "alice-sub"); userID != uuid.Nil {
+		t.Fatal("identity was linked from another browser")
+	}
+
+	// The browser that started it can still finish it, and the cookie is
+	// cleared.
+	rec := finishOIDCLogin(cfg, link)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("callback: status %d: %s", rec.Code, rec.Body)
+	}
+	if userID, _ := cfg.db.GetUserIDByIdentity(provider.URL, "alice-sub"); userID != attacker.ID {
+		t.Errorf("identity linked to %v, want %v", userID, attacker.ID)
+	}
+	cleared := rec.Result().Cookies()
+	if len(cleared) != 1 || cleared[0].Name != oidcStateCookie || cleared[0].MaxAge >= 0 {
+		t.Errorf("callback cookies = %+v, want %s deleted", cleared, oidcStateCookie)
+	}
+}
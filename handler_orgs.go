@@ -0,0 +1,291 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const maxOrgNameLength = 100
+
+func (cfg *apiConfig) handlerOrgCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name string `json:"name"`
+	}
+
+	userID := requestUser(r).ID
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	name, err := validateOrgName(params.Name)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	org, err := cfg.db.CreateOrg(name, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create organization", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, org)
+}
+
+func (cfg *apiConfig) handlerOrgsRetrieve(w http.ResponseWriter, r *http.Request) {
+	userID := requestUser(r).ID
+
+	orgs, err := cfg.db.GetOrgsForUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve organizations", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, orgs)
+}
+
+func (cfg *apiConfig) handlerOrgGet(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		database.Org
+		Members []database.OrgMember  `json:"members"`
+		Usage   database.StorageUsage `json:"usage"`
+	}
+
+	org, _, ok := cfg.orgForMember(w, r, database.OrgRoleMember)
+	if !ok {
+		return
+	}
+
+	members, err := cfg.db.GetOrgMembers(org.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve members", err)
+		return
+	}
+	usage, err := cfg.db.GetOrgUsage(org.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve usage", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		Org:     org,
+		Members: members,
+		Usage:   usage,
+	})
+}
+
+func (cfg *apiConfig) handlerOrgUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name string `json:"name"`
+	}
+
+	org, _, ok := cfg.orgForMember(w, r, database.OrgRoleAdmin)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	org.Name, err = validateOrgName(params.Name)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	org, err = cfg.db.UpdateOrg(org)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update organization", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, org)
+}
+
+func (cfg *apiConfig) handlerOrgMemberAdd(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string `json:"email"`
+		Role  string `json:"role"`
+	}
+
+	org, _, ok := cfg.orgForMember(w, r, database.OrgRoleAdmin)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Role != database.OrgRoleAdmin && params.Role != database.OrgRoleMember {
+		respondWithError(w, http.StatusBadRequest, "Role must be admin or member", nil)
+		return
+	}
+
+	user, err := cfg.db.GetUserByEmail(strings.TrimSpace(params.Email))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't look up user", err)
+		return
+	}
+	if user.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "No user with that email", nil)
+		return
+	}
+
+	if params.Role != database.OrgRoleAdmin {
+		ok, err := cfg.keepsAnAdmin(org.ID, user.ID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve members", err)
+			return
+		}
+		if !ok {
+			respondWithError(w, http.StatusBadRequest, "An organization needs at least one admin", nil)
+			return
+		}
+	}
+
+	member, err := cfg.db.SetOrgMember(org.ID, user.ID, params.Role)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't add member", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, member)
+}
+
+func (cfg *apiConfig) handlerOrgMemberRemove(w http.ResponseWriter, r *http.Request) {
+	memberID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+
+	org, caller, ok := cfg.orgForMember(w, r, database.OrgRoleMember)
+	if !ok {
+		return
+	}
+
+	// Members can always leave, removing anybody else takes an admin.
+	if memberID != caller.UserID && caller.Role != database.OrgRoleAdmin {
+		respondWithError(w, http.StatusForbidden, "Only admins can remove members", nil)
+		return
+	}
+
+	ok, err = cfg.keepsAnAdmin(org.ID, memberID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve members", err)
+		return
+	}
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "An organization needs at least one admin", nil)
+		return
+	}
+
+	err = cfg.db.DeleteOrgMember(org.ID, memberID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't remove member", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// orgForMember loads the organization named in the path, checking that the
+// caller holds at least role in it. It writes the error response itself and
+// returns false if the handler should stop.
+func (cfg *apiConfig) orgForMember(w http.ResponseWriter, r *http.Request, role string) (database.Org, database.OrgMember, bool) {
+	orgID, err := uuid.Parse(r.PathValue("orgID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid organization ID", err)
+		return database.Org{}, database.OrgMember{}, false
+	}
+
+	org, err := cfg.db.GetOrg(orgID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get organization", err)
+		return database.Org{}, database.OrgMember{}, false
+	}
+	member, err := cfg.db.GetOrgMember(orgID, requestUser(r).ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check organization membership", err)
+		return database.Org{}, database.OrgMember{}, false
+	}
+	// Non-members get a 404 so they can't probe for organization IDs.
+	if org.ID == uuid.Nil || member.Role == "" {
+		respondWithError(w, http.StatusNotFound, "Organization not found", nil)
+		return database.Org{}, database.OrgMember{}, false
+	}
+	if role == database.OrgRoleAdmin && member.Role != database.OrgRoleAdmin {
+		respondWithError(w, http.StatusForbidden, "Only admins can do that", nil)
+		return database.Org{}, database.OrgMember{}, false
+	}
+
+	return org, member, true
+}
+
+// keepsAnAdmin reports whether the organization would still have an admin if
+// userID stopped being one.
+func (cfg *apiConfig) keepsAnAdmin(orgID, userID uuid.UUID) (bool, error) {
+	members, err := cfg.db.GetOrgMembers(orgID)
+	if err != nil {
+		return false, err
+	}
+	for _, m := range members {
+		if m.Role == database.OrgRoleAdmin && m.UserID != userID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// checkOrgQuota checks whether adding extraVideos videos and extraBytes bytes
+// would take the organization over one of its quotas. If so, it returns a
+// message describing which one.
+func (cfg *apiConfig) checkOrgQuota(orgID uuid.UUID, extraVideos int, extraBytes int64) (string, error) {
+	org, err := cfg.db.GetOrg(orgID)
+	if err != nil {
+		return "", err
+	}
+	usage, err := cfg.db.GetOrgUsage(orgID)
+	if err != nil {
+		return "", err
+	}
+
+	q := quota{MaxVideos: org.MaxVideos, MaxStorageBytes: org.MaxStorageBytes}
+	exceeded := q.exceeded(usage, extraVideos, extraBytes)
+	if exceeded == "" {
+		return "", nil
+	}
+	return "Organization " + strings.ToLower(exceeded[:1]) + exceeded[1:], nil
+}
+
+func validateOrgName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", errors.New("Name is required")
+	}
+	if utf8.RuneCountInString(name) > maxOrgNameLength {
+		return "", fmt.Errorf("Name must be at most %d characters", maxOrgNameLength)
+	}
+	return name, nil
+}
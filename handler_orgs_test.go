@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func TestOrgQuotas(t *testing.T) {
+	cfg := newTestAPIConfig(t)
+	cfg.metrics = newServerMetrics(cfg.db)
+	admin := testUser(t, cfg, "admin@example.com")
+	if err := cfg.db.SetUserRole(admin.ID, database.UserRoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	member := testUser(t, cfg, "member@example.com")
+	org, err := cfg.db.CreateOrg("Acme", member.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("PATCH /admin/orgs/{orgID}", cfg.requireAuth(auth.ScopeAccount, requireAdmin(cfg.handlerAdminOrgUpdate)))
+	mux.Handle("POST /api/videos", cfg.requireAuth(auth.ScopeVideosWrite, cfg.handlerVideoMetaCreate))
+	mux.Handle("POST /api/video_upload/{videoID}", cfg.requireAuth(auth.ScopeVideosWrite, cfg.handlerUploadVideo))
+	do := func(userID uuid.UUID, req *http.Request) *httptest.ResponseRecorder {
+		t.Helper()
+		req.Header.Set("Authorization", "Bearer "+testAccessToken(t, cfg, userID, auth.UserScopes))
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	setQuota := `{"max_videos": 1, "max_storage_bytes": 100}`
+	if rec := do(member.ID, httptest.NewRequest("PATCH", "/admin/orgs/"+org.ID.String(), strings.NewReader(setQuota))); rec.Code != http.StatusForbidden {
+		t.Errorf("org admin setting quotas: status %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	rec := do(admin.ID, httptest.NewRequest("PATCH", "/admin/orgs/"+org.ID.String(), strings.NewReader(setQuota)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("setting quotas: status %d: %s", rec.Code, rec.Body)
+	}
+	if updated, _ := cfg.db.GetOrg(org.ID); updated.MaxVideos != 1 || updated.MaxStorageBytes != 100 {
+		t.Fatalf("quotas not saved: %+v", updated)
+	}
+
+	createVideo := func() *httptest.ResponseRecorder {
+		return do(member.ID, httptest.NewRequest("POST", "/api/videos", strings.NewReader(`{"title": "Demo", "org_id": "`+org.ID.String()+`"}`)))
+	}
+	rec = createVideo()
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("creating video: status %d: %s", rec.Code, rec.Body)
+	}
+	var video database.Video
+	if err := json.Unmarshal(rec.Body.Bytes(), &video); err != nil {
+		t.Fatal(err)
+	}
+	if rec := createVideo(); rec.Code != http.StatusForbidden {
+		t.Errorf("creating video past the org's video quota: status %d, want %d", rec.Code, http.StatusForbidden)
+	}
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreatePart(textproto.MIMEHeader{
+		"Content-Disposition": {`form-data; name="video"; filename="demo.mp4"`},
+		"Content-Type":        {"video/mp4"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(make([]byte, 200))
+	form.Close()
+	req := httptest.NewRequest("POST", "/api/video_upload/"+video.ID.String(), &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	rec = do(member.ID, req)
+	if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "Organization storage quota") {
+		t.Errorf("upload past the org's storage quota: status %d: %s", rec.Code, rec.Body)
+	}
+
+	// An organization already over its quota can still shrink its usage.
+	if _, err := cfg.db.UpdateVideoFile(video.ID, "https://example.com/demo.mp4", 150); err != nil {
+		t.Fatal(err)
+	}
+	if exceeded, err := cfg.checkOrgQuota(org.ID, 0, -50); err != nil || exceeded != "" {
+		t.Errorf("replacing a video with a smaller one over quota: %q, %v", exceeded, err)
+	}
+	if exceeded, _ := cfg.checkOrgQuota(org.ID, 0, 10); exceeded != "Organization storage quota of 100 bytes exceeded" {
+		t.Errorf("growing over quota: %q", exceeded)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handlerPasswordResetRequest(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string `json:"email"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	auditDetail(r, "email", params.Email)
+	user, err := cfg.db.GetUserByEmail(strings.TrimSpace(params.Email))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't look up user", err)
+		return
+	}
+
+	// Respond the same way whether or not the account exists, so this can't
+	// be used to find out who has one. Sending the email takes long enough
+	// to tell apart from not sending one, so it happens after responding.
+	if user.ID != uuid.Nil {
+		auditTarget(r, "user:"+user.ID.String())
+		ctx := context.WithoutCancel(r.Context())
+		logger := requestLogger(r)
+		cfg.inBackground(func() {
+			err := cfg.sendPasswordResetEmail(ctx, user)
+			if err != nil {
+				logger.Error("Couldn't send password reset email", "error", err)
+			}
+		})
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (cfg *apiConfig) handlerPasswordResetConfirm(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Password == "" {
+		respondWithError(w, http.StatusBadRequest, "Password is required", nil)
+		return
+	}
+
+	token, err := cfg.db.ConsumeUserToken(auth.HashToken(params.Token), database.UserTokenResetPassword)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check token", err)
+		return
+	}
+	if token.TokenHash == "" {
+		respondWithError(w, http.StatusBadRequest, "Token is invalid, expired or already used", nil)
+		return
+	}
+	auditActor(r, token.UserID)
+
+	hashedPassword, err := auth.HashPassword(params.Password)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't hash password", err)
+		return
+	}
+	err = cfg.db.UpdateUserPassword(token.UserID, hashedPassword)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update password", err)
+		return
+	}
+
+	// Whoever knew the old password shouldn't stay logged in, and receiving
+	// the email proves the address is the user's.
+	err = cfg.db.RevokeUserRefreshTokens(token.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions", err)
+		return
+	}
+	err = cfg.db.MarkEmailVerified(token.UserID, token.Email)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't verify email", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mailer"
+)
+
+// recordingMailer keeps the messages it's asked to send.
+type recordingMailer struct {
+	mu       sync.Mutex
+	messages []mailer.Message
+}
+
+func (m *recordingMailer) Send(ctx context.Context, msg mailer.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+func (m *recordingMailer) sent() []mailer.Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]mailer.Message(nil), m.messages...)
+}
+
+func TestPasswordReset(t *testing.T) {
+	db, err := database.NewClient(filepath.Join(t.TempDir(), "tubely.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	mail := &recordingMailer{}
+	cfg := &apiConfig{db: db, mailer: mail, jobs: newJobTracker(), tokens: defaultConfig().Tokens}
+	cfg.tokens.PasswordResetTTL = 30 * time.Minute
+	user, err := db.CreateUser(database.CreateUserParams{Email: "alice@example.com", Password: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := func(email string) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		cfg.handlerPasswordResetRequest(rec, httptest.NewRequest("POST", "/api/password_reset", strings.NewReader(`{"email":"`+email+`"}`)))
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("request for %s: status %d", email, rec.Code)
+		}
+		if err := cfg.jobs.wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	request("nobody@example.com")
+	if sent := mail.sent(); len(sent) != 0 {
+		t.Fatalf("sent %d emails for an unknown address", len(sent))
+	}
+	request("alice@example.com")
+	sent := mail.sent()
+	if len(sent) != 1 || sent[0].To != user.Email {
+		t.Fatalf("sent %+v, want one email to %s", sent, user.Email)
+	}
+	if !strings.Contains(sent[0].Body, "It expires in 30 minutes") {
+		t.Errorf("email doesn't give the configured expiry: %s", sent[0].Body)
+	}
+	// The token is on a line of its own, after the instructions.
+	token := strings.Split(sent[0].Body, "\n")[4]
+
+	confirm := func() int {
+		rec := httptest.NewRecorder()
+		body := `{"token":"` + token + `","password":"new password"}`
+		cfg.handlerPasswordResetConfirm(rec, httptest.NewRequest("POST", "/api/password_reset/confirm", strings.NewReader(body)))
+		return rec.Code
+	}
+	if code := confirm(); code != http.StatusNoContent {
+		t.Fatalf("confirm: status %d", code)
+	}
+	updated, err := db.GetUser(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if match, err := auth.CheckPasswordHash("new password", updated.Password); !match || err != nil {
+		t.Errorf("password not changed: %v", err)
+	}
+	if code := confirm(); code != http.StatusBadRequest {
+		t.Errorf("reusing the token: status %d, want %d", code, http.StatusBadRequest)
+	}
+}
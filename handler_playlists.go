@@ -0,0 +1,352 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	maxPlaylistTitleLength       = 100
+	maxPlaylistDescriptionLength = 5000
+)
+
+func (cfg *apiConfig) handlerPlaylistCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Title       string `json:"title"`
+		Description string `json:"description"`
+		Visibility  string `json:"visibility"`
+	}
+
+	userID := requestUser(r).ID
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Visibility == "" {
+		params.Visibility = database.PlaylistVisibilityPrivate
+	}
+
+	createParams := database.CreatePlaylistParams{
+		Title:       params.Title,
+		Description: params.Description,
+		Visibility:  params.Visibility,
+		UserID:      userID,
+	}
+	err = validatePlaylistParams(&createParams)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	playlist, err := cfg.db.CreatePlaylist(createParams)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create playlist", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, playlist)
+}
+
+func (cfg *apiConfig) handlerPlaylistsRetrieve(w http.ResponseWriter, r *http.Request) {
+	userID := requestUser(r).ID
+
+	playlists, err := cfg.db.GetPlaylists(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve playlists", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, playlists)
+}
+
+func (cfg *apiConfig) handlerPlaylistGet(w http.ResponseWriter, r *http.Request) {
+	playlistID, err := uuid.Parse(r.PathValue("playlistID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid playlist ID", err)
+		return
+	}
+
+	playlist, err := cfg.db.GetPlaylist(playlistID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get playlist", err)
+		return
+	}
+	if playlist.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Playlist not found", nil)
+		return
+	}
+
+	// Private playlists are only visible to their owner, everyone else gets
+	// the same 404 as for a playlist that doesn't exist.
+	if playlist.Visibility == database.PlaylistVisibilityPrivate {
+		creds, err := cfg.authenticatedUser(r)
+		if err != nil || !slices.Contains(creds.Scopes, auth.ScopeVideosRead) || creds.User.ID != playlist.UserID {
+			respondWithError(w, http.StatusNotFound, "Playlist not found", err)
+			return
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, playlist)
+}
+
+func (cfg *apiConfig) handlerPlaylistUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Title       *string `json:"title"`
+		Description *string `json:"description"`
+		Visibility  *string `json:"visibility"`
+	}
+
+	playlist, ok := cfg.ownedPlaylist(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	if params.Title != nil {
+		playlist.Title = *params.Title
+	}
+	if params.Description != nil {
+		playlist.Description = *params.Description
+	}
+	if params.Visibility != nil {
+		playlist.Visibility = *params.Visibility
+	}
+	err = validatePlaylistParams(&playlist.CreatePlaylistParams)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	playlist, err = cfg.db.UpdatePlaylist(playlist)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update playlist", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, playlist)
+}
+
+func (cfg *apiConfig) handlerPlaylistDelete(w http.ResponseWriter, r *http.Request) {
+	playlist, ok := cfg.ownedPlaylist(w, r)
+	if !ok {
+		return
+	}
+
+	err := cfg.db.DeletePlaylist(playlist.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete playlist", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerPlaylistVideoAdd(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		VideoID  uuid.UUID `json:"video_id"`
+		Position *int      `json:"position"`
+	}
+
+	playlist, ok := cfg.ownedPlaylist(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(params.VideoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	if video.UserID != playlist.UserID {
+		respondWithError(w, http.StatusForbidden, "You can't add this video to a playlist", nil)
+		return
+	}
+
+	position := -1
+	if params.Position != nil {
+		position = *params.Position
+	}
+
+	playlist, err = cfg.db.AddPlaylistVideo(playlist.ID, video.ID, position)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't add video to playlist", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, playlist)
+}
+
+func (cfg *apiConfig) handlerPlaylistVideoMove(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Position int `json:"position"`
+	}
+
+	playlist, ok := cfg.ownedPlaylist(w, r)
+	if !ok {
+		return
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Position < 0 {
+		respondWithError(w, http.StatusBadRequest, "Position can't be negative", nil)
+		return
+	}
+
+	playlist, err = cfg.db.MovePlaylistVideo(playlist.ID, videoID, params.Position)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, http.StatusNotFound, "Video is not in this playlist", err)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't move video", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, playlist)
+}
+
+func (cfg *apiConfig) handlerPlaylistVideoRemove(w http.ResponseWriter, r *http.Request) {
+	playlist, ok := cfg.ownedPlaylist(w, r)
+	if !ok {
+		return
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	playlist, err = cfg.db.RemovePlaylistVideo(playlist.ID, videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't remove video from playlist", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, playlist)
+}
+
+func (cfg *apiConfig) handlerPlaylistReorder(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		VideoIDs []uuid.UUID `json:"video_ids"`
+	}
+
+	playlist, ok := cfg.ownedPlaylist(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	playlist, err = cfg.db.SetPlaylistOrder(playlist.ID, params.VideoIDs)
+	if err != nil {
+		if errors.Is(err, database.ErrInvalidPlaylistOrder) {
+			respondWithError(w, http.StatusBadRequest, err.Error(), err)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reorder playlist", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, playlist)
+}
+
+// ownedPlaylist loads the playlist named in the path, checking that the caller
+// owns it. Other users' playlists get the same 404 as missing ones, so their
+// IDs can't be probed. It writes the error response itself and returns false
+// if the handler should stop.
+func (cfg *apiConfig) ownedPlaylist(w http.ResponseWriter, r *http.Request) (database.Playlist, bool) {
+	playlistID, err := uuid.Parse(r.PathValue("playlistID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid playlist ID", err)
+		return database.Playlist{}, false
+	}
+
+	playlist, err := cfg.db.GetPlaylist(playlistID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get playlist", err)
+		return database.Playlist{}, false
+	}
+	if playlist.ID == uuid.Nil || playlist.UserID != requestUser(r).ID {
+		respondWithError(w, http.StatusNotFound, "Playlist not found", nil)
+		return database.Playlist{}, false
+	}
+
+	return playlist, true
+}
+
+// validatePlaylistParams trims the title and description in place and checks
+// them, along with the visibility, against what we accept.
+func validatePlaylistParams(params *database.CreatePlaylistParams) error {
+	params.Title = strings.TrimSpace(params.Title)
+	params.Description = strings.TrimSpace(params.Description)
+
+	if params.Title == "" {
+		return errors.New("Title is required")
+	}
+	if utf8.RuneCountInString(params.Title) > maxPlaylistTitleLength {
+		return fmt.Errorf("Title must be at most %d characters", maxPlaylistTitleLength)
+	}
+	if utf8.RuneCountInString(params.Description) > maxPlaylistDescriptionLength {
+		return fmt.Errorf("Description must be at most %d characters", maxPlaylistDescriptionLength)
+	}
+
+	switch params.Visibility {
+	case database.PlaylistVisibilityPrivate, database.PlaylistVisibilityUnlisted, database.PlaylistVisibilityPublic:
+	default:
+		return fmt.Errorf("Visibility must be one of %q, %q or %q",
+			database.PlaylistVisibilityPrivate, database.PlaylistVisibilityUnlisted, database.PlaylistVisibilityPublic)
+	}
+	return nil
+}
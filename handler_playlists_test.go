@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func TestOwnedPlaylistHidesOtherUsersPlaylists(t *testing.T) {
+	cfg := newTestAPIConfig(t)
+	alice := testUser(t, cfg, "alice@example.com")
+	bob := testUser(t, cfg, "bob@example.com")
+	playlist, err := cfg.db.CreatePlaylist(database.CreatePlaylistParams{Title: "mix", Visibility: database.PlaylistVisibilityPublic, UserID: alice.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("PATCH /api/playlists/{playlistID}", cfg.requireAuth(auth.ScopeVideosWrite, cfg.handlerPlaylistUpdate))
+	mux.Handle("DELETE /api/playlists/{playlistID}", cfg.requireAuth(auth.ScopeVideosWrite, cfg.handlerPlaylistDelete))
+
+	tests := []struct {
+		name       string
+		method     string
+		playlistID uuid.UUID
+		userID     uuid.UUID
+		wantStatus int
+	}{
+		{"update someone else's", "PATCH", playlist.ID, bob.ID, http.StatusNotFound},
+		{"delete someone else's", "DELETE", playlist.ID, bob.ID, http.StatusNotFound},
+		{"delete missing", "DELETE", uuid.New(), alice.ID, http.StatusNotFound},
+		{"update own", "PATCH", playlist.ID, alice.ID, http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, "/api/playlists/"+tt.playlistID.String(), strings.NewReader(`{"title":"renamed"}`))
+		req.Header.Set("Authorization", "Bearer "+testAccessToken(t, cfg, tt.userID, nil))
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != tt.wantStatus {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, rec.Code, tt.wantStatus, rec.Body)
+		}
+	}
+}
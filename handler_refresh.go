@@ -1,15 +1,18 @@
 package main
 
 import (
+	"errors"
 	"net/http"
 	"time"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
 )
 
 func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 	type response struct {
-		Token string `json:"token"`
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
 	}
 
 	refreshToken, err := auth.GetBearerToken(r.Header)
@@ -18,27 +21,79 @@ func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := cfg.db.GetUserByRefreshToken(refreshToken)
+	stored, err := cfg.db.GetRefreshToken(refreshToken)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't get user for refresh token", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get refresh token", err)
+		return
+	}
+	if stored.Token == "" {
+		respondWithError(w, http.StatusUnauthorized, "Invalid refresh token", nil)
+		return
+	}
+	auditActor(r, stored.UserID)
+	if stored.RevokedAt != nil {
+		// A revoked token being presented again means it was most likely
+		// stolen, so log out every session descended from the same login.
+		cfg.revokeRefreshTokenFamily(w, r, stored)
+		return
+	}
+	if time.Now().UTC().After(stored.ExpiresAt) {
+		respondWithError(w, http.StatusUnauthorized, "Refresh token has expired", nil)
 		return
 	}
 
+	newRefreshToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create refresh token", err)
+		return
+	}
+	_, err = cfg.db.RotateRefreshToken(refreshToken, database.CreateRefreshTokenParams{
+		Token:     newRefreshToken,
+		UserID:    stored.UserID,
+		ExpiresAt: time.Now().UTC().Add(cfg.tokens.RefreshTTL),
+		UserAgent: r.UserAgent(),
+		IP:        clientIP(r),
+	})
+	if errors.Is(err, database.ErrRefreshTokenReused) {
+		cfg.revokeRefreshTokenFamily(w, r, stored)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't rotate refresh token", err)
+		return
+	}
+
+	scopes := stored.Scopes
+	if len(scopes) == 0 {
+		scopes = auth.UserScopes
+	}
 	accessToken, err := auth.MakeJWT(
-		user.ID,
-		cfg.jwtSecret,
-		time.Hour,
+		stored.UserID,
+		cfg.jwtKeys,
+		cfg.tokens.RefreshedAccessTTL,
+		scopes,
 	)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate token", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create access JWT", err)
 		return
 	}
 
 	respondWithJSON(w, http.StatusOK, response{
-		Token: accessToken,
+		Token:        accessToken,
+		RefreshToken: newRefreshToken,
 	})
 }
 
+func (cfg *apiConfig) revokeRefreshTokenFamily(w http.ResponseWriter, r *http.Request, reused database.RefreshToken) {
+	auditDetail(r, "reason", "refresh_token_reused")
+	err := cfg.db.RevokeRefreshTokenFamily(reused.UserID, reused.FamilyID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions", err)
+		return
+	}
+	respondWithError(w, http.StatusUnauthorized, "Refresh token has already been used", nil)
+}
+
 func (cfg *apiConfig) handlerRevoke(w http.ResponseWriter, r *http.Request) {
 	refreshToken, err := auth.GetBearerToken(r.Header)
 	if err != nil {
@@ -46,6 +101,15 @@ func (cfg *apiConfig) handlerRevoke(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	stored, err := cfg.db.GetRefreshToken(refreshToken)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get refresh token", err)
+		return
+	}
+	if stored.Token != "" {
+		auditActor(r, stored.UserID)
+	}
+
 	err = cfg.db.RevokeRefreshToken(refreshToken)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke session", err)
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHandlerRefresh(t *testing.T) {
+	cfg := newTestAPIConfig(t)
+	user := testUser(t, cfg, "alice@example.com")
+	later := time.Now().UTC().Add(time.Hour)
+
+	refresh := func(token string) (int, string) {
+		t.Helper()
+		req := httptest.NewRequest("POST", "/api/refresh", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		cfg.handlerRefresh(rec, req)
+		var resp struct {
+			RefreshToken string `json:"refresh_token"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec.Code, resp.RefreshToken
+	}
+
+	login := testRefreshToken(t, cfg, user.ID, later)
+	otherLogin := testRefreshToken(t, cfg, user.ID, later)
+	code, rotated := refresh(login)
+	if code != http.StatusOK || rotated == "" || rotated == login {
+		t.Fatalf("refresh: status %d, new token %q", code, rotated)
+	}
+	code, rotatedAgain := refresh(rotated)
+	if code != http.StatusOK {
+		t.Fatalf("refreshing the rotated token: status %d", code)
+	}
+
+	// Replaying a rotated token logs out the whole session, including the
+	// token that replaced it, but not other sessions.
+	if code, _ := refresh(login); code != http.StatusUnauthorized {
+		t.Errorf("replayed token: status %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code, _ := refresh(rotatedAgain); code != http.StatusUnauthorized {
+		t.Errorf("latest token after a replay: status %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code, _ := refresh(otherLogin); code != http.StatusOK {
+		t.Errorf("other session after a replay: status %d, want %d", code, http.StatusOK)
+	}
+
+	expired := testRefreshToken(t, cfg, user.ID, time.Now().UTC().Add(-time.Minute))
+	if code, _ := refresh(expired); code != http.StatusUnauthorized {
+		t.Errorf("expired token: status %d, want %d", code, http.StatusUnauthorized)
+	}
+
+	revoked := testRefreshToken(t, cfg, user.ID, later)
+	req := httptest.NewRequest("POST", "/api/revoke", nil)
+	req.Header.Set("Authorization", "Bearer "+revoked)
+	rec := httptest.NewRecorder()
+	cfg.handlerRevoke(rec, req)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("revoke: status %d", rec.Code)
+	}
+	if code, _ := refresh(revoked); code != http.StatusUnauthorized {
+		t.Errorf("revoked token: status %d, want %d", code, http.StatusUnauthorized)
+	}
+
+	if code, _ := refresh("unknown"); code != http.StatusUnauthorized {
+		t.Errorf("unknown token: status %d, want %d", code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	maxTagLength    = 32
+	maxTagsPerVideo = 20
+)
+
+func (cfg *apiConfig) handlerVideoTagsSet(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Tags []string `json:"tags"`
+	}
+	type response struct {
+		Tags []string `json:"tags"`
+	}
+
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	userID := requestUser(r).ID
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	tags, err := normalizeTags(params.Tags)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	if len(tags) > maxTagsPerVideo {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("A video can have at most %d tags", maxTagsPerVideo), nil)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	role, err := cfg.videoRole(video, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check video permissions", err)
+		return
+	}
+	if !hasVideoRole(role, database.VideoRoleEditor) {
+		respondWithError(w, http.StatusForbidden, "You can't tag this video", nil)
+		return
+	}
+
+	tags, err = cfg.db.SetVideoTags(videoID, tags)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't set tags", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		Tags: tags,
+	})
+}
+
+func (cfg *apiConfig) handlerVideoTagDelete(w http.ResponseWriter, r *http.Request) {
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	tag, err := normalizeTag(r.PathValue("tag"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	userID := requestUser(r).ID
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	role, err := cfg.videoRole(video, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check video permissions", err)
+		return
+	}
+	if !hasVideoRole(role, database.VideoRoleEditor) {
+		respondWithError(w, http.StatusForbidden, "You can't untag this video", nil)
+		return
+	}
+
+	err = cfg.db.RemoveVideoTag(videoID, tag)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't remove tag", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerTagsList(w http.ResponseWriter, r *http.Request) {
+	userID := requestUser(r).ID
+
+	counts, err := cfg.db.GetTagCounts(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve tags", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, counts)
+}
+
+// normalizeTag lowercases and trims a tag name and checks that it is usable.
+func normalizeTag(tag string) (string, error) {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if tag == "" {
+		return "", errors.New("Tags can't be empty")
+	}
+	if utf8.RuneCountInString(tag) > maxTagLength {
+		return "", fmt.Errorf("Tags must be at most %d characters", maxTagLength)
+	}
+	if strings.ContainsAny(tag, ",/") {
+		return "", errors.New("Tags can't contain ',' or '/'")
+	}
+	return tag, nil
+}
+
+// normalizeTags normalizes every tag in the list, dropping duplicates and
+// returning the result sorted.
+func normalizeTags(tags []string) ([]string, error) {
+	seen := map[string]bool{}
+	normalized := []string{}
+	for _, tag := range tags {
+		tag, err := normalizeTag(tag)
+		if err != nil {
+			return nil, err
+		}
+		if seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	sort.Strings(normalized)
+	return normalized, nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/google/uuid"
+)
+
+func TestNormalizeTags(t *testing.T) {
+	tests := []struct {
+		name    string
+		tags    []string
+		want    []string
+		wantErr bool
+	}{
+		{"none", nil, []string{}, false},
+		{"trims, lowercases and sorts", []string{" Travel ", "cats"}, []string{"cats", "travel"}, false},
+		{"drops duplicates", []string{"Cats", "cats", " CATS"}, []string{"cats"}, false},
+		{"keeps unicode", []string{"Ünïcode"}, []string{"ünïcode"}, false},
+		{"longest tag", []string{strings.Repeat("é", maxTagLength)}, []string{strings.Repeat("é", maxTagLength)}, false},
+		{"too long", []string{strings.Repeat("a", maxTagLength+1)}, nil, true},
+		{"empty", []string{"cats", "  "}, nil, true},
+		{"comma", []string{"cats,dogs"}, nil, true},
+		{"slash", []string{"cats/dogs"}, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := normalizeTags(tt.tags)
+		if (err != nil) != tt.wantErr || !slices.Equal(got, tt.want) {
+			t.Errorf("%s: normalizeTags(%q) = %q, %v, want %q, error %v", tt.name, tt.tags, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestHandlerVideoTagsSetLimitsTags(t *testing.T) {
+	cfg := newTestAPIConfig(t)
+	user := testUser(t, cfg, "alice@example.com")
+	mux := http.NewServeMux()
+	mux.Handle("PUT /api/videos/{videoID}/tags", cfg.requireAuth(auth.ScopeVideosWrite, cfg.handlerVideoTagsSet))
+
+	tags := make([]string, maxTagsPerVideo+1)
+	for i := range tags {
+		tags[i] = fmt.Sprintf("%q", fmt.Sprint("tag", i))
+	}
+	body := `{"tags":[` + strings.Join(tags, ",") + `]}`
+	req := httptest.NewRequest("PUT", "/api/videos/"+uuid.NewString()+"/tags", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+testAccessToken(t, cfg, user.ID, nil))
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "at most") {
+		t.Errorf("%d tags: status %d: %s", len(tags), rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+const (
+	recoveryCodeCount = 10
+	totpIssuer        = "Tubely"
+)
+
+// handlerTOTPEnroll starts setting up an authenticator app. Two-factor
+// authentication isn't turned on until a code from it is confirmed.
+func (cfg *apiConfig) handlerTOTPEnroll(w http.ResponseWriter, r *http.Request) {
+	user := requestUser(r)
+
+	factor, err := cfg.db.GetTOTPFactor(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't look up two-factor authentication", err)
+		return
+	}
+	if factor.ConfirmedAt != nil {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is already on, turn it off first", nil)
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate secret", err)
+		return
+	}
+	err = cfg.db.SetTOTPFactor(user.ID, secret)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save secret", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, struct {
+		Secret     string `json:"secret"`
+		OTPAuthURI string `json:"otpauth_uri"`
+	}{
+		Secret:     secret,
+		OTPAuthURI: auth.TOTPURI(secret, totpIssuer, user.Email),
+	})
+}
+
+// handlerTOTPConfirm turns two-factor authentication on once the user proves
+// their authenticator app works, and responds with their recovery codes.
+func (cfg *apiConfig) handlerTOTPConfirm(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Code string `json:"code"`
+	}
+
+	user := requestUser(r)
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	factor, err := cfg.db.GetTOTPFactor(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't look up two-factor authentication", err)
+		return
+	}
+	if factor.Secret == "" {
+		respondWithError(w, http.StatusBadRequest, "Set up an authenticator app first", nil)
+		return
+	}
+	if factor.ConfirmedAt != nil {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is already on", nil)
+		return
+	}
+
+	step, ok, err := auth.ValidateTOTP(factor.Secret, params.Code, time.Now(), factor.LastUsedStep)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check code", err)
+		return
+	}
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "Incorrect two-factor code", nil)
+		return
+	}
+
+	codes, hashes, err := makeRecoveryCodes()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate recovery codes", err)
+		return
+	}
+	err = cfg.db.ConfirmTOTPFactor(user.ID, step, hashes)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't turn on two-factor authentication", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, recoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// handlerTOTPDelete turns two-factor authentication off. It takes a current
+// code so a stolen session alone isn't enough.
+func (cfg *apiConfig) handlerTOTPDelete(w http.ResponseWriter, r *http.Request) {
+	factor, ok := cfg.secondFactorFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	err := cfg.db.DeleteTOTPFactor(factor.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't turn off two-factor authentication", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerRecoveryCodesRegenerate replaces the user's recovery codes, for when
+// they've used most of them up or lost them.
+func (cfg *apiConfig) handlerRecoveryCodesRegenerate(w http.ResponseWriter, r *http.Request) {
+	factor, ok := cfg.secondFactorFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	codes, hashes, err := makeRecoveryCodes()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate recovery codes", err)
+		return
+	}
+	err = cfg.db.SetRecoveryCodes(factor.UserID, hashes)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save recovery codes", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, recoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// handlerLoginTwoFactor finishes a login started with handlerLogin by
+// exchanging its challenge token and a second factor for the session tokens.
+func (cfg *apiConfig) handlerLoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		ChallengeToken string `json:"challenge_token"`
+		Code           string `json:"code"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	userID, scopes, err := auth.ValidateChallengeToken(params.ChallengeToken, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Login has expired, start again", err)
+		return
+	}
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't look up user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusUnauthorized, "Login has expired, start again", nil)
+		return
+	}
+	auditActor(r, user.ID)
+
+	// Wrong codes count against the same limits as wrong passwords.
+	failure := database.CreateLoginFailureParams{
+		Email:     user.Email,
+		UserID:    &user.ID,
+		IP:        clientIP(r),
+		UserAgent: r.UserAgent(),
+	}
+	if !cfg.checkLoginThrottle(w, r, failure) {
+		return
+	}
+
+	factor, err := cfg.db.GetTOTPFactor(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't look up two-factor authentication", err)
+		return
+	}
+	if factor.ConfirmedAt == nil {
+		// Two-factor authentication was turned off since the password was
+		// checked, the user has to start again for the new setting to apply.
+		respondWithError(w, http.StatusUnauthorized, "Login has expired, start again", nil)
+		return
+	}
+
+	ok, err := cfg.checkSecondFactor(factor, params.Code)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check code", err)
+		return
+	}
+	if !ok {
+		failure.Reason = database.LoginFailureBadSecondFactor
+		cfg.respondWithLoginFailure(w, r, failure, nil)
+		return
+	}
+
+	err = cfg.recordLoginSuccess(user.Email)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't record login", err)
+		return
+	}
+
+	cfg.respondWithNewSession(w, r, *user, scopes)
+}
+
+// respondWithTwoFactorChallenge responds to a correct password for a user with
+// two-factor authentication on. No session is started yet.
+func (cfg *apiConfig) respondWithTwoFactorChallenge(w http.ResponseWriter, user database.User, scopes []string) {
+	challenge, err := auth.MakeChallengeToken(user.ID, cfg.jwtKeys, cfg.tokens.TwoFactorChallengeTTL, scopes)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create challenge token", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, struct {
+		TwoFactorRequired bool   `json:"two_factor_required"`
+		ChallengeToken    string `json:"challenge_token"`
+	}{
+		TwoFactorRequired: true,
+		ChallengeToken:    challenge,
+	})
+}
+
+// secondFactorFromRequest checks the code in the body of a request to change
+// the authenticated user's two-factor settings, throttled like logins. It
+// writes an error response and returns false unless two-factor authentication
+// is on and the code is right.
+func (cfg *apiConfig) secondFactorFromRequest(w http.ResponseWriter, r *http.Request) (database.TOTPFactor, bool) {
+	type parameters struct {
+		Code string `json:"code"`
+	}
+
+	user := requestUser(r)
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return database.TOTPFactor{}, false
+	}
+
+	// Wrong codes count against the same limits as at login, otherwise a
+	// stolen access token would be enough to guess them.
+	failure := database.CreateLoginFailureParams{
+		Email:     user.Email,
+		UserID:    &user.ID,
+		IP:        clientIP(r),
+		UserAgent: r.UserAgent(),
+	}
+	if !cfg.checkLoginThrottle(w, r, failure) {
+		return database.TOTPFactor{}, false
+	}
+
+	factor, err := cfg.db.GetTOTPFactor(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't look up two-factor authentication", err)
+		return database.TOTPFactor{}, false
+	}
+	if factor.ConfirmedAt == nil {
+		respondWithError(w, http.StatusNotFound, "Two-factor authentication isn't on", nil)
+		return database.TOTPFactor{}, false
+	}
+
+	ok, err := cfg.checkSecondFactor(factor, params.Code)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check code", err)
+		return database.TOTPFactor{}, false
+	}
+	if !ok {
+		failure.Reason = database.LoginFailureBadSecondFactor
+		auditDetail(r, "reason", failure.Reason)
+		if err := cfg.recordLoginFailure(failure); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't record login failure", err)
+			return database.TOTPFactor{}, false
+		}
+		respondWithError(w, http.StatusForbidden, "Incorrect two-factor code", nil)
+		return database.TOTPFactor{}, false
+	}
+	err = cfg.recordLoginSuccess(user.Email)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't record login", err)
+		return database.TOTPFactor{}, false
+	}
+	return factor, true
+}
+
+// checkSecondFactor accepts either a code from the user's authenticator app or
+// one of their recovery codes, using it up.
+func (cfg *apiConfig) checkSecondFactor(factor database.TOTPFactor, code string) (bool, error) {
+	if isTOTPCode(code) {
+		step, ok, err := auth.ValidateTOTP(factor.Secret, code, time.Now(), factor.LastUsedStep)
+		if err != nil || !ok {
+			return false, err
+		}
+		// Another request may have used the same code in the meantime.
+		return cfg.db.UseTOTPStep(factor.UserID, step)
+	}
+
+	return cfg.db.UseRecoveryCode(factor.UserID, auth.HashToken(auth.NormalizeRecoveryCode(code)))
+}
+
+func isTOTPCode(code string) bool {
+	if len(code) != 6 {
+		return false
+	}
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+type recoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// makeRecoveryCodes returns a new set of recovery codes to show the user once
+// and the hashes to store.
+func makeRecoveryCodes() (codes, hashes []string, err error) {
+	for range recoveryCodeCount {
+		code, err := auth.MakeRecoveryCode()
+		if err != nil {
+			return nil, nil, err
+		}
+		codes = append(codes, code)
+		hashes = append(hashes, auth.HashToken(auth.NormalizeRecoveryCode(code)))
+	}
+	return codes, hashes, nil
+}
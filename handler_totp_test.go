@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+)
+
+func TestTOTPSettingsAreThrottled(t *testing.T) {
+	cfg := newTestAPIConfig(t)
+	cfg.loginThrottle = defaultLoginThrottlePolicy
+	user := testUser(t, cfg, "alice@example.com")
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.db.SetTOTPFactor(user.ID, secret); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.db.ConfirmTOTPFactor(user.ID, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	token := testAccessToken(t, cfg, user.ID, nil)
+
+	mux := http.NewServeMux()
+	mux.Handle("DELETE /api/users/me/totp", cfg.requireAuth(auth.ScopeAccount, cfg.handlerTOTPDelete))
+	mux.Handle("POST /api/users/me/totp/recovery_codes", cfg.requireAuth(auth.ScopeAccount, cfg.handlerRecoveryCodesRegenerate))
+	send := func(method, path, code string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(`{"code": "`+code+`"}`))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Both endpoints count towards the same limit as logging in.
+	for i := range defaultLoginThrottlePolicy.FreeFailures {
+		path := "/api/users/me/totp/recovery_codes"
+		method := "POST"
+		if i%2 == 0 {
+			method, path = "DELETE", "/api/users/me/totp"
+		}
+		if rec := send(method, path, "000000"); rec.Code != http.StatusForbidden {
+			t.Fatalf("bad code %d: status %d, want %d", i+1, rec.Code, http.StatusForbidden)
+		}
+	}
+
+	code, err := auth.TOTPCode(secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := send("DELETE", "/api/users/me/totp", code)
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("right code after too many bad ones: status %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+	if factor, _ := cfg.db.GetTOTPFactor(user.ID); factor.ConfirmedAt == nil {
+		t.Error("two-factor authentication was turned off while locked out")
+	}
+}
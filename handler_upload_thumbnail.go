@@ -3,6 +3,7 @@ package main
 import (
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"mime"
@@ -10,8 +11,9 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
-	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
 	"github.com/google/uuid"
 )
 
@@ -23,31 +25,56 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
+	userID := requestUser(r).ID
+
+	// Retrieve video metadata
+	videoMeta, err := cfg.db.GetVideo(videoID)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		respondWithError(w, http.StatusInternalServerError, "Unable to retrieve video metadata", err)
+		return
+	}
+	if videoMeta.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
 		return
 	}
 
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	// Check if the authenticated user is allowed to edit the video
+	role, err := cfg.videoRole(videoMeta, userID)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		respondWithError(w, http.StatusInternalServerError, "Unable to check video permissions", err)
+		return
+	}
+	if !hasVideoRole(role, database.VideoRoleEditor) {
+		respondWithError(w, http.StatusForbidden, "You do not have permission to upload a thumbnail for this video", nil)
 		return
 	}
 
-	fmt.Println("uploading thumbnail for video", videoID, "by user", userID)
-
-	// TODO: implement the upload here
-	const maxMemory = 10 << 20 // 10 MB
-	r.ParseMultipartForm(maxMemory)
+	// Turn away thumbnails over the limit before reading them
+	maxBytes := cfg.uploads.MaxThumbnailBytes
+	tooLarge := fmt.Sprintf("Thumbnail is larger than the limit of %d bytes", maxBytes)
+	if r.ContentLength > maxBytes+multipartOverhead {
+		respondWithError(w, http.StatusRequestEntityTooLarge, tooLarge, nil)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes+multipartOverhead)
 
 	// "thumbnail" should match the HTML form input name - Extract the file from form data
 	file, header, err := r.FormFile("thumbnail")
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		respondWithError(w, http.StatusRequestEntityTooLarge, tooLarge, err)
+		return
+	}
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, "Unable to parse form file", err)
 		return
 	}
 	defer file.Close()
+	cfg.metrics.uploadSize.WithLabelValues("thumbnail").Observe(float64(header.Size))
+	if header.Size > maxBytes {
+		respondWithError(w, http.StatusRequestEntityTooLarge, tooLarge, nil)
+		return
+	}
 
 	// Extract media type from the uploaded file header
 	mediaType := header.Header.Get("Content-Type")
@@ -67,19 +94,6 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 	// We'll stream the uploaded file directly to disk instead of reading it all into memory.
 	// This avoids using a []byte as an io.Reader and is more memory efficient for larger files.
 
-	// Retrieve video metadata
-	videoMeta, err := cfg.db.GetVideo(videoID)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Unable to retrieve video metadata", err)
-		return
-	}
-
-	// Check if the authenticated user is the owner of the video
-	if videoMeta.UserID != userID {
-		respondWithError(w, http.StatusUnauthorized, "You do not have permission to upload a thumbnail for this video", nil)
-		return
-	}
-
 	// Create thumbnail filepath, ready to store in "assets" directory
 
 	// Fetch file extension from media type
@@ -94,14 +108,21 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 	thumbnailPath := filepath.Join(cfg.assetsRoot, thumbnailFileName)
 
 	// Save the thumbnail file to the assets directory
+	storeStart := time.Now()
+	_, span := startStorageSpan(r.Context(), "local", "put", thumbnailFileName)
 	tnFile, err := os.Create(thumbnailPath)
 	if err != nil {
+		endSpan(span, err)
+		cfg.metrics.observeStorage("local", "put", storeStart, err)
 		respondWithError(w, http.StatusInternalServerError, "Unable to create thumbnail file", err)
 		return
 	}
 	defer tnFile.Close()
 
-	if _, err := io.Copy(tnFile, file); err != nil {
+	_, err = io.Copy(tnFile, file)
+	endSpan(span, err)
+	cfg.metrics.observeStorage("local", "put", storeStart, err)
+	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Unable to save thumbnail file", err)
 		return
 	}
@@ -109,9 +130,7 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 	// Create URL for the thumbnail
 	tnURL := fmt.Sprintf("http://localhost:%s/assets/%s", cfg.port, thumbnailFileName)
 
-	videoMeta.ThumbnailURL = &tnURL
-
-	err = cfg.db.UpdateVideo(videoMeta)
+	videoMeta, err = cfg.db.UpdateVideoThumbnail(videoMeta.ID, tnURL)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Unable to update video thumbnail URL", err)
 		return
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestUploadThumbnailKeepsEditsMadeDuringTheUpload(t *testing.T) {
+	cfg := newTestAPIConfig(t)
+	cfg.metrics = newServerMetrics(cfg.db)
+	cfg.assetsRoot = t.TempDir()
+	cfg.uploads = defaultConfig().Uploads
+	user := testUser(t, cfg, "alice@example.com")
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "Demo", UserID: user.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := testAccessToken(t, cfg, user.ID, nil)
+
+	mux := http.NewServeMux()
+	mux.Handle("POST /api/thumbnail_upload/{videoID}", cfg.requireAuth(auth.ScopeVideosWrite, cfg.handlerUploadThumbnail))
+	mux.Handle("PATCH /api/videos/{videoID}", cfg.requireAuth(auth.ScopeVideosWrite, cfg.handlerVideoMetaUpdate))
+
+	// Stream the upload so the handler has loaded the video by the time the
+	// edit is made.
+	body, bodyWriter := io.Pipe()
+	form := multipart.NewWriter(bodyWriter)
+	req := httptest.NewRequest("POST", "/api/thumbnail_upload/"+video.ID.String(), body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+token)
+	upload := httptest.NewRecorder()
+	uploaded := make(chan struct{})
+	go func() {
+		mux.ServeHTTP(upload, req)
+		close(uploaded)
+	}()
+	part, err := form.CreatePart(textproto.MIMEHeader{
+		"Content-Disposition": {`form-data; name="thumbnail"; filename="thumb.png"`},
+		"Content-Type":        {"image/png"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req = httptest.NewRequest("PATCH", "/api/videos/"+video.ID.String(), strings.NewReader(`{"title": "Renamed"}`))
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("edit: status %d: %s", rec.Code, rec.Body)
+	}
+
+	part.Write([]byte("not really a png"))
+	form.Close()
+	bodyWriter.Close()
+	<-uploaded
+	if upload.Code != http.StatusOK {
+		t.Fatalf("upload: status %d: %s", upload.Code, upload.Body)
+	}
+	var got database.Video
+	if err := json.Unmarshal(upload.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Title != "Renamed" || got.ThumbnailURL == nil {
+		t.Errorf("video after the upload = %+v, want the edit kept and a thumbnail", got)
+	}
+	if stored, _ := cfg.db.GetVideo(video.ID); stored.Title != "Renamed" {
+		t.Errorf("stored title = %q, want %q", stored.Title, "Renamed")
+	}
+}
@@ -1,26 +1,27 @@
 package main
 
 import (
-	"context"
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"mime"
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
 	"github.com/google/uuid"
 )
 
-func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request) {
-	// Limit upload size
-	const maxUpload = 10 << 30 // 1 GB
-	r.Body = http.MaxBytesReader(w, r.Body, maxUpload)
+// multipartOverhead is how much bigger than the file a request to upload
+// it can be, for the multipart boundaries and headers.
+const multipartOverhead = 1 << 20 // 1 MiB
 
+func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request) {
 	// Parse video ID from path
 	videoIDStr := r.PathValue("videoID")
 	videoID, err := uuid.Parse(videoIDStr)
@@ -30,16 +31,7 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 	}
 
 	// Authenticate
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Missing JWT", err)
-		return
-	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Invalid JWT", err)
-		return
-	}
+	userID := requestUser(r).ID
 
 	// Retrieve metadata and check ownership
 	videoMeta, err := cfg.db.GetVideo(videoID)
@@ -51,18 +43,51 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 		respondWithError(w, http.StatusNotFound, "Video not found", nil)
 		return
 	}
-	if videoMeta.UserID != userID {
-		respondWithError(w, http.StatusForbidden, "You don't own this video", nil)
+	role, err := cfg.videoRole(videoMeta, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to check video permissions", err)
+		return
+	}
+	if !hasVideoRole(role, database.VideoRoleEditor) {
+		respondWithError(w, http.StatusForbidden, "You can't upload to this video", nil)
 		return
 	}
 
+	// Limit upload size to the uploader's quota, turning away requests that
+	// are too big before reading them
+	uploaderQuota, err := cfg.userQuota(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to check quota", err)
+		return
+	}
+	tooLarge := fmt.Sprintf("Video is larger than your limit of %d bytes", uploaderQuota.MaxFileBytes)
+	if uploaderQuota.MaxFileBytes > 0 {
+		if r.ContentLength > uploaderQuota.MaxFileBytes+multipartOverhead {
+			respondWithError(w, http.StatusRequestEntityTooLarge, tooLarge, nil)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, uploaderQuota.MaxFileBytes+multipartOverhead)
+	}
+
 	// Get uploaded file
+	receiveStart := time.Now()
 	file, header, err := r.FormFile("video")
+	cfg.metrics.observeUploadStage("receive", receiveStart, err)
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		respondWithError(w, http.StatusRequestEntityTooLarge, tooLarge, err)
+		return
+	}
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, "Unable to parse uploaded file", err)
 		return
 	}
 	defer file.Close()
+	cfg.metrics.uploadSize.WithLabelValues("video").Observe(float64(header.Size))
+	if uploaderQuota.MaxFileBytes > 0 && header.Size > uploaderQuota.MaxFileBytes {
+		respondWithError(w, http.StatusRequestEntityTooLarge, tooLarge, nil)
+		return
+	}
 
 	mediaType := header.Header.Get("Content-Type")
 	mimeType, _, err := mime.ParseMediaType(mediaType)
@@ -75,27 +100,64 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	// Turn away uploads that can't fit before doing any work on them. The
+	// processed file is what gets stored, so it's checked again below.
+	exceeded, err := cfg.checkVideoQuota(videoMeta, header.Size)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to check quota", err)
+		return
+	}
+	if exceeded != "" {
+		respondWithError(w, http.StatusForbidden, exceeded, nil)
+		return
+	}
+
+	cfg.metrics.videoJobs.Inc()
+	defer cfg.metrics.videoJobs.Dec()
+
 	// Save to temp file then run ffprobe-based helper
-	tmp, err := os.CreateTemp("", "tubely-upload-*.mp4")
+	tmp, err := cfg.jobs.createTemp("tubely-upload-*.mp4")
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Unable to create temp file", err)
 		return
 	}
-	defer os.Remove(tmp.Name())
+	defer cfg.jobs.removeTemp(tmp.Name())
 	defer tmp.Close()
 
-	if _, err := io.Copy(tmp, file); err != nil {
+	stageStart := time.Now()
+	_, err = io.Copy(tmp, file)
+	cfg.metrics.observeUploadStage("save", stageStart, err)
+	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Unable to save upload", err)
 		return
 	}
 
+	if uploaderQuota.MaxDurationSeconds > 0 {
+		maxDuration := time.Duration(uploaderQuota.MaxDurationSeconds) * time.Second
+		stageStart = time.Now()
+		duration, err := getVideoDuration(r.Context(), tmp.Name())
+		cfg.metrics.observeUploadStage("probe_duration", stageStart, err)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Unable to determine video duration", err)
+			return
+		}
+		if duration > maxDuration {
+			respondWithError(w, http.StatusForbidden, fmt.Sprintf("Video is longer than your limit of %s", maxDuration), nil)
+			return
+		}
+	}
+
 	// Pre-process video to enable fast start
-	processedVideoPath, err := processVideoForFastStart(tmp.Name())
+	processedVideoPath := tmp.Name() + ".processing"
+	cfg.jobs.trackTemp(processedVideoPath)
+	defer cfg.jobs.removeTemp(processedVideoPath)
+	stageStart = time.Now()
+	err = processVideoForFastStart(r.Context(), tmp.Name(), processedVideoPath)
+	cfg.metrics.observeUploadStage("faststart", stageStart, err)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Unable to process video for fast start", err)
 		return
 	}
-	defer os.Remove(processedVideoPath)
 	tmp.Close() // close original temp file before re-opening processed file
 
 	// Re-open processed video file
@@ -106,8 +168,28 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 	}
 	defer tmp.Close()
 
+	info, err := tmp.Stat()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to stat processed video file", err)
+		return
+	}
+
+	// Check the stored file fits too, less the one it replaces, since it's
+	// what counts towards the quota
+	exceeded, err = cfg.checkVideoQuota(videoMeta, info.Size())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to check quota", err)
+		return
+	}
+	if exceeded != "" {
+		respondWithError(w, http.StatusForbidden, exceeded, nil)
+		return
+	}
+
 	// Determine aspect and construct storage key
-	aspect, err := getVideoAspectRatio(tmp.Name())
+	stageStart = time.Now()
+	aspect, err := getVideoAspectRatio(r.Context(), tmp.Name())
+	cfg.metrics.observeUploadStage("probe_aspect", stageStart, err)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Unable to determine video aspect", err)
 		return
@@ -139,15 +221,21 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 		ContentType: &mimeType,
 	}
 
-	if _, err := cfg.s3Client.PutObject(context.TODO(), params); err != nil {
+	stageStart = time.Now()
+	spanCtx, span := startStorageSpan(r.Context(), "s3", "put", objectKey)
+	_, err = cfg.s3Client.PutObject(spanCtx, params)
+	endSpan(span, err)
+	cfg.metrics.observeUploadStage("store", stageStart, err)
+	cfg.metrics.observeStorage("s3", "put", stageStart, err)
+	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "S3 upload failed", err)
 		return
 	}
 
 	// Update video metadata with Cloudfront URL
 	url := fmt.Sprintf("https://%s/%s", cfg.s3CfDistribution, objectKey)
-	videoMeta.VideoURL = &url
-	if err := cfg.db.UpdateVideo(videoMeta); err != nil {
+	videoMeta, err = cfg.db.UpdateVideoFile(videoMeta.ID, url, info.Size())
+	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Unable to update video metadata", err)
 		return
 	}
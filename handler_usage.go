@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// handlerUsage reports how much of their quota the user's personal library is
+// using. Organization usage is on the organization.
+func (cfg *apiConfig) handlerUsage(w http.ResponseWriter, r *http.Request) {
+	userID := requestUser(r).ID
+
+	q, err := cfg.userQuota(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve quota", err)
+		return
+	}
+	usage, err := cfg.db.GetUserStorageUsage(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve storage usage", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, struct {
+		Usage database.StorageUsage `json:"usage"`
+		Quota quota                 `json:"quota"`
+	}{
+		Usage: usage,
+		Quota: q,
+	})
+}
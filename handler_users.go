@@ -2,10 +2,17 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"net/mail"
+	"slices"
+	"strings"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mailer"
+	"github.com/google/uuid"
 )
 
 func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, r *http.Request) {
@@ -26,6 +33,11 @@ func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, r *http.Request)
 		respondWithError(w, http.StatusBadRequest, "Email and password are required", nil)
 		return
 	}
+	email, err := validateEmail(params.Email)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
 
 	hashedPassword, err := auth.HashPassword(params.Password)
 	if err != nil {
@@ -34,13 +46,263 @@ func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, r *http.Request)
 	}
 
 	user, err := cfg.db.CreateUser(database.CreateUserParams{
-		Email:    params.Email,
+		Email:    email,
 		Password: hashedPassword,
 	})
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create user", err)
 		return
 	}
+	auditActor(r, user.ID)
+
+	// The account is usable without verifying, so a mail problem shouldn't
+	// fail the signup. The user can ask for another email.
+	err = cfg.sendVerificationEmail(r.Context(), *user)
+	if err != nil {
+		requestLogger(r).Error("Couldn't send verification email", "error", err)
+	}
 
 	respondWithJSON(w, http.StatusCreated, user)
 }
+
+// validateEmail trims email and checks it's a bare address like
+// "user@example.com".
+func validateEmail(email string) (string, error) {
+	email = strings.TrimSpace(email)
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Name != "" || addr.Address != email {
+		return "", errors.New("Email must be a valid email address")
+	}
+	return email, nil
+}
+
+func (cfg *apiConfig) handlerUserGet(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, http.StatusOK, requestUser(r))
+}
+
+// handlerUserUpdate changes the user's email address or password. Either
+// change takes the current password, so a stolen access token isn't enough to
+// take over the account.
+func (cfg *apiConfig) handlerUserUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email           string `json:"email"`
+		Password        string `json:"password"`
+		CurrentPassword string `json:"current_password"`
+	}
+
+	user := requestUser(r)
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	email := user.Email
+	if params.Email != "" {
+		email, err = validateEmail(params.Email)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error(), err)
+			return
+		}
+	}
+	emailChanged := email != user.Email
+	if !emailChanged && params.Password == "" {
+		respondWithError(w, http.StatusBadRequest, "Nothing to change, give a new email or password", nil)
+		return
+	}
+
+	if !cfg.checkCurrentPassword(w, r, user, params.CurrentPassword) {
+		return
+	}
+
+	if emailChanged {
+		existing, err := cfg.db.GetUserByEmail(email)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't look up email", err)
+			return
+		}
+		if existing.ID != uuid.Nil {
+			respondWithError(w, http.StatusConflict, "Email is already in use", nil)
+			return
+		}
+	}
+
+	if params.Password != "" {
+		hashedPassword, err := auth.HashPassword(params.Password)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't hash password", err)
+			return
+		}
+		err = cfg.db.UpdateUserPassword(user.ID, hashedPassword)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update password", err)
+			return
+		}
+		// Like a password reset, this logs out every session, the caller's
+		// included, as an access token doesn't say which one it came from.
+		// Access tokens already issued keep working until they expire.
+		err = cfg.db.RevokeUserRefreshTokens(user.ID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions", err)
+			return
+		}
+	}
+
+	if emailChanged {
+		err = cfg.db.UpdateUserEmail(user.ID, email)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update email", err)
+			return
+		}
+	}
+
+	updated, err := cfg.db.GetUser(user.ID)
+	if err != nil || updated == nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve user", err)
+		return
+	}
+
+	if emailChanged {
+		// As with signing up, the change stands even if mail fails, the user
+		// can ask for another verification email.
+		err = cfg.sendVerificationEmail(r.Context(), *updated)
+		if err != nil {
+			requestLogger(r).Error("Couldn't send verification email", "error", err)
+		}
+		err = cfg.mailer.Send(r.Context(), mailer.Message{
+			To:      user.Email,
+			Subject: "Your Tubely email address was changed",
+			Body: "The email address of your Tubely account was changed to " + email + ".\n\n" +
+				"If it wasn't you, reset your password and contact us.\n",
+		})
+		if err != nil {
+			requestLogger(r).Error("Couldn't send email change notice", "error", err)
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, updated)
+}
+
+// handlerUserDelete deletes the user's account along with their videos and
+// the files stored for them. Organizations the user is the only member of go
+// with it, but they have to hand over any others they're the last admin of.
+func (cfg *apiConfig) handlerUserDelete(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		CurrentPassword string `json:"current_password"`
+	}
+
+	user := requestUser(r)
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	if !cfg.checkCurrentPassword(w, r, user, params.CurrentPassword) {
+		return
+	}
+
+	memberships, err := cfg.db.GetOrgsForUser(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve organizations", err)
+		return
+	}
+	soleOrgIDs := []uuid.UUID{}
+	for _, m := range memberships {
+		members, err := cfg.db.GetOrgMembers(m.ID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve members", err)
+			return
+		}
+		if len(members) == 1 {
+			soleOrgIDs = append(soleOrgIDs, m.ID)
+			continue
+		}
+		ok, err := cfg.keepsAnAdmin(m.ID, user.ID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve members", err)
+			return
+		}
+		if !ok {
+			respondWithError(w, http.StatusConflict, fmt.Sprintf("Make someone else an admin of %q first", m.Name), nil)
+			return
+		}
+	}
+
+	videos, err := cfg.db.GetVideos(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+		return
+	}
+	// Org videos stay with the organization unless it's going too.
+	videos = slices.DeleteFunc(videos, func(v database.Video) bool { return v.OrgID != nil })
+	for _, orgID := range soleOrgIDs {
+		orgVideos, err := cfg.db.ListVideos(database.ListVideosParams{OrgID: &orgID})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+			return
+		}
+		videos = append(videos, orgVideos...)
+	}
+
+	// Files go first: if deleting one fails the account is still there to
+	// try again, rather than leaving files nothing points to.
+	for _, video := range videos {
+		err = cfg.deleteVideoFiles(r.Context(), video)
+		if err != nil {
+			respondWithError(w, http.StatusBadGateway, "Couldn't delete video files, try again", err)
+			return
+		}
+	}
+
+	err = cfg.db.DeleteUserAccount(user.ID, soleOrgIDs)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete account", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkCurrentPassword confirms a sensitive change with the user's password.
+// Wrong guesses count against the login limits. It writes the error response
+// and returns false if the change shouldn't go ahead.
+func (cfg *apiConfig) checkCurrentPassword(w http.ResponseWriter, r *http.Request, user database.User, password string) bool {
+	if user.Password == "" {
+		respondWithError(w, http.StatusBadRequest, "Your account has no password yet, set one with a password reset first", nil)
+		return false
+	}
+	if password == "" {
+		respondWithError(w, http.StatusBadRequest, "Current password is required", nil)
+		return false
+	}
+
+	failure := database.CreateLoginFailureParams{
+		Email:     user.Email,
+		UserID:    &user.ID,
+		IP:        clientIP(r),
+		UserAgent: r.UserAgent(),
+	}
+	if !cfg.checkLoginThrottle(w, r, failure) {
+		return false
+	}
+
+	match, err := auth.CheckPasswordHash(password, user.Password)
+	if err != nil || !match {
+		failure.Reason = database.LoginFailureBadPassword
+		recordErr := cfg.recordLoginFailure(failure)
+		if recordErr != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't record login failure", recordErr)
+			return false
+		}
+		respondWithError(w, http.StatusForbidden, "Current password is incorrect", err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+)
+
+func TestUserUpdatePasswordLogsOutEverySession(t *testing.T) {
+	cfg := newTestAPIConfig(t)
+	user := testUser(t, cfg, "user@example.com")
+	hash, err := auth.HashPassword("old password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.db.UpdateUserPassword(user.ID, hash); err != nil {
+		t.Fatal(err)
+	}
+	expiresAt := time.Now().Add(time.Hour)
+	callers := testRefreshToken(t, cfg, user.ID, expiresAt)
+	others := testRefreshToken(t, cfg, user.ID, expiresAt)
+
+	mux := http.NewServeMux()
+	mux.Handle("PATCH /api/users/me", cfg.requireAuth(auth.ScopeAccount, cfg.handlerUserUpdate))
+	body := `{"password": "new password", "current_password": "old password"}`
+	req := httptest.NewRequest("PATCH", "/api/users/me", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+testAccessToken(t, cfg, user.ID, nil))
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("changing password: status %d: %s", rec.Code, rec.Body)
+	}
+
+	for name, token := range map[string]string{"caller's": callers, "other": others} {
+		stored, err := cfg.db.GetRefreshToken(token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.RevokedAt == nil {
+			t.Errorf("%s session wasn't logged out", name)
+		}
+	}
+}
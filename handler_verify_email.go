@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func (cfg *apiConfig) handlerVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token string `json:"token"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	token, err := cfg.db.ConsumeUserToken(auth.HashToken(params.Token), database.UserTokenVerifyEmail)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check token", err)
+		return
+	}
+	if token.TokenHash == "" {
+		respondWithError(w, http.StatusBadRequest, "Token is invalid, expired or already used", nil)
+		return
+	}
+	auditActor(r, token.UserID)
+
+	// The token only proves ownership of the address it was sent to, which
+	// may no longer be the user's.
+	err = cfg.db.MarkEmailVerified(token.UserID, token.Email)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't verify email", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerVerifyEmailResend(w http.ResponseWriter, r *http.Request) {
+	user := requestUser(r)
+	if user.EmailVerifiedAt != nil {
+		respondWithError(w, http.StatusBadRequest, "Email is already verified", nil)
+		return
+	}
+
+	err := cfg.sendVerificationEmail(r.Context(), user)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't send verification email", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -2,9 +2,12 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"strings"
+	"unicode/utf8"
 
-	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
 	"github.com/google/uuid"
 )
@@ -14,31 +17,60 @@ func (cfg *apiConfig) handlerVideoMetaCreate(w http.ResponseWriter, r *http.Requ
 		database.CreateVideoParams
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
-		return
-	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
-		return
-	}
+	userID := requestUser(r).ID
 
 	decoder := json.NewDecoder(r.Body)
 	params := parameters{}
-	err = decoder.Decode(&params)
+	err := decoder.Decode(&params)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters", err)
 		return
 	}
 	params.UserID = userID
 
+	if params.OrgID != nil {
+		member, err := cfg.db.GetOrgMember(*params.OrgID, userID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check organization membership", err)
+			return
+		}
+		if member.Role == "" {
+			respondWithError(w, http.StatusForbidden, "You aren't a member of this organization", nil)
+			return
+		}
+		exceeded, err := cfg.checkOrgQuota(*params.OrgID, 1, 0)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check organization quota", err)
+			return
+		}
+		if exceeded != "" {
+			respondWithError(w, http.StatusForbidden, exceeded, nil)
+			return
+		}
+	} else {
+		exceeded, err := cfg.checkUserQuota(userID, 1, 0)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check quota", err)
+			return
+		}
+		if exceeded != "" {
+			respondWithError(w, http.StatusForbidden, exceeded, nil)
+			return
+		}
+	}
+
+	params.Title, params.Description, err = validateVideoMeta(params.Title, params.Description)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
 	video, err := cfg.db.CreateVideo(params.CreateVideoParams)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create video", err)
 		return
 	}
+	auditTarget(r, "video:"+video.ID.String())
 
 	respondWithJSON(w, http.StatusCreated, video)
 }
@@ -51,24 +83,24 @@ func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
+	userID := requestUser(r).ID
+
+	video, err := cfg.db.GetVideo(videoID)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
 		return
 	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
 		return
 	}
-
-	video, err := cfg.db.GetVideo(videoID)
+	role, err := cfg.videoRole(video, userID)
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check video permissions", err)
 		return
 	}
-	if video.UserID != userID {
-		respondWithError(w, http.StatusForbidden, "You can't delete this video", err)
+	if !hasVideoRole(role, database.VideoRoleOwner) {
+		respondWithError(w, http.StatusForbidden, "You can't delete this video", nil)
 		return
 	}
 
@@ -91,26 +123,59 @@ func (cfg *apiConfig) handlerVideoGet(w http.ResponseWriter, r *http.Request) {
 
 	video, err := cfg.db.GetVideo(videoID)
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	role, err := cfg.videoRole(video, requestUser(r).ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check video permissions", err)
+		return
+	}
+	if !hasVideoRole(role, database.VideoRoleViewer) {
+		respondWithError(w, http.StatusForbidden, "You don't have access to this video", nil)
 		return
 	}
 
+	w.Header().Set("ETag", videoETag(video))
 	respondWithJSON(w, http.StatusOK, video)
 }
 
 func (cfg *apiConfig) handlerVideosRetrieve(w http.ResponseWriter, r *http.Request) {
-	token, err := auth.GetBearerToken(r.Header)
+	userID := requestUser(r).ID
+
+	tags, err := normalizeTags(r.URL.Query()["tag"])
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
 		return
 	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
-		return
+
+	listParams := database.ListVideosParams{
+		UserID: userID,
+		Tags:   tags,
+	}
+	if orgIDString := r.URL.Query().Get("org"); orgIDString != "" {
+		orgID, err := uuid.Parse(orgIDString)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid organization ID", err)
+			return
+		}
+		member, err := cfg.db.GetOrgMember(orgID, userID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check organization membership", err)
+			return
+		}
+		if member.Role == "" {
+			respondWithError(w, http.StatusForbidden, "You aren't a member of this organization", nil)
+			return
+		}
+		listParams.OrgID = &orgID
 	}
 
-	videos, err := cfg.db.GetVideos(userID)
+	videos, err := cfg.db.ListVideos(listParams)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
 		return
@@ -118,3 +183,117 @@ func (cfg *apiConfig) handlerVideosRetrieve(w http.ResponseWriter, r *http.Reque
 
 	respondWithJSON(w, http.StatusOK, videos)
 }
+
+func (cfg *apiConfig) handlerVideoMetaUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Title       *string `json:"title"`
+		Description *string `json:"description"`
+	}
+
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	userID := requestUser(r).ID
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	role, err := cfg.videoRole(video, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check video permissions", err)
+		return
+	}
+	if !hasVideoRole(role, database.VideoRoleEditor) {
+		respondWithError(w, http.StatusForbidden, "You can't update this video", nil)
+		return
+	}
+
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" && !etagMatches(ifMatch, videoETag(video)) {
+		w.Header().Set("ETag", videoETag(video))
+		respondWithError(w, http.StatusPreconditionFailed, "Video was modified, reload it and try again", nil)
+		return
+	}
+
+	if params.Title != nil {
+		video.Title = *params.Title
+	}
+	if params.Description != nil {
+		video.Description = *params.Description
+	}
+	video.Title, video.Description, err = validateVideoMeta(video.Title, video.Description)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	video, err = cfg.db.UpdateVideoIfUnmodified(video)
+	if errors.Is(err, database.ErrVideoModified) {
+		respondWithError(w, http.StatusPreconditionFailed, "Video was modified, reload it and try again", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+
+	w.Header().Set("ETag", videoETag(video))
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+const (
+	maxVideoTitleLength       = 100
+	maxVideoDescriptionLength = 5000
+)
+
+// validateVideoMeta trims the title and description and checks them against
+// the length limits, returning the cleaned up values.
+func validateVideoMeta(title, description string) (string, string, error) {
+	title = strings.TrimSpace(title)
+	description = strings.TrimSpace(description)
+
+	if title == "" {
+		return "", "", errors.New("Title is required")
+	}
+	if utf8.RuneCountInString(title) > maxVideoTitleLength {
+		return "", "", fmt.Errorf("Title must be at most %d characters", maxVideoTitleLength)
+	}
+	if utf8.RuneCountInString(description) > maxVideoDescriptionLength {
+		return "", "", fmt.Errorf("Description must be at most %d characters", maxVideoDescriptionLength)
+	}
+	return title, description, nil
+}
+
+// videoETag derives an entity tag from the video's updated_at timestamp, which
+// is bumped on every write.
+func videoETag(video database.Video) string {
+	return fmt.Sprintf(`"%x"`, video.UpdatedAt.UnixNano())
+}
+
+// etagMatches reports whether an If-Match header value matches etag.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
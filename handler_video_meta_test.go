@@ -0,0 +1,164 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func TestValidateVideoMeta(t *testing.T) {
+	cases := []struct {
+		name        string
+		title       string
+		description string
+		wantTitle   string
+		wantDesc    string
+		wantErr     bool
+	}{
+		{
+			name:        "trims_whitespace",
+			title:       "  My video \n",
+			description: "\tabout things  ",
+			wantTitle:   "My video",
+			wantDesc:    "about things",
+		},
+		{
+			name:    "empty_title",
+			title:   "   ",
+			wantErr: true,
+		},
+		{
+			name:    "title_too_long",
+			title:   strings.Repeat("a", maxVideoTitleLength+1),
+			wantErr: true,
+		},
+		{
+			name:      "title_limit_counts_runes",
+			title:     strings.Repeat("é", maxVideoTitleLength),
+			wantTitle: strings.Repeat("é", maxVideoTitleLength),
+		},
+		{
+			name:        "description_too_long",
+			title:       "ok",
+			description: strings.Repeat("a", maxVideoDescriptionLength+1),
+			wantErr:     true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			title, desc, err := validateVideoMeta(tc.title, tc.description)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if title != tc.wantTitle || desc != tc.wantDesc {
+				t.Fatalf("got (%q, %q) want (%q, %q)", title, desc, tc.wantTitle, tc.wantDesc)
+			}
+		})
+	}
+}
+
+func TestEtagMatches(t *testing.T) {
+	tests := []struct {
+		header string
+		etag   string
+		want   bool
+	}{
+		{`"abc"`, `"abc"`, true},
+		{`"abc"`, `"def"`, false},
+		{`"def", "abc"`, `"abc"`, true},
+		{`*`, `"abc"`, true},
+	}
+
+	for _, tt := range tests {
+		if got := etagMatches(tt.header, tt.etag); got != tt.want {
+			t.Fatalf("etagMatches(%s, %s) = %v, want %v", tt.header, tt.etag, got, tt.want)
+		}
+	}
+}
+
+func TestHandlerVideoGetChecksAccess(t *testing.T) {
+	cfg := newTestAPIConfig(t)
+	owner := testUser(t, cfg, "owner@example.com")
+	viewer := testUser(t, cfg, "viewer@example.com")
+	stranger := testUser(t, cfg, "stranger@example.com")
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "Private", UserID: owner.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cfg.db.SetVideoCollaborator(video.ID, viewer.ID, database.VideoRoleViewer); err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /api/videos/{videoID}", cfg.requireAuth(auth.ScopeVideosRead, cfg.handlerVideoGet))
+	tests := []struct {
+		name    string
+		videoID uuid.UUID
+		userID  uuid.UUID
+		want    int
+	}{
+		{"owner", video.ID, owner.ID, http.StatusOK},
+		{"collaborator", video.ID, viewer.ID, http.StatusOK},
+		{"stranger", video.ID, stranger.ID, http.StatusForbidden},
+		{"unauthenticated", video.ID, uuid.Nil, http.StatusUnauthorized},
+		{"missing", uuid.New(), owner.ID, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/api/videos/"+tt.videoID.String(), nil)
+		if tt.userID != uuid.Nil {
+			req.Header.Set("Authorization", "Bearer "+testAccessToken(t, cfg, tt.userID, auth.UserScopes))
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, rec.Code, tt.want)
+		}
+	}
+}
+
+func TestHandlersRespondNotFoundForMissingVideos(t *testing.T) {
+	cfg := newTestAPIConfig(t)
+	owner := testUser(t, cfg, "owner@example.com")
+	stranger := testUser(t, cfg, "stranger@example.com")
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "Private", UserID: owner.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("DELETE /api/videos/{videoID}", cfg.requireAuth(auth.ScopeVideosWrite, cfg.handlerVideoMetaDelete))
+	mux.Handle("POST /api/thumbnail_upload/{videoID}", cfg.requireAuth(auth.ScopeVideosWrite, cfg.handlerUploadThumbnail))
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		videoID uuid.UUID
+		want    int
+	}{
+		{"delete missing", "DELETE", "/api/videos/", uuid.New(), http.StatusNotFound},
+		{"delete someone else's", "DELETE", "/api/videos/", video.ID, http.StatusForbidden},
+		{"thumbnail for missing", "POST", "/api/thumbnail_upload/", uuid.New(), http.StatusNotFound},
+		{"thumbnail for someone else's", "POST", "/api/thumbnail_upload/", video.ID, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path+tt.videoID.String(), nil)
+		req.Header.Set("Authorization", "Bearer "+testAccessToken(t, cfg, stranger.ID, auth.UserScopes))
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, rec.Code, tt.want, rec.Body)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package auth
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -18,8 +19,36 @@ type TokenType string
 
 const (
 	TokenTypeAccess TokenType = "tubely-access"
+	// TokenTypeTwoFactorChallenge tokens prove a user got their password
+	// right and can only be exchanged, along with a second factor, for an
+	// access token.
+	TokenTypeTwoFactorChallenge TokenType = "tubely-2fa-challenge"
 )
 
+// Scopes limit what a credential may be used for.
+const (
+	ScopeVideosRead  = "videos:read"
+	ScopeVideosWrite = "videos:write"
+	// ScopeAccount covers managing the account itself (sessions, API keys,
+	// organizations). It can't be granted to API keys.
+	ScopeAccount = "account"
+)
+
+// APIKeyScopes are the scopes an API key can be granted.
+var APIKeyScopes = []string{ScopeVideosRead, ScopeVideosWrite}
+
+// UserScopes are the scopes a user logging in with their password gets,
+// unless they ask for fewer.
+var UserScopes = []string{ScopeVideosRead, ScopeVideosWrite, ScopeAccount}
+
+// AccessClaims are the claims carried by access tokens.
+type AccessClaims struct {
+	jwt.RegisteredClaims
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+const apiKeyPrefix = "tubely_"
+
 var ErrNoAuthHeaderIncluded = errors.New("no auth header included in request")
 
 func HashPassword(password string) (string, error) {
@@ -40,48 +69,89 @@ func CheckPasswordHash(password, hash string) (bool, error) {
 
 func MakeJWT(
 	userID uuid.UUID,
-	tokenSecret string,
+	keys *KeySet,
+	expiresIn time.Duration,
+	scopes []string,
+) (string, error) {
+	return makeToken(TokenTypeAccess, userID, keys, expiresIn, scopes)
+}
+
+// MakeChallengeToken issues a token for a user who still has to provide a
+// second factor before getting an access token with scopes.
+func MakeChallengeToken(
+	userID uuid.UUID,
+	keys *KeySet,
+	expiresIn time.Duration,
+	scopes []string,
+) (string, error) {
+	return makeToken(TokenTypeTwoFactorChallenge, userID, keys, expiresIn, scopes)
+}
+
+func makeToken(
+	tokenType TokenType,
+	userID uuid.UUID,
+	keys *KeySet,
 	expiresIn time.Duration,
+	scopes []string,
 ) (string, error) {
-	signingKey := []byte(tokenSecret)
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
-		Issuer:    string(TokenTypeAccess),
-		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
-		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
-		Subject:   userID.String(),
+	return keys.sign(AccessClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    string(tokenType),
+			IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
+			Subject:   userID.String(),
+		},
+		Scopes: scopes,
 	})
-	return token.SignedString(signingKey)
 }
 
-func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
-	claimsStruct := jwt.RegisteredClaims{}
+// ValidateJWT checks an access token and returns the user it was issued to
+// along with its scopes. Tokens issued before scopes existed get UserScopes.
+func ValidateJWT(tokenString string, keys *KeySet) (uuid.UUID, []string, error) {
+	return validateToken(TokenTypeAccess, tokenString, keys)
+}
+
+// ValidateChallengeToken checks a token from MakeChallengeToken and returns the
+// user it was issued to along with the scopes they asked for.
+func ValidateChallengeToken(tokenString string, keys *KeySet) (uuid.UUID, []string, error) {
+	return validateToken(TokenTypeTwoFactorChallenge, tokenString, keys)
+}
+
+func validateToken(tokenType TokenType, tokenString string, keys *KeySet) (uuid.UUID, []string, error) {
+	claimsStruct := AccessClaims{}
 	token, err := jwt.ParseWithClaims(
 		tokenString,
 		&claimsStruct,
-		func(token *jwt.Token) (interface{}, error) { return []byte(tokenSecret), nil },
+		keys.keyFunc,
+		jwt.WithValidMethods([]string{AlgEdDSA, AlgRS256, jwt.SigningMethodHS256.Alg()}),
 	)
 	if err != nil {
-		return uuid.Nil, err
+		return uuid.Nil, nil, err
 	}
 
 	userIDString, err := token.Claims.GetSubject()
 	if err != nil {
-		return uuid.Nil, err
+		return uuid.Nil, nil, err
 	}
 
 	issuer, err := token.Claims.GetIssuer()
 	if err != nil {
-		return uuid.Nil, err
+		return uuid.Nil, nil, err
 	}
-	if issuer != string(TokenTypeAccess) {
-		return uuid.Nil, errors.New("invalid issuer")
+	if issuer != string(tokenType) {
+		return uuid.Nil, nil, errors.New("invalid issuer")
 	}
 
 	id, err := uuid.Parse(userIDString)
 	if err != nil {
-		return uuid.Nil, fmt.Errorf("invalid user ID: %w", err)
+		return uuid.Nil, nil, fmt.Errorf("invalid user ID: %w", err)
 	}
-	return id, nil
+
+	scopes := claimsStruct.Scopes
+	if len(scopes) == 0 {
+		scopes = UserScopes
+	}
+	return id, scopes, nil
 }
 
 func GetBearerToken(headers http.Header) (string, error) {
@@ -118,3 +188,36 @@ func GetAPIKey(headers http.Header) (string, error) {
 
 	return splitAuth[1], nil
 }
+
+// MakeAPIKey generates a new random API key. Only its hash should be stored.
+func MakeAPIKey() (string, error) {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	if err != nil {
+		return "", err
+	}
+	return apiKeyPrefix + hex.EncodeToString(key), nil
+}
+
+// HashAPIKey returns the hash an API key is stored and looked up by. API keys
+// are long and random, so a fast hash is enough.
+func HashAPIKey(key string) string {
+	return HashToken(key)
+}
+
+// HashToken returns the hash a long random token, like the ones from
+// MakeRefreshToken, is stored and looked up by.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// APIKeyHint returns the start of an API key, enough for a user to recognize
+// it without revealing the secret.
+func APIKeyHint(key string) string {
+	n := len(apiKeyPrefix) + 8
+	if len(key) < n {
+		return key
+	}
+	return key[:n]
+}
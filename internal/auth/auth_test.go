@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestValidateJWTScopes(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := GenerateKey(dir, AlgEdDSA, time.Now()); err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	keys, err := LoadKeySet(dir, "")
+	if err != nil {
+		t.Fatalf("LoadKeySet: %v", err)
+	}
+	userID := uuid.New()
+
+	tests := []struct {
+		name   string
+		scopes []string
+		want   []string
+	}{
+		{"scoped token", []string{ScopeVideosRead}, []string{ScopeVideosRead}},
+		{"token from before scopes", nil, UserScopes},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := MakeJWT(userID, keys, time.Hour, tt.scopes)
+			if err != nil {
+				t.Fatalf("MakeJWT: %v", err)
+			}
+			gotID, got, err := ValidateJWT(token, keys)
+			if err != nil || gotID != userID {
+				t.Fatalf("ValidateJWT = %v, %v, want %v", gotID, err, userID)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("scopes = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	challenge, err := MakeChallengeToken(userID, keys, time.Hour, UserScopes)
+	if err != nil {
+		t.Fatalf("MakeChallengeToken: %v", err)
+	}
+	if _, _, err := ValidateJWT(challenge, keys); err == nil {
+		t.Error("ValidateJWT accepted a two-factor challenge token")
+	}
+}
@@ -0,0 +1,325 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Algorithms access tokens can be signed with.
+const (
+	AlgEdDSA = "EdDSA"
+	AlgRS256 = "RS256"
+)
+
+// ErrNoSigningKey is returned by LoadKeySet when the key directory holds no
+// private key to sign tokens with.
+var ErrNoSigningKey = errors.New("no signing key found")
+
+// Key IDs start with the time the key was generated so that sorting them puts
+// the newest key last.
+const keyIDTimeFormat = "20060102T150405Z"
+
+const (
+	privateKeySuffix = ".pem"
+	publicKeySuffix  = ".pub.pem"
+)
+
+type verificationKey struct {
+	method jwt.SigningMethod
+	public crypto.PublicKey
+}
+
+// KeySet holds the key access tokens are signed with and every key they are
+// accepted from. It's loaded from a directory holding one PEM file per key:
+// "<kid>.pem" for a PKCS #8 private key, or "<kid>.pub.pem" for a PKIX public
+// key that is only used for verification. The private key with the greatest
+// ID signs new tokens.
+type KeySet struct {
+	signingID  string
+	signingKey crypto.Signer
+	verifiers  map[string]verificationKey
+	// legacySecret verifies HS256 tokens issued before asymmetric keys were
+	// introduced, so that upgrading doesn't log everyone out.
+	legacySecret []byte
+}
+
+// LoadKeySet reads every key in dir. If legacySecret isn't empty, HS256 tokens
+// without a key ID signed with it are accepted too.
+func LoadKeySet(dir, legacySecret string) (*KeySet, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	ks := &KeySet{
+		verifiers: map[string]verificationKey{},
+	}
+	if legacySecret != "" {
+		ks.legacySecret = []byte(legacySecret)
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, privateKeySuffix) {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+
+		if kid, ok := strings.CutSuffix(name, publicKeySuffix); ok {
+			public, err := parsePublicKey(data)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			if err := ks.addVerifier(kid, public); err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			continue
+		}
+
+		kid := strings.TrimSuffix(name, privateKeySuffix)
+		private, err := parsePrivateKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		if err := ks.addVerifier(kid, private.Public()); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		if kid > ks.signingID {
+			ks.signingID = kid
+			ks.signingKey = private
+		}
+	}
+
+	if ks.signingKey == nil {
+		return nil, fmt.Errorf("%w in %s", ErrNoSigningKey, dir)
+	}
+	return ks, nil
+}
+
+func (ks *KeySet) addVerifier(kid string, public crypto.PublicKey) error {
+	method, err := methodForKey(public)
+	if err != nil {
+		return err
+	}
+	ks.verifiers[kid] = verificationKey{method: method, public: public}
+	return nil
+}
+
+// SigningKeyID returns the ID of the key new tokens are signed with.
+func (ks *KeySet) SigningKeyID() string {
+	return ks.signingID
+}
+
+func (ks *KeySet) sign(claims jwt.Claims) (string, error) {
+	method, err := methodForKey(ks.signingKey.Public())
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = ks.signingID
+	return token.SignedString(ks.signingKey)
+}
+
+// keyFunc picks the key to verify a token with from its "kid" header, making
+// sure the token's algorithm is the one that key is for.
+func (ks *KeySet) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		if ks.legacySecret != nil && token.Method == jwt.SigningMethodHS256 {
+			return ks.legacySecret, nil
+		}
+		return nil, errors.New("token has no key ID")
+	}
+
+	key, ok := ks.verifiers[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key ID %q", kid)
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("key %q doesn't sign %s tokens", kid, token.Method.Alg())
+	}
+	return key.public, nil
+}
+
+// JWK is a public key in JSON Web Key format.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+	// Ed25519 keys
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+	// RSA keys
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+}
+
+// JWKS returns every verification key, ordered by key ID, so that other
+// services can check our tokens.
+func (ks *KeySet) JWKS() []JWK {
+	kids := make([]string, 0, len(ks.verifiers))
+	for kid := range ks.verifiers {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+
+	keys := make([]JWK, 0, len(kids))
+	for _, kid := range kids {
+		key := ks.verifiers[kid]
+		jwk := JWK{
+			KeyID:     kid,
+			Algorithm: key.method.Alg(),
+			Use:       "sig",
+		}
+		switch public := key.public.(type) {
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		}
+		keys = append(keys, jwk)
+	}
+	return keys
+}
+
+// GenerateKey writes a new private key for alg to dir and returns its ID. The
+// new key signs every token issued once the key set is next loaded.
+func GenerateKey(dir, alg string, now time.Time) (string, error) {
+	var private crypto.Signer
+	var err error
+	switch alg {
+	case AlgEdDSA:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	case AlgRS256:
+		private, err = rsa.GenerateKey(rand.Reader, 3072)
+	default:
+		return "", fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return "", err
+	}
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	kid := now.UTC().Format(keyIDTimeFormat) + "-" + hex.EncodeToString(suffix)
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	err = os.WriteFile(filepath.Join(dir, kid+privateKeySuffix), data, 0o600)
+	if err != nil {
+		return "", err
+	}
+	return kid, nil
+}
+
+// PruneKeys deletes generated private keys that were replaced as the signing
+// key more than retention ago, since no unexpired token can be signed with
+// them any more. It returns the IDs of the deleted keys. Keys whose ID doesn't
+// start with a timestamp are left alone.
+func PruneKeys(dir string, retention time.Duration, now time.Time) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	type generatedKey struct {
+		id        string
+		createdAt time.Time
+	}
+	keys := []generatedKey{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasSuffix(name, publicKeySuffix) || !strings.HasSuffix(name, privateKeySuffix) {
+			continue
+		}
+		kid := strings.TrimSuffix(name, privateKeySuffix)
+		stamp, _, _ := strings.Cut(kid, "-")
+		createdAt, err := time.Parse(keyIDTimeFormat, stamp)
+		if err != nil {
+			continue
+		}
+		keys = append(keys, generatedKey{id: kid, createdAt: createdAt})
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].id < keys[j].id })
+
+	pruned := []string{}
+	for i := 0; i+1 < len(keys); i++ {
+		replacedAt := keys[i+1].createdAt
+		if now.Sub(replacedAt) <= retention {
+			continue
+		}
+		err := os.Remove(filepath.Join(dir, keys[i].id+privateKeySuffix))
+		if err != nil {
+			return pruned, err
+		}
+		pruned = append(pruned, keys[i].id)
+	}
+	return pruned, nil
+}
+
+func parsePrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, errors.New("expected a PEM encoded PKCS #8 private key")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("unsupported private key type")
+	}
+	return signer, nil
+}
+
+func parsePublicKey(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, errors.New("expected a PEM encoded PKIX public key")
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+func methodForKey(public crypto.PublicKey) (jwt.SigningMethod, error) {
+	switch public.(type) {
+	case ed25519.PublicKey:
+		return jwt.SigningMethodEdDSA, nil
+	case *rsa.PublicKey:
+		return jwt.SigningMethodRS256, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %T", public)
+}
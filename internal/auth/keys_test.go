@@ -0,0 +1,127 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+func TestKeySetRotation(t *testing.T) {
+	dir := t.TempDir()
+	userID := uuid.New()
+	start := time.Now()
+
+	oldKID, err := GenerateKey(dir, AlgRS256, start.Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	oldKeys, err := LoadKeySet(dir, "")
+	if err != nil {
+		t.Fatalf("LoadKeySet: %v", err)
+	}
+	oldToken, err := MakeJWT(userID, oldKeys, time.Hour, nil)
+	if err != nil {
+		t.Fatalf("MakeJWT: %v", err)
+	}
+
+	newKID, err := GenerateKey(dir, AlgEdDSA, start)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	keys, err := LoadKeySet(dir, "")
+	if err != nil {
+		t.Fatalf("LoadKeySet: %v", err)
+	}
+	if keys.SigningKeyID() != newKID {
+		t.Errorf("signing key = %s, want the newest key %s", keys.SigningKeyID(), newKID)
+	}
+	if got := len(keys.JWKS()); got != 2 {
+		t.Errorf("JWKS has %d keys, want 2", got)
+	}
+
+	newToken, err := MakeJWT(userID, keys, time.Hour, nil)
+	if err != nil {
+		t.Fatalf("MakeJWT: %v", err)
+	}
+	for name, token := range map[string]string{"old key": oldToken, "new key": newToken} {
+		got, _, err := ValidateJWT(token, keys)
+		if err != nil || got != userID {
+			t.Errorf("%s: ValidateJWT = %v, %v, want %v", name, got, err, userID)
+		}
+	}
+
+	pruned, err := PruneKeys(dir, time.Minute, start.Add(2*time.Minute))
+	if err != nil {
+		t.Fatalf("PruneKeys: %v", err)
+	}
+	if len(pruned) != 1 || pruned[0] != oldKID {
+		t.Fatalf("PruneKeys deleted %v, want [%s]", pruned, oldKID)
+	}
+	keys, err = LoadKeySet(dir, "")
+	if err != nil {
+		t.Fatalf("LoadKeySet: %v", err)
+	}
+	if _, _, err := ValidateJWT(oldToken, keys); err == nil {
+		t.Error("token signed with a pruned key was accepted")
+	}
+}
+
+func TestKeySetRejectsForgedTokens(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := GenerateKey(dir, AlgEdDSA, time.Now()); err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	const secret = "legacy-secret"
+	keys, err := LoadKeySet(dir, secret)
+	if err != nil {
+		t.Fatalf("LoadKeySet: %v", err)
+	}
+	claims := AccessClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    string(TokenTypeAccess),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			Subject:   uuid.NewString(),
+		},
+	}
+
+	legacy, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ValidateJWT(legacy, keys); err != nil {
+		t.Errorf("legacy HS256 token rejected: %v", err)
+	}
+	keysWithoutSecret, err := LoadKeySet(dir, "")
+	if err != nil {
+		t.Fatalf("LoadKeySet: %v", err)
+	}
+	if _, _, err := ValidateJWT(legacy, keysWithoutSecret); err == nil {
+		t.Error("HS256 token accepted without a legacy secret")
+	}
+
+	// An HS256 token naming one of our public keys must not be checked with
+	// the public key bytes as an HMAC secret.
+	confused := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	confused.Header["kid"] = keys.SigningKeyID()
+	token, err := confused.SignedString([]byte(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ValidateJWT(token, keys); err == nil {
+		t.Error("HS256 token with a key ID was accepted")
+	}
+
+	if _, err := LoadKeySet(filepath.Join(dir, "missing"), ""); err == nil {
+		t.Error("LoadKeySet succeeded without any keys")
+	}
+	if err := os.WriteFile(filepath.Join(dir, "broken.pem"), []byte("nope"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadKeySet(dir, ""); err == nil {
+		t.Error("LoadKeySet accepted a malformed key file")
+	}
+}
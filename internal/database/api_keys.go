@@ -0,0 +1,165 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type APIKey struct {
+	ID         uuid.UUID  `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreateAPIKeyParams
+}
+
+type CreateAPIKeyParams struct {
+	UserID uuid.UUID `json:"user_id"`
+	Name   string    `json:"name"`
+	// KeyHash is never sent to clients, KeyPrefix is enough to tell keys
+	// apart.
+	KeyHash   string     `json:"-"`
+	KeyPrefix string     `json:"key_prefix"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+func (c Client) CreateAPIKey(params CreateAPIKeyParams) (APIKey, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO api_keys (
+		id,
+		created_at,
+		updated_at,
+		user_id,
+		name,
+		key_hash,
+		key_prefix,
+		scopes,
+		expires_at
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(
+		query,
+		id,
+		params.UserID.String(),
+		params.Name,
+		params.KeyHash,
+		params.KeyPrefix,
+		strings.Join(params.Scopes, " "),
+		params.ExpiresAt,
+	)
+	if err != nil {
+		return APIKey{}, err
+	}
+
+	return c.GetAPIKey(id)
+}
+
+// GetAPIKey returns an empty APIKey if no key has the given ID.
+func (c Client) GetAPIKey(id uuid.UUID) (APIKey, error) {
+	return c.getAPIKey(`WHERE id = ?`, id)
+}
+
+// GetAPIKeyByHash looks a key up by the hash of the secret presented by a
+// client. It returns an empty APIKey if there is no match.
+func (c Client) GetAPIKeyByHash(keyHash string) (APIKey, error) {
+	return c.getAPIKey(`WHERE key_hash = ?`, keyHash)
+}
+
+func (c Client) getAPIKey(where string, arg interface{}) (APIKey, error) {
+	query := `
+	SELECT id, created_at, updated_at, last_used_at, revoked_at, user_id, name, key_hash, key_prefix, scopes, expires_at
+	FROM api_keys
+	` + where
+
+	var key APIKey
+	var scopes string
+	err := c.db.QueryRow(query, arg).Scan(
+		&key.ID,
+		&key.CreatedAt,
+		&key.UpdatedAt,
+		&key.LastUsedAt,
+		&key.RevokedAt,
+		&key.UserID,
+		&key.Name,
+		&key.KeyHash,
+		&key.KeyPrefix,
+		&scopes,
+		&key.ExpiresAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return APIKey{}, nil
+		}
+		return APIKey{}, err
+	}
+	key.Scopes = strings.Fields(scopes)
+
+	return key, nil
+}
+
+// GetAPIKeys returns all of the user's keys that haven't been revoked.
+func (c Client) GetAPIKeys(userID uuid.UUID) ([]APIKey, error) {
+	query := `
+	SELECT id, created_at, updated_at, last_used_at, revoked_at, user_id, name, key_hash, key_prefix, scopes, expires_at
+	FROM api_keys
+	WHERE user_id = ? AND revoked_at IS NULL
+	ORDER BY created_at DESC
+	`
+	rows, err := c.db.Query(query, userID.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		var key APIKey
+		var scopes string
+		if err := rows.Scan(
+			&key.ID,
+			&key.CreatedAt,
+			&key.UpdatedAt,
+			&key.LastUsedAt,
+			&key.RevokedAt,
+			&key.UserID,
+			&key.Name,
+			&key.KeyHash,
+			&key.KeyPrefix,
+			&scopes,
+			&key.ExpiresAt,
+		); err != nil {
+			return nil, err
+		}
+		key.Scopes = strings.Fields(scopes)
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+func (c Client) TouchAPIKey(id uuid.UUID) error {
+	query := `
+	UPDATE api_keys
+	SET last_used_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, id)
+	return err
+}
+
+func (c Client) RevokeAPIKey(id uuid.UUID) error {
+	query := `
+	UPDATE api_keys
+	SET revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, id)
+	return err
+}
@@ -0,0 +1,143 @@
+package database
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Audit event results.
+const (
+	AuditResultSuccess = "success"
+	// AuditResultDenied is for requests turned away for lack of credentials
+	// or permission, or because of throttling.
+	AuditResultDenied  = "denied"
+	AuditResultFailure = "failure"
+)
+
+// AuditEvent records a security or content event. The audit log is append
+// only: there is no way to change or delete events, not even Reset.
+type AuditEvent struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	CreateAuditEventParams
+}
+
+type CreateAuditEventParams struct {
+	// ActorID is who did it, nil if they couldn't be identified.
+	ActorID *uuid.UUID `json:"actor_id"`
+	// Action is what was done, like "video.delete".
+	Action string `json:"action"`
+	// Target is what it was done to, like "video:<id>".
+	Target    string `json:"target"`
+	IP        string `json:"ip"`
+	UserAgent string `json:"user_agent"`
+	Result    string `json:"result"`
+	// Status is the HTTP status code of the response.
+	Status int `json:"status"`
+	// Details is free-form JSON with more about the event.
+	Details json.RawMessage `json:"details,omitempty"`
+}
+
+func (c Client) CreateAuditEvent(params CreateAuditEventParams) error {
+	query := `
+		INSERT INTO audit_events (id, created_at, actor_id, action, target, ip, user_agent, result, status, details)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, uuid.New(), time.Now().UTC(), params.ActorID, params.Action, params.Target,
+		params.IP, params.UserAgent, params.Result, params.Status, string(params.Details))
+	return err
+}
+
+type ListAuditEventsParams struct {
+	ActorID *uuid.UUID
+	// Action matches exactly, or as a prefix if it ends in "*".
+	Action string
+	Target string
+	Result string
+	Since  time.Time
+	Until  time.Time
+	// Limit is the most events to return, zero means no limit.
+	Limit  int
+	Offset int
+}
+
+// ListAuditEvents returns matching events, the most recent first.
+func (c Client) ListAuditEvents(params ListAuditEventsParams) ([]AuditEvent, error) {
+	events := []AuditEvent{}
+	err := c.EachAuditEvent(params, func(e AuditEvent) error {
+		events = append(events, e)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// EachAuditEvent calls fn with every matching event, the most recent first,
+// without loading them all into memory. It stops at the first error from fn.
+func (c Client) EachAuditEvent(params ListAuditEventsParams, fn func(AuditEvent) error) error {
+	query := `
+		SELECT id, created_at, actor_id, action, target, ip, user_agent, result, status, details
+		FROM audit_events
+		WHERE 1 = 1
+	`
+	args := []interface{}{}
+	if params.ActorID != nil {
+		query += ` AND actor_id = ?`
+		args = append(args, *params.ActorID)
+	}
+	if prefix, ok := strings.CutSuffix(params.Action, "*"); ok {
+		query += ` AND action LIKE ? ESCAPE '\'`
+		args = append(args, escapeLike(prefix)+"%")
+	} else if params.Action != "" {
+		query += ` AND action = ?`
+		args = append(args, params.Action)
+	}
+	if params.Target != "" {
+		query += ` AND target = ?`
+		args = append(args, params.Target)
+	}
+	if params.Result != "" {
+		query += ` AND result = ?`
+		args = append(args, params.Result)
+	}
+	if !params.Since.IsZero() {
+		query += ` AND created_at >= ?`
+		args = append(args, params.Since.UTC())
+	}
+	if !params.Until.IsZero() {
+		query += ` AND created_at < ?`
+		args = append(args, params.Until.UTC())
+	}
+	query += ` ORDER BY created_at DESC, rowid DESC`
+	if params.Limit > 0 {
+		query += ` LIMIT ? OFFSET ?`
+		args = append(args, params.Limit, params.Offset)
+	}
+
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e AuditEvent
+		var details string
+		err := rows.Scan(&e.ID, &e.CreatedAt, &e.ActorID, &e.Action, &e.Target, &e.IP, &e.UserAgent, &e.Result, &e.Status, &details)
+		if err != nil {
+			return err
+		}
+		if details != "" {
+			e.Details = json.RawMessage(details)
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,40 @@
+package database
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAuditEventsAreAppendOnly(t *testing.T) {
+	c := newTestClient(t)
+	err := c.CreateAuditEvent(CreateAuditEventParams{
+		Action: "video.delete",
+		Target: "video:1",
+		Result: AuditResultSuccess,
+		Status: 204,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, statement := range []string{
+		`UPDATE audit_events SET result = 'failure'`,
+		`DELETE FROM audit_events`,
+	} {
+		_, err := c.db.Exec(statement)
+		if err == nil || !strings.Contains(err.Error(), "append only") {
+			t.Errorf("%s: got error %v, want it refused", statement, err)
+		}
+	}
+	if err := c.Reset(); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := c.ListAuditEvents(ListAuditEventsParams{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Result != AuditResultSuccess {
+		t.Errorf("events after tampering and a reset: %+v", events)
+	}
+}
@@ -0,0 +1,129 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Video roles, from least to most privileged. The user who created a video
+// is always treated as its owner, even without a video_collaborators row.
+const (
+	VideoRoleViewer = "viewer"
+	VideoRoleEditor = "editor"
+	VideoRoleOwner  = "owner"
+)
+
+type VideoCollaborator struct {
+	VideoID   uuid.UUID `json:"video_id"`
+	UserID    uuid.UUID `json:"user_id"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// SetVideoCollaborator grants role on the video to the user, replacing any
+// role they already had.
+func (c Client) SetVideoCollaborator(videoID, userID uuid.UUID, role string) (VideoCollaborator, error) {
+	query := `
+	INSERT INTO video_collaborators (video_id, user_id, role, created_at, updated_at)
+	VALUES (?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+	ON CONFLICT(video_id, user_id) DO UPDATE SET
+		role = excluded.role,
+		updated_at = CURRENT_TIMESTAMP
+	`
+	_, err := c.db.Exec(query, videoID, userID, role)
+	if err != nil {
+		return VideoCollaborator{}, err
+	}
+
+	return c.GetVideoCollaborator(videoID, userID)
+}
+
+// GetVideoCollaborator returns an empty VideoCollaborator if the user has no
+// role on the video.
+func (c Client) GetVideoCollaborator(videoID, userID uuid.UUID) (VideoCollaborator, error) {
+	query := `
+	SELECT vc.video_id, vc.user_id, u.email, vc.role, vc.created_at, vc.updated_at
+	FROM video_collaborators vc
+	JOIN users u ON u.id = vc.user_id
+	WHERE vc.video_id = ? AND vc.user_id = ?
+	`
+	var vc VideoCollaborator
+	err := c.db.QueryRow(query, videoID, userID).
+		Scan(&vc.VideoID, &vc.UserID, &vc.Email, &vc.Role, &vc.CreatedAt, &vc.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return VideoCollaborator{}, nil
+		}
+		return VideoCollaborator{}, err
+	}
+	return vc, nil
+}
+
+func (c Client) GetVideoCollaborators(videoID uuid.UUID) ([]VideoCollaborator, error) {
+	query := `
+	SELECT vc.video_id, vc.user_id, u.email, vc.role, vc.created_at, vc.updated_at
+	FROM video_collaborators vc
+	JOIN users u ON u.id = vc.user_id
+	WHERE vc.video_id = ?
+	ORDER BY vc.created_at
+	`
+	rows, err := c.db.Query(query, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	collaborators := []VideoCollaborator{}
+	for rows.Next() {
+		var vc VideoCollaborator
+		if err := rows.Scan(&vc.VideoID, &vc.UserID, &vc.Email, &vc.Role, &vc.CreatedAt, &vc.UpdatedAt); err != nil {
+			return nil, err
+		}
+		collaborators = append(collaborators, vc)
+	}
+
+	return collaborators, rows.Err()
+}
+
+func (c Client) DeleteVideoCollaborator(videoID, userID uuid.UUID) error {
+	query := `
+	DELETE FROM video_collaborators
+	WHERE video_id = ? AND user_id = ?
+	`
+	_, err := c.db.Exec(query, videoID, userID)
+	return err
+}
+
+// GetSharedVideos returns the videos other users have shared with userID.
+func (c Client) GetSharedVideos(userID uuid.UUID) ([]Video, error) {
+	query := `
+	SELECT
+		v.id,
+		v.created_at,
+		v.updated_at,
+		v.title,
+		v.description,
+		v.thumbnail_url,
+		v.video_url,
+		v.user_id,
+		v.org_id,
+		v.size_bytes
+	FROM videos v
+	JOIN video_collaborators vc ON vc.video_id = v.id
+	WHERE vc.user_id = ?
+	ORDER BY v.created_at DESC
+	`
+
+	rows, err := c.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanVideos(rows)
+}
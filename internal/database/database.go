@@ -1,18 +1,31 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 
+	"github.com/XSAM/otelsql"
 	_ "github.com/mattn/go-sqlite3"
+	semconv "go.opentelemetry.io/otel/semconv/v1.40.0"
 )
 
 type Client struct {
 	db *sql.DB
 }
 
+// NewClient opens the database, migrating it if needed. Statements are traced
+// with otelsql. The client's methods don't take a context, so their spans
+// aren't part of a request's trace, but show how long each statement and
+// reading its rows took.
 func NewClient(pathToDB string) (Client, error) {
-	db, err := sql.Open("sqlite3", pathToDB)
+	db, err := otelsql.Open("sqlite3", pathToDB,
+		otelsql.WithAttributes(semconv.DBSystemNameSQLite),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{
+			OmitConnResetSession: true,
+			OmitConnectorConnect: true,
+		}),
+	)
 	if err != nil {
 		return Client{}, err
 	}
@@ -25,6 +38,22 @@ func NewClient(pathToDB string) (Client, error) {
 
 }
 
+// Close closes the database, waiting for queries in progress to finish.
+func (c Client) Close() error {
+	return c.db.Close()
+}
+
+// Ping checks that the database can be queried, giving up when ctx is done.
+func (c Client) Ping(ctx context.Context) error {
+	var one int
+	return c.db.QueryRowContext(ctx, "SELECT 1").Scan(&one)
+}
+
+// DB returns the connection pool, for collecting its statistics.
+func (c Client) DB() *sql.DB {
+	return c.db
+}
+
 func (c *Client) autoMigrate() error {
 	userTable := `
 	CREATE TABLE IF NOT EXISTS users (
@@ -72,10 +101,433 @@ func (c *Client) autoMigrate() error {
 	if err != nil {
 		return err
 	}
+
+	tagTable := `
+	CREATE TABLE IF NOT EXISTS tags (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		name TEXT UNIQUE NOT NULL
+	);
+	`
+	_, err = c.db.Exec(tagTable)
+	if err != nil {
+		return err
+	}
+
+	videoTagTable := `
+	CREATE TABLE IF NOT EXISTS video_tags (
+		video_id TEXT NOT NULL,
+		tag_id TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(video_id, tag_id),
+		FOREIGN KEY(video_id) REFERENCES videos(id),
+		FOREIGN KEY(tag_id) REFERENCES tags(id)
+	);
+	`
+	_, err = c.db.Exec(videoTagTable)
+	if err != nil {
+		return err
+	}
+
+	playlistTable := `
+	CREATE TABLE IF NOT EXISTS playlists (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		title TEXT NOT NULL,
+		description TEXT,
+		visibility TEXT NOT NULL DEFAULT 'private',
+		user_id TEXT NOT NULL,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(playlistTable)
+	if err != nil {
+		return err
+	}
+
+	playlistVideoTable := `
+	CREATE TABLE IF NOT EXISTS playlist_videos (
+		playlist_id TEXT NOT NULL,
+		video_id TEXT NOT NULL,
+		position INTEGER NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(playlist_id, video_id),
+		FOREIGN KEY(playlist_id) REFERENCES playlists(id),
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	);
+	`
+	_, err = c.db.Exec(playlistVideoTable)
+	if err != nil {
+		return err
+	}
+
+	videoCollaboratorTable := `
+	CREATE TABLE IF NOT EXISTS video_collaborators (
+		video_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		role TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(video_id, user_id),
+		FOREIGN KEY(video_id) REFERENCES videos(id),
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(videoCollaboratorTable)
+	if err != nil {
+		return err
+	}
+
+	orgTable := `
+	CREATE TABLE IF NOT EXISTS orgs (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		name TEXT NOT NULL,
+		max_videos INTEGER NOT NULL DEFAULT 0,
+		max_storage_bytes INTEGER NOT NULL DEFAULT 0
+	);
+	`
+	_, err = c.db.Exec(orgTable)
+	if err != nil {
+		return err
+	}
+
+	orgMemberTable := `
+	CREATE TABLE IF NOT EXISTS org_members (
+		org_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		role TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(org_id, user_id),
+		FOREIGN KEY(org_id) REFERENCES orgs(id),
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(orgMemberTable)
+	if err != nil {
+		return err
+	}
+
+	apiKeyTable := `
+	CREATE TABLE IF NOT EXISTS api_keys (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		user_id TEXT NOT NULL,
+		name TEXT NOT NULL,
+		key_hash TEXT UNIQUE NOT NULL,
+		key_prefix TEXT NOT NULL,
+		scopes TEXT NOT NULL,
+		expires_at TIMESTAMP,
+		last_used_at TIMESTAMP,
+		revoked_at TIMESTAMP,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(apiKeyTable)
+	if err != nil {
+		return err
+	}
+
+	oidcLoginTable := `
+	CREATE TABLE IF NOT EXISTS oidc_logins (
+		state TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		expires_at TIMESTAMP NOT NULL,
+		nonce TEXT NOT NULL,
+		code_verifier TEXT NOT NULL,
+		link_user_id TEXT
+	);
+	`
+	_, err = c.db.Exec(oidcLoginTable)
+	if err != nil {
+		return err
+	}
+
+	userIdentityTable := `
+	CREATE TABLE IF NOT EXISTS user_identities (
+		issuer TEXT NOT NULL,
+		subject TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(issuer, subject),
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(userIdentityTable)
+	if err != nil {
+		return err
+	}
+
+	userTokenTable := `
+	CREATE TABLE IF NOT EXISTS user_tokens (
+		token_hash TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		user_id TEXT NOT NULL,
+		purpose TEXT NOT NULL,
+		email TEXT NOT NULL,
+		expires_at TIMESTAMP NOT NULL,
+		used_at TIMESTAMP,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(userTokenTable)
+	if err != nil {
+		return err
+	}
+
+	loginThrottleTable := `
+	CREATE TABLE IF NOT EXISTS login_throttles (
+		key TEXT PRIMARY KEY,
+		failures INTEGER NOT NULL,
+		last_failure_at TIMESTAMP NOT NULL
+	);
+	`
+	_, err = c.db.Exec(loginThrottleTable)
+	if err != nil {
+		return err
+	}
+
+	loginFailureTable := `
+	CREATE TABLE IF NOT EXISTS login_failures (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		email TEXT NOT NULL,
+		user_id TEXT,
+		ip TEXT NOT NULL,
+		user_agent TEXT NOT NULL,
+		reason TEXT NOT NULL
+	);
+	`
+	_, err = c.db.Exec(loginFailureTable)
+	if err != nil {
+		return err
+	}
+
+	totpFactorTable := `
+	CREATE TABLE IF NOT EXISTS totp_factors (
+		user_id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		secret TEXT NOT NULL,
+		confirmed_at TIMESTAMP,
+		last_used_step INTEGER NOT NULL DEFAULT 0,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(totpFactorTable)
+	if err != nil {
+		return err
+	}
+
+	recoveryCodeTable := `
+	CREATE TABLE IF NOT EXISTS recovery_codes (
+		code_hash TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		used_at TIMESTAMP,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(recoveryCodeTable)
+	if err != nil {
+		return err
+	}
+
+	userQuotaTable := `
+	CREATE TABLE IF NOT EXISTS user_quotas (
+		user_id TEXT PRIMARY KEY,
+		updated_at TIMESTAMP NOT NULL,
+		max_videos INTEGER,
+		max_storage_bytes INTEGER,
+		max_file_bytes INTEGER,
+		max_duration_seconds INTEGER,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(userQuotaTable)
+	if err != nil {
+		return err
+	}
+
+	auditEventTable := `
+	CREATE TABLE IF NOT EXISTS audit_events (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP NOT NULL,
+		actor_id TEXT,
+		action TEXT NOT NULL,
+		target TEXT NOT NULL,
+		ip TEXT NOT NULL,
+		user_agent TEXT NOT NULL,
+		result TEXT NOT NULL,
+		status INTEGER NOT NULL,
+		details TEXT NOT NULL DEFAULT ''
+	);
+	CREATE INDEX IF NOT EXISTS audit_events_created_at ON audit_events(created_at);
+	CREATE INDEX IF NOT EXISTS audit_events_actor_id ON audit_events(actor_id, created_at);
+	CREATE TRIGGER IF NOT EXISTS audit_events_no_update BEFORE UPDATE ON audit_events
+	BEGIN
+		SELECT RAISE(ABORT, 'audit events are append only');
+	END;
+	CREATE TRIGGER IF NOT EXISTS audit_events_no_delete BEFORE DELETE ON audit_events
+	BEGIN
+		SELECT RAISE(ABORT, 'audit events are append only');
+	END;
+	`
+	_, err = c.db.Exec(auditEventTable)
+	if err != nil {
+		return err
+	}
+
+	err = c.addColumn("videos", "org_id", "TEXT REFERENCES orgs(id)")
+	if err != nil {
+		return err
+	}
+	err = c.addColumn("videos", "size_bytes", "INTEGER NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+	// Videos used to be created with CURRENT_TIMESTAMP, which leaves out the
+	// time zone the driver writes, so updated_at wouldn't compare equal to
+	// the time read back from it.
+	_, err = c.db.Exec(`UPDATE videos SET updated_at = updated_at || '+00:00' WHERE length(updated_at) = 19`)
+	if err != nil {
+		return err
+	}
+	err = c.addColumn("refresh_tokens", "family_id", "TEXT")
+	if err != nil {
+		return err
+	}
+	// Tokens issued before rotation existed each get a family of their own.
+	_, err = c.db.Exec(`UPDATE refresh_tokens SET family_id = lower(hex(randomblob(16))) WHERE family_id IS NULL`)
+	if err != nil {
+		return err
+	}
+	err = c.addColumn("refresh_tokens", "last_used_at", "TIMESTAMP")
+	if err != nil {
+		return err
+	}
+	err = c.addColumn("refresh_tokens", "user_agent", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+	err = c.addColumn("refresh_tokens", "ip", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+	err = c.addColumn("refresh_tokens", "scopes", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+	hadEmailVerification, err := c.hasColumn("users", "email_verified_at")
+	if err != nil {
+		return err
+	}
+	err = c.addColumn("users", "email_verified_at", "TIMESTAMP")
+	if err != nil {
+		return err
+	}
+	if !hadEmailVerification {
+		// Accounts from before verification existed keep being able to upload.
+		_, err = c.db.Exec(`UPDATE users SET email_verified_at = created_at`)
+		if err != nil {
+			return err
+		}
+	}
+	err = c.addColumn("users", "role", "TEXT NOT NULL DEFAULT 'user'")
+	if err != nil {
+		return err
+	}
+	err = c.addColumn("users", "disabled_at", "TIMESTAMP")
+	if err != nil {
+		return err
+	}
+	err = c.addColumn("oidc_logins", "link_user_id", "TEXT")
+	if err != nil {
+		return err
+	}
 	return nil
 }
 
+// addColumn adds a column to a table created by an earlier version of the
+// schema. It does nothing if the column already exists.
+func (c *Client) addColumn(table, column, definition string) error {
+	exists, err := c.hasColumn(table, column)
+	if err != nil || exists {
+		return err
+	}
+
+	_, err = c.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+func (c *Client) hasColumn(table, column string) (bool, error) {
+	rows, err := c.db.Query(fmt.Sprintf("SELECT name FROM pragma_table_info('%s')", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
 func (c Client) Reset() error {
+	if _, err := c.db.Exec("DELETE FROM user_quotas"); err != nil {
+		return fmt.Errorf("failed to reset table user_quotas: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM recovery_codes"); err != nil {
+		return fmt.Errorf("failed to reset table recovery_codes: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM totp_factors"); err != nil {
+		return fmt.Errorf("failed to reset table totp_factors: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM login_throttles"); err != nil {
+		return fmt.Errorf("failed to reset table login_throttles: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM login_failures"); err != nil {
+		return fmt.Errorf("failed to reset table login_failures: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM user_tokens"); err != nil {
+		return fmt.Errorf("failed to reset table user_tokens: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM oidc_logins"); err != nil {
+		return fmt.Errorf("failed to reset table oidc_logins: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM user_identities"); err != nil {
+		return fmt.Errorf("failed to reset table user_identities: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM api_keys"); err != nil {
+		return fmt.Errorf("failed to reset table api_keys: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM org_members"); err != nil {
+		return fmt.Errorf("failed to reset table org_members: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_collaborators"); err != nil {
+		return fmt.Errorf("failed to reset table video_collaborators: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM playlist_videos"); err != nil {
+		return fmt.Errorf("failed to reset table playlist_videos: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM playlists"); err != nil {
+		return fmt.Errorf("failed to reset table playlists: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_tags"); err != nil {
+		return fmt.Errorf("failed to reset table video_tags: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM tags"); err != nil {
+		return fmt.Errorf("failed to reset table tags: %w", err)
+	}
 	if _, err := c.db.Exec("DELETE FROM refresh_tokens"); err != nil {
 		return fmt.Errorf("failed to reset table refresh_tokens: %w", err)
 	}
@@ -85,5 +537,8 @@ func (c Client) Reset() error {
 	if _, err := c.db.Exec("DELETE FROM videos"); err != nil {
 		return fmt.Errorf("failed to reset table videos: %w", err)
 	}
+	if _, err := c.db.Exec("DELETE FROM orgs"); err != nil {
+		return fmt.Errorf("failed to reset table orgs: %w", err)
+	}
 	return nil
 }
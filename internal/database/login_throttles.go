@@ -0,0 +1,89 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Reasons a login attempt failed.
+const (
+	LoginFailureUnknownUser     = "unknown_user"
+	LoginFailureBadPassword     = "bad_password"
+	LoginFailureBadSecondFactor = "bad_second_factor"
+	LoginFailureThrottled       = "throttled"
+)
+
+// LoginThrottle counts recent failed logins for an account or IP address.
+type LoginThrottle struct {
+	Key           string    `json:"key"`
+	Failures      int       `json:"failures"`
+	LastFailureAt time.Time `json:"last_failure_at"`
+}
+
+// LoginFailure records a failed login attempt for auditing.
+type LoginFailure struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	CreateLoginFailureParams
+}
+
+type CreateLoginFailureParams struct {
+	Email     string     `json:"email"`
+	UserID    *uuid.UUID `json:"user_id"`
+	IP        string     `json:"ip"`
+	UserAgent string     `json:"user_agent"`
+	Reason    string     `json:"reason"`
+}
+
+// GetLoginThrottle returns an empty LoginThrottle if key has no failures.
+func (c Client) GetLoginThrottle(key string) (LoginThrottle, error) {
+	query := `
+		SELECT key, failures, last_failure_at
+		FROM login_throttles
+		WHERE key = ?
+	`
+	var throttle LoginThrottle
+	err := c.db.QueryRow(query, key).Scan(&throttle.Key, &throttle.Failures, &throttle.LastFailureAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return LoginThrottle{}, nil
+	}
+	if err != nil {
+		return LoginThrottle{}, err
+	}
+	return throttle, nil
+}
+
+// RecordLoginFailure counts a failed login against key. Failures older than
+// window are forgotten, so the count starts over after a quiet period.
+func (c Client) RecordLoginFailure(key string, window time.Duration) (LoginThrottle, error) {
+	now := time.Now().UTC()
+	query := `
+		INSERT INTO login_throttles (key, failures, last_failure_at)
+		VALUES (?, 1, ?)
+		ON CONFLICT(key) DO UPDATE SET
+			failures = CASE WHEN last_failure_at < ? THEN 1 ELSE failures + 1 END,
+			last_failure_at = excluded.last_failure_at
+	`
+	_, err := c.db.Exec(query, key, now, now.Add(-window))
+	if err != nil {
+		return LoginThrottle{}, err
+	}
+	return c.GetLoginThrottle(key)
+}
+
+func (c Client) ClearLoginThrottle(key string) error {
+	_, err := c.db.Exec(`DELETE FROM login_throttles WHERE key = ?`, key)
+	return err
+}
+
+func (c Client) CreateLoginFailure(params CreateLoginFailureParams) error {
+	query := `
+		INSERT INTO login_failures (id, created_at, email, user_id, ip, user_agent, reason)
+		VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, uuid.New(), params.Email, params.UserID, params.IP, params.UserAgent, params.Reason)
+	return err
+}
@@ -0,0 +1,101 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// OIDCLogin is an OpenID Connect login that has been sent to the identity
+// provider and is waiting for it to redirect back.
+type OIDCLogin struct {
+	State        string    `json:"state"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	Nonce        string    `json:"nonce"`
+	CodeVerifier string    `json:"code_verifier"`
+	// LinkUserID is the logged in user who started the login to link their
+	// identity provider account, or uuid.Nil for an ordinary login.
+	LinkUserID uuid.UUID `json:"link_user_id"`
+}
+
+func (c Client) CreateOIDCLogin(login OIDCLogin) error {
+	query := `
+		INSERT INTO oidc_logins (state, created_at, expires_at, nonce, code_verifier, link_user_id)
+		VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?)
+	`
+	var linkUserID *uuid.UUID
+	if login.LinkUserID != uuid.Nil {
+		linkUserID = &login.LinkUserID
+	}
+	_, err := c.db.Exec(query, login.State, login.ExpiresAt, login.Nonce, login.CodeVerifier, linkUserID)
+	return err
+}
+
+// ConsumeOIDCLogin deletes and returns the pending login with the given
+// state, so each one can only be completed once. It returns an empty
+// OIDCLogin if there is no such login or it has expired.
+func (c Client) ConsumeOIDCLogin(state string) (OIDCLogin, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return OIDCLogin{}, err
+	}
+	defer tx.Rollback()
+
+	var login OIDCLogin
+	err = tx.QueryRow(`
+		SELECT state, expires_at, nonce, code_verifier, link_user_id
+		FROM oidc_logins
+		WHERE state = ?
+	`, state).Scan(&login.State, &login.ExpiresAt, &login.Nonce, &login.CodeVerifier, &login.LinkUserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return OIDCLogin{}, nil
+	}
+	if err != nil {
+		return OIDCLogin{}, err
+	}
+
+	// Clear out abandoned logins while we're here.
+	_, err = tx.Exec(`DELETE FROM oidc_logins WHERE state = ? OR expires_at <= ?`, state, time.Now().UTC())
+	if err != nil {
+		return OIDCLogin{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return OIDCLogin{}, err
+	}
+
+	if time.Now().UTC().After(login.ExpiresAt) {
+		return OIDCLogin{}, nil
+	}
+	return login, nil
+}
+
+// GetUserIDByIdentity returns the user linked to the identity provider
+// account, or uuid.Nil if none is.
+func (c Client) GetUserIDByIdentity(issuer, subject string) (uuid.UUID, error) {
+	query := `
+		SELECT user_id
+		FROM user_identities
+		WHERE issuer = ? AND subject = ?
+	`
+	var userID uuid.UUID
+	err := c.db.QueryRow(query, issuer, subject).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return uuid.Nil, nil
+	}
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return userID, nil
+}
+
+// LinkIdentity records that the identity provider account belongs to userID.
+func (c Client) LinkIdentity(issuer, subject string, userID uuid.UUID) error {
+	query := `
+		INSERT INTO user_identities (issuer, subject, user_id, created_at)
+		VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+	`
+	_, err := c.db.Exec(query, issuer, subject, userID)
+	return err
+}
@@ -0,0 +1,227 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	OrgRoleAdmin  = "admin"
+	OrgRoleMember = "member"
+)
+
+type Org struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Name      string    `json:"name"`
+	// Quotas for the whole organization, zero means unlimited.
+	MaxVideos       int   `json:"max_videos"`
+	MaxStorageBytes int64 `json:"max_storage_bytes"`
+}
+
+type OrgMember struct {
+	OrgID     uuid.UUID `json:"org_id"`
+	UserID    uuid.UUID `json:"user_id"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// OrgMembership is an organization as seen by one of its members.
+type OrgMembership struct {
+	Org
+	Role string `json:"role"`
+}
+
+type StorageUsage struct {
+	VideoCount   int   `json:"video_count"`
+	StorageBytes int64 `json:"storage_bytes"`
+}
+
+// CreateOrg creates an organization with adminID as its first admin.
+func (c Client) CreateOrg(name string, adminID uuid.UUID) (Org, error) {
+	id := uuid.New()
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return Org{}, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO orgs (id, created_at, updated_at, name)
+		VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?)
+	`, id, name)
+	if err != nil {
+		return Org{}, err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO org_members (org_id, user_id, role, created_at, updated_at)
+		VALUES (?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+	`, id, adminID, OrgRoleAdmin)
+	if err != nil {
+		return Org{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Org{}, err
+	}
+
+	return c.GetOrg(id)
+}
+
+// GetOrg returns an empty Org if no organization has the given ID.
+func (c Client) GetOrg(id uuid.UUID) (Org, error) {
+	query := `
+	SELECT id, created_at, updated_at, name, max_videos, max_storage_bytes
+	FROM orgs
+	WHERE id = ?
+	`
+	var org Org
+	err := c.db.QueryRow(query, id).
+		Scan(&org.ID, &org.CreatedAt, &org.UpdatedAt, &org.Name, &org.MaxVideos, &org.MaxStorageBytes)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Org{}, nil
+		}
+		return Org{}, err
+	}
+	return org, nil
+}
+
+func (c Client) UpdateOrg(org Org) (Org, error) {
+	query := `
+	UPDATE orgs
+	SET
+		updated_at = ?,
+		name = ?,
+		max_videos = ?,
+		max_storage_bytes = ?
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, time.Now().UTC(), org.Name, org.MaxVideos, org.MaxStorageBytes, org.ID)
+	if err != nil {
+		return Org{}, err
+	}
+	return c.GetOrg(org.ID)
+}
+
+// GetOrgsForUser returns every organization the user is a member of.
+func (c Client) GetOrgsForUser(userID uuid.UUID) ([]OrgMembership, error) {
+	query := `
+	SELECT o.id, o.created_at, o.updated_at, o.name, o.max_videos, o.max_storage_bytes, m.role
+	FROM orgs o
+	JOIN org_members m ON m.org_id = o.id
+	WHERE m.user_id = ?
+	ORDER BY o.name
+	`
+	rows, err := c.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	memberships := []OrgMembership{}
+	for rows.Next() {
+		var m OrgMembership
+		if err := rows.Scan(&m.ID, &m.CreatedAt, &m.UpdatedAt, &m.Name, &m.MaxVideos, &m.MaxStorageBytes, &m.Role); err != nil {
+			return nil, err
+		}
+		memberships = append(memberships, m)
+	}
+
+	return memberships, rows.Err()
+}
+
+// SetOrgMember adds the user to the organization with role, or changes their
+// role if they are already a member.
+func (c Client) SetOrgMember(orgID, userID uuid.UUID, role string) (OrgMember, error) {
+	query := `
+	INSERT INTO org_members (org_id, user_id, role, created_at, updated_at)
+	VALUES (?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+	ON CONFLICT(org_id, user_id) DO UPDATE SET
+		role = excluded.role,
+		updated_at = CURRENT_TIMESTAMP
+	`
+	_, err := c.db.Exec(query, orgID, userID, role)
+	if err != nil {
+		return OrgMember{}, err
+	}
+
+	return c.GetOrgMember(orgID, userID)
+}
+
+// GetOrgMember returns an empty OrgMember if the user isn't a member.
+func (c Client) GetOrgMember(orgID, userID uuid.UUID) (OrgMember, error) {
+	query := `
+	SELECT m.org_id, m.user_id, u.email, m.role, m.created_at, m.updated_at
+	FROM org_members m
+	JOIN users u ON u.id = m.user_id
+	WHERE m.org_id = ? AND m.user_id = ?
+	`
+	var m OrgMember
+	err := c.db.QueryRow(query, orgID, userID).
+		Scan(&m.OrgID, &m.UserID, &m.Email, &m.Role, &m.CreatedAt, &m.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return OrgMember{}, nil
+		}
+		return OrgMember{}, err
+	}
+	return m, nil
+}
+
+func (c Client) GetOrgMembers(orgID uuid.UUID) ([]OrgMember, error) {
+	query := `
+	SELECT m.org_id, m.user_id, u.email, m.role, m.created_at, m.updated_at
+	FROM org_members m
+	JOIN users u ON u.id = m.user_id
+	WHERE m.org_id = ?
+	ORDER BY m.created_at
+	`
+	rows, err := c.db.Query(query, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []OrgMember{}
+	for rows.Next() {
+		var m OrgMember
+		if err := rows.Scan(&m.OrgID, &m.UserID, &m.Email, &m.Role, &m.CreatedAt, &m.UpdatedAt); err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+
+	return members, rows.Err()
+}
+
+func (c Client) DeleteOrgMember(orgID, userID uuid.UUID) error {
+	query := `
+	DELETE FROM org_members
+	WHERE org_id = ? AND user_id = ?
+	`
+	_, err := c.db.Exec(query, orgID, userID)
+	return err
+}
+
+// GetOrgUsage returns how much of its quotas the organization is using.
+func (c Client) GetOrgUsage(orgID uuid.UUID) (StorageUsage, error) {
+	query := `
+	SELECT COUNT(*), COALESCE(SUM(size_bytes), 0)
+	FROM videos
+	WHERE org_id = ?
+	`
+	var usage StorageUsage
+	err := c.db.QueryRow(query, orgID).Scan(&usage.VideoCount, &usage.StorageBytes)
+	if err != nil {
+		return StorageUsage{}, err
+	}
+	return usage, nil
+}
@@ -0,0 +1,394 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	PlaylistVisibilityPrivate  = "private"
+	PlaylistVisibilityUnlisted = "unlisted"
+	PlaylistVisibilityPublic   = "public"
+)
+
+var ErrInvalidPlaylistOrder = errors.New("video IDs must list every video in the playlist exactly once")
+
+type Playlist struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	CreatePlaylistParams
+	Videos []Video `json:"videos"`
+}
+
+type CreatePlaylistParams struct {
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	Visibility  string    `json:"visibility"`
+	UserID      uuid.UUID `json:"user_id"`
+}
+
+func (c Client) CreatePlaylist(params CreatePlaylistParams) (Playlist, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO playlists (
+		id,
+		created_at,
+		updated_at,
+		title,
+		description,
+		visibility,
+		user_id
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, id, params.Title, params.Description, params.Visibility, params.UserID)
+	if err != nil {
+		return Playlist{}, err
+	}
+
+	return c.GetPlaylist(id)
+}
+
+// GetPlaylist returns the playlist with its videos in order. It returns an
+// empty Playlist if no playlist has the given ID.
+func (c Client) GetPlaylist(id uuid.UUID) (Playlist, error) {
+	query := `
+	SELECT
+		id,
+		created_at,
+		updated_at,
+		title,
+		description,
+		visibility,
+		user_id
+	FROM playlists
+	WHERE id = ?
+	`
+
+	var playlist Playlist
+	err := c.db.QueryRow(query, id).Scan(
+		&playlist.ID,
+		&playlist.CreatedAt,
+		&playlist.UpdatedAt,
+		&playlist.Title,
+		&playlist.Description,
+		&playlist.Visibility,
+		&playlist.UserID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Playlist{}, nil
+		}
+		return Playlist{}, err
+	}
+
+	playlist.Videos, err = c.getPlaylistVideos(id)
+	if err != nil {
+		return Playlist{}, err
+	}
+
+	return playlist, nil
+}
+
+// GetPlaylists returns the user's playlists, newest first, each with its
+// videos in order.
+func (c Client) GetPlaylists(userID uuid.UUID) ([]Playlist, error) {
+	query := `
+	SELECT
+		id,
+		created_at,
+		updated_at,
+		title,
+		description,
+		visibility,
+		user_id
+	FROM playlists
+	WHERE user_id = ?
+	ORDER BY created_at DESC
+	`
+
+	rows, err := c.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	playlists := []Playlist{}
+	byID := map[uuid.UUID]int{}
+	for rows.Next() {
+		playlist := Playlist{Videos: []Video{}}
+		if err := rows.Scan(
+			&playlist.ID,
+			&playlist.CreatedAt,
+			&playlist.UpdatedAt,
+			&playlist.Title,
+			&playlist.Description,
+			&playlist.Visibility,
+			&playlist.UserID,
+		); err != nil {
+			return nil, err
+		}
+		byID[playlist.ID] = len(playlists)
+		playlists = append(playlists, playlist)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Load the videos of every playlist at once rather than one query per
+	// playlist.
+	videoRows, err := c.db.Query(`
+	SELECT
+		pv.playlist_id,
+		v.id,
+		v.created_at,
+		v.updated_at,
+		v.title,
+		v.description,
+		v.thumbnail_url,
+		v.video_url,
+		v.user_id,
+		v.org_id,
+		v.size_bytes
+	FROM playlist_videos pv
+	JOIN playlists p ON p.id = pv.playlist_id
+	JOIN videos v ON v.id = pv.video_id
+	WHERE p.user_id = ?
+	ORDER BY pv.playlist_id, pv.position
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer videoRows.Close()
+
+	for videoRows.Next() {
+		var playlistID uuid.UUID
+		var video Video
+		if err := videoRows.Scan(
+			&playlistID,
+			&video.ID,
+			&video.CreatedAt,
+			&video.UpdatedAt,
+			&video.Title,
+			&video.Description,
+			&video.ThumbnailURL,
+			&video.VideoURL,
+			&video.UserID,
+			&video.OrgID,
+			&video.SizeBytes,
+		); err != nil {
+			return nil, err
+		}
+		i, ok := byID[playlistID]
+		if !ok {
+			// Created after we listed the playlists.
+			continue
+		}
+		playlists[i].Videos = append(playlists[i].Videos, video)
+	}
+	if err := videoRows.Err(); err != nil {
+		return nil, err
+	}
+
+	return playlists, nil
+}
+
+func (c Client) UpdatePlaylist(playlist Playlist) (Playlist, error) {
+	query := `
+	UPDATE playlists
+	SET
+		updated_at = ?,
+		title = ?,
+		description = ?,
+		visibility = ?
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(
+		query,
+		time.Now().UTC(),
+		playlist.Title,
+		playlist.Description,
+		playlist.Visibility,
+		playlist.ID,
+	)
+	if err != nil {
+		return Playlist{}, err
+	}
+
+	return c.GetPlaylist(playlist.ID)
+}
+
+func (c Client) DeletePlaylist(id uuid.UUID) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM playlist_videos WHERE playlist_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM playlists WHERE id = ?`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// AddPlaylistVideo inserts a video into the playlist at position, shifting
+// later entries down. A negative or out of range position appends the video.
+// Adding a video that is already in the playlist moves it instead.
+func (c Client) AddPlaylistVideo(playlistID, videoID uuid.UUID, position int) (Playlist, error) {
+	return c.reorderPlaylist(playlistID, func(ids []uuid.UUID) ([]uuid.UUID, error) {
+		return insertAt(removeID(ids, videoID), videoID, position), nil
+	})
+}
+
+// MovePlaylistVideo moves a video that is already in the playlist to
+// position. It returns sql.ErrNoRows if the video isn't in the playlist.
+func (c Client) MovePlaylistVideo(playlistID, videoID uuid.UUID, position int) (Playlist, error) {
+	return c.reorderPlaylist(playlistID, func(ids []uuid.UUID) ([]uuid.UUID, error) {
+		remaining := removeID(ids, videoID)
+		if len(remaining) == len(ids) {
+			return nil, sql.ErrNoRows
+		}
+		return insertAt(remaining, videoID, position), nil
+	})
+}
+
+func (c Client) RemovePlaylistVideo(playlistID, videoID uuid.UUID) (Playlist, error) {
+	return c.reorderPlaylist(playlistID, func(ids []uuid.UUID) ([]uuid.UUID, error) {
+		return removeID(ids, videoID), nil
+	})
+}
+
+// SetPlaylistOrder reorders the playlist to match videoIDs, which must be a
+// permutation of the videos currently in it.
+func (c Client) SetPlaylistOrder(playlistID uuid.UUID, videoIDs []uuid.UUID) (Playlist, error) {
+	return c.reorderPlaylist(playlistID, func(ids []uuid.UUID) ([]uuid.UUID, error) {
+		if len(ids) != len(videoIDs) {
+			return nil, ErrInvalidPlaylistOrder
+		}
+		current := map[uuid.UUID]bool{}
+		for _, id := range ids {
+			current[id] = true
+		}
+		for _, id := range videoIDs {
+			if !current[id] {
+				return nil, ErrInvalidPlaylistOrder
+			}
+			delete(current, id)
+		}
+		return videoIDs, nil
+	})
+}
+
+// reorderPlaylist loads the playlist's video IDs in order, lets reorder
+// produce the new order and writes it back with contiguous positions, all in
+// a single transaction.
+func (c Client) reorderPlaylist(playlistID uuid.UUID, reorder func([]uuid.UUID) ([]uuid.UUID, error)) (Playlist, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return Playlist{}, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+		SELECT video_id
+		FROM playlist_videos
+		WHERE playlist_id = ?
+		ORDER BY position
+	`, playlistID)
+	if err != nil {
+		return Playlist{}, err
+	}
+	ids := []uuid.UUID{}
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return Playlist{}, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return Playlist{}, err
+	}
+
+	ids, err = reorder(ids)
+	if err != nil {
+		return Playlist{}, err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM playlist_videos WHERE playlist_id = ?`, playlistID); err != nil {
+		return Playlist{}, err
+	}
+	for position, id := range ids {
+		_, err := tx.Exec(`
+			INSERT INTO playlist_videos (playlist_id, video_id, position, created_at)
+			VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+		`, playlistID, id, position)
+		if err != nil {
+			return Playlist{}, err
+		}
+	}
+	if _, err := tx.Exec(`UPDATE playlists SET updated_at = ? WHERE id = ?`, time.Now().UTC(), playlistID); err != nil {
+		return Playlist{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Playlist{}, err
+	}
+
+	return c.GetPlaylist(playlistID)
+}
+
+func (c Client) getPlaylistVideos(playlistID uuid.UUID) ([]Video, error) {
+	query := `
+	SELECT
+		v.id,
+		v.created_at,
+		v.updated_at,
+		v.title,
+		v.description,
+		v.thumbnail_url,
+		v.video_url,
+		v.user_id,
+		v.org_id,
+		v.size_bytes
+	FROM playlist_videos pv
+	JOIN videos v ON v.id = pv.video_id
+	WHERE pv.playlist_id = ?
+	ORDER BY pv.position
+	`
+
+	rows, err := c.db.Query(query, playlistID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanVideos(rows)
+}
+
+func removeID(ids []uuid.UUID, target uuid.UUID) []uuid.UUID {
+	out := make([]uuid.UUID, 0, len(ids))
+	for _, id := range ids {
+		if id != target {
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+func insertAt(ids []uuid.UUID, id uuid.UUID, position int) []uuid.UUID {
+	if position < 0 || position > len(ids) {
+		position = len(ids)
+	}
+	out := make([]uuid.UUID, 0, len(ids)+1)
+	out = append(out, ids[:position]...)
+	out = append(out, id)
+	return append(out, ids[position:]...)
+}
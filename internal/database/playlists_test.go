@@ -0,0 +1,97 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestPlaylistOrder(t *testing.T) {
+	c := newTestClient(t)
+	user, err := c.CreateUser(CreateUserParams{Email: "alice@example.com", Password: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var a, b, d uuid.UUID
+	for _, id := range []*uuid.UUID{&a, &b, &d} {
+		video, err := c.CreateVideo(CreateVideoParams{Title: "video", UserID: user.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		*id = video.ID
+	}
+	playlist, err := c.CreatePlaylist(CreatePlaylistParams{Title: "mix", Visibility: PlaylistVisibilityPrivate, UserID: user.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(step string, playlist Playlist, err error, want ...uuid.UUID) {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("%s: %v", step, err)
+		}
+		got := []uuid.UUID{}
+		for _, video := range playlist.Videos {
+			got = append(got, video.ID)
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("%s: order = %v, want %v", step, got, want)
+		}
+	}
+
+	playlist, err = c.AddPlaylistVideo(playlist.ID, a, -1)
+	check("append", playlist, err, a)
+	playlist, err = c.AddPlaylistVideo(playlist.ID, b, 0)
+	check("insert at the start", playlist, err, b, a)
+	playlist, err = c.AddPlaylistVideo(playlist.ID, d, 1)
+	check("insert in the middle", playlist, err, b, d, a)
+	playlist, err = c.AddPlaylistVideo(playlist.ID, b, 99)
+	check("add a video already in the playlist", playlist, err, d, a, b)
+	playlist, err = c.MovePlaylistVideo(playlist.ID, b, 0)
+	check("move", playlist, err, b, d, a)
+	playlist, err = c.RemovePlaylistVideo(playlist.ID, d)
+	check("remove", playlist, err, b, a)
+	if _, err := c.MovePlaylistVideo(playlist.ID, d, 0); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("moving a video not in the playlist: got %v, want sql.ErrNoRows", err)
+	}
+
+	playlist, err = c.SetPlaylistOrder(playlist.ID, []uuid.UUID{a, b})
+	check("set order", playlist, err, a, b)
+	for name, ids := range map[string][]uuid.UUID{
+		"missing video":   {a},
+		"duplicate video": {a, a},
+		"other video":     {a, d},
+		"extra video":     {a, b, d},
+	} {
+		if _, err := c.SetPlaylistOrder(playlist.ID, ids); !errors.Is(err, ErrInvalidPlaylistOrder) {
+			t.Errorf("set order with %s: got %v, want ErrInvalidPlaylistOrder", name, err)
+		}
+	}
+	playlist, err = c.GetPlaylist(playlist.ID)
+	check("after rejected orders", playlist, err, a, b)
+
+	empty, err := c.CreatePlaylist(CreatePlaylistParams{Title: "empty", Visibility: PlaylistVisibilityPublic, UserID: user.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	playlists, err := c.GetPlaylists(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(playlists) != 2 {
+		t.Fatalf("GetPlaylists returned %d playlists, want 2", len(playlists))
+	}
+	for _, got := range playlists {
+		switch got.ID {
+		case playlist.ID:
+			check("GetPlaylists", got, nil, a, b)
+		case empty.ID:
+			if got.Videos == nil || len(got.Videos) != 0 {
+				t.Errorf("empty playlist has videos %v, want []", got.Videos)
+			}
+		}
+	}
+}
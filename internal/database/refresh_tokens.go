@@ -2,35 +2,80 @@ package database
 
 import (
 	"database/sql"
+	"errors"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+// ErrRefreshTokenReused is returned when rotating a refresh token that has
+// already been revoked, which means someone is replaying an old token.
+var ErrRefreshTokenReused = errors.New("refresh token has already been used")
+
+// RefreshToken is the currently valid token of a login session. Rotating the
+// token keeps the session's created_at and bumps last_used_at.
 type RefreshToken struct {
 	CreateRefreshTokenParams
-	CreatedAt time.Time  `json:"created_at"`
-	UpdatedAt time.Time  `json:"updated_at"`
-	RevokedAt *time.Time `json:"revoked_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
 }
 
 type CreateRefreshTokenParams struct {
 	Token     string    `json:"token"`
 	UserID    uuid.UUID `json:"user_id"`
 	ExpiresAt time.Time `json:"expires_at"`
+	// FamilyID links every token issued by rotating the token handed out at
+	// login. Leave it empty when logging in to start a new family.
+	FamilyID  string `json:"family_id"`
+	UserAgent string `json:"user_agent"`
+	IP        string `json:"ip"`
+	// Scopes granted to access tokens issued with this refresh token. Empty
+	// for sessions started before scopes existed.
+	Scopes []string `json:"scopes"`
+}
+
+// Session is a login as shown to its user. Its ID is the refresh token
+// family, so it stays the same across rotations and never reveals a token.
+type Session struct {
+	ID         string     `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	UserAgent  string     `json:"user_agent"`
+	IP         string     `json:"ip"`
 }
 
 func (c Client) CreateRefreshToken(params CreateRefreshTokenParams) (RefreshToken, error) {
+	if params.FamilyID == "" {
+		params.FamilyID = uuid.New().String()
+	}
+
 	query := `
 		INSERT INTO refresh_tokens (
 			token,
 			created_at,
 			updated_at,
 			user_id,
-			expires_at
-		) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?)
+			expires_at,
+			family_id,
+			user_agent,
+			ip,
+			scopes
+		) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, ?)
 	`
-	_, err := c.db.Exec(query, params.Token, params.UserID.String(), params.ExpiresAt)
+	_, err := c.db.Exec(
+		query,
+		params.Token,
+		params.UserID.String(),
+		params.ExpiresAt,
+		params.FamilyID,
+		params.UserAgent,
+		params.IP,
+		strings.Join(params.Scopes, " "),
+	)
 	if err != nil {
 		return RefreshToken{}, err
 	}
@@ -38,6 +83,62 @@ func (c Client) CreateRefreshToken(params CreateRefreshTokenParams) (RefreshToke
 	return c.GetRefreshToken(params.Token)
 }
 
+// RotateRefreshToken revokes oldToken and issues next in the same family,
+// carrying over when the session started and its scopes. It returns
+// ErrRefreshTokenReused if oldToken was already revoked.
+func (c Client) RotateRefreshToken(oldToken string, next CreateRefreshTokenParams) (RefreshToken, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	defer tx.Rollback()
+
+	// Only the first of two racing rotations gets to revoke the token, the
+	// other one is treated as a replay.
+	result, err := tx.Exec(`
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE token = ? AND revoked_at IS NULL
+	`, oldToken)
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	revoked, err := result.RowsAffected()
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	if revoked == 0 {
+		return RefreshToken{}, ErrRefreshTokenReused
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO refresh_tokens (
+			token,
+			created_at,
+			updated_at,
+			last_used_at,
+			user_id,
+			expires_at,
+			family_id,
+			user_agent,
+			ip,
+			scopes
+		)
+		SELECT ?, created_at, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, user_id, ?, family_id, ?, ?, scopes
+		FROM refresh_tokens
+		WHERE token = ?
+	`, next.Token, next.ExpiresAt, next.UserAgent, next.IP, oldToken)
+	if err != nil {
+		return RefreshToken{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return RefreshToken{}, err
+	}
+
+	return c.GetRefreshToken(next.Token)
+}
+
 func (c Client) RevokeRefreshToken(token string) error {
 	query := `
 		UPDATE refresh_tokens
@@ -48,16 +149,54 @@ func (c Client) RevokeRefreshToken(token string) error {
 	return err
 }
 
+// RevokeRefreshTokenFamily revokes every still active token the user has in
+// the given family, ending that session.
+func (c Client) RevokeRefreshTokenFamily(userID uuid.UUID, familyID string) error {
+	query := `
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE user_id = ?
+		AND family_id = ?
+		AND revoked_at IS NULL
+	`
+	_, err := c.db.Exec(query, userID.String(), familyID)
+	return err
+}
+
+// RevokeUserRefreshTokens revokes every active token the user has, ending all
+// of their sessions.
+func (c Client) RevokeUserRefreshTokens(userID uuid.UUID) error {
+	query := `
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE user_id = ?
+		AND revoked_at IS NULL
+	`
+	_, err := c.db.Exec(query, userID.String())
+	return err
+}
+
 func (c Client) GetRefreshToken(token string) (RefreshToken, error) {
 	query := `
-		SELECT token, created_at, updated_at, user_id, expires_at, revoked_at
+		SELECT token, created_at, updated_at, user_id, expires_at, revoked_at, last_used_at, family_id, user_agent, ip, scopes
 		FROM refresh_tokens
 		WHERE token = ?
 	`
 	var rt RefreshToken
-	var userID string
-	err := c.db.QueryRow(query, token).
-		Scan(&rt.Token, &rt.CreatedAt, &rt.UpdatedAt, &userID, &rt.ExpiresAt, &rt.RevokedAt)
+	var userID, scopes string
+	err := c.db.QueryRow(query, token).Scan(
+		&rt.Token,
+		&rt.CreatedAt,
+		&rt.UpdatedAt,
+		&userID,
+		&rt.ExpiresAt,
+		&rt.RevokedAt,
+		&rt.LastUsedAt,
+		&rt.FamilyID,
+		&rt.UserAgent,
+		&rt.IP,
+		&scopes,
+	)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return RefreshToken{}, nil
@@ -69,10 +208,39 @@ func (c Client) GetRefreshToken(token string) (RefreshToken, error) {
 	if err != nil {
 		return RefreshToken{}, err
 	}
+	rt.Scopes = strings.Fields(scopes)
 
 	return rt, nil
 }
 
+// GetSessions returns the user's active sessions, most recently used first.
+func (c Client) GetSessions(userID uuid.UUID) ([]Session, error) {
+	query := `
+		SELECT family_id, created_at, last_used_at, expires_at, user_agent, ip
+		FROM refresh_tokens
+		WHERE user_id = ?
+		AND revoked_at IS NULL
+		AND expires_at > ?
+		ORDER BY COALESCE(last_used_at, created_at) DESC
+	`
+	rows, err := c.db.Query(query, userID.String(), time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		var s Session
+		if err := rows.Scan(&s.ID, &s.CreatedAt, &s.LastUsedAt, &s.ExpiresAt, &s.UserAgent, &s.IP); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+
+	return sessions, rows.Err()
+}
+
 func (c Client) DeleteRefreshToken(token string) error {
 	query := `
 		DELETE FROM refresh_tokens
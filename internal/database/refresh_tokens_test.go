@@ -0,0 +1,63 @@
+package database
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestRotateRefreshToken(t *testing.T) {
+	c := newTestClient(t)
+	user, err := c.CreateUser(CreateUserParams{Email: "alice@example.com", Password: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiresAt := time.Now().UTC().Add(time.Hour)
+	first, err := c.CreateRefreshToken(CreateRefreshTokenParams{
+		Token:     "first",
+		UserID:    user.ID,
+		ExpiresAt: expiresAt,
+		Scopes:    []string{"videos:read"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := c.CreateRefreshToken(CreateRefreshTokenParams{Token: "other", UserID: user.ID, ExpiresAt: expiresAt})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.FamilyID == "" || first.FamilyID == other.FamilyID {
+		t.Fatalf("logins got families %q and %q, want different ones", first.FamilyID, other.FamilyID)
+	}
+
+	second, err := c.RotateRefreshToken("first", CreateRefreshTokenParams{Token: "second", ExpiresAt: expiresAt, UserAgent: "curl"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.FamilyID != first.FamilyID || second.UserID != user.ID || second.UserAgent != "curl" ||
+		!slices.Equal(second.Scopes, []string{"videos:read"}) || second.LastUsedAt == nil || second.RevokedAt != nil {
+		t.Errorf("rotated token is %+v, want it to carry on from %+v", second, first)
+	}
+	if old, _ := c.GetRefreshToken("first"); old.RevokedAt == nil {
+		t.Error("rotated token wasn't revoked")
+	}
+
+	_, err = c.RotateRefreshToken("first", CreateRefreshTokenParams{Token: "third", ExpiresAt: expiresAt})
+	if !errors.Is(err, ErrRefreshTokenReused) {
+		t.Errorf("rotating a revoked token: got %v, want ErrRefreshTokenReused", err)
+	}
+	if third, _ := c.GetRefreshToken("third"); third.Token != "" {
+		t.Error("rotating a revoked token issued a new one")
+	}
+
+	if err := c.RevokeRefreshTokenFamily(user.ID, first.FamilyID); err != nil {
+		t.Fatal(err)
+	}
+	if second, _ := c.GetRefreshToken("second"); second.RevokedAt == nil {
+		t.Error("revoking the family left its latest token active")
+	}
+	if other, _ := c.GetRefreshToken("other"); other.RevokedAt != nil {
+		t.Error("revoking a family revoked another session")
+	}
+}
@@ -0,0 +1,67 @@
+package database
+
+import "github.com/google/uuid"
+
+// UserStorageUsage is how much a user's personal library takes up.
+type UserStorageUsage struct {
+	UserID uuid.UUID `json:"user_id"`
+	Email  string    `json:"email"`
+	StorageUsage
+}
+
+// GetStorageUsage returns how many videos there are and how much space they
+// take up in total.
+func (c Client) GetStorageUsage() (StorageUsage, error) {
+	var usage StorageUsage
+	err := c.db.QueryRow(`SELECT COUNT(*), COALESCE(SUM(size_bytes), 0) FROM videos`).
+		Scan(&usage.VideoCount, &usage.StorageBytes)
+	if err != nil {
+		return StorageUsage{}, err
+	}
+	return usage, nil
+}
+
+// GetUserStorageUsage returns how much the user's personal videos take up.
+// Organization videos count towards the organization instead.
+func (c Client) GetUserStorageUsage(userID uuid.UUID) (StorageUsage, error) {
+	query := `
+		SELECT COUNT(*), COALESCE(SUM(size_bytes), 0)
+		FROM videos
+		WHERE user_id = ? AND org_id IS NULL
+	`
+	var usage StorageUsage
+	err := c.db.QueryRow(query, userID).Scan(&usage.VideoCount, &usage.StorageBytes)
+	if err != nil {
+		return StorageUsage{}, err
+	}
+	return usage, nil
+}
+
+// GetTopStorageUsers returns the limit users whose personal videos take up
+// the most space, largest first.
+func (c Client) GetTopStorageUsers(limit int) ([]UserStorageUsage, error) {
+	query := `
+		SELECT u.id, u.email, COUNT(*), SUM(v.size_bytes) AS storage_bytes
+		FROM videos v
+		JOIN users u ON u.id = v.user_id
+		WHERE v.org_id IS NULL
+		GROUP BY u.id, u.email
+		ORDER BY storage_bytes DESC, u.email
+		LIMIT ?
+	`
+	rows, err := c.db.Query(query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	usages := []UserStorageUsage{}
+	for rows.Next() {
+		var u UserStorageUsage
+		if err := rows.Scan(&u.UserID, &u.Email, &u.VideoCount, &u.StorageBytes); err != nil {
+			return nil, err
+		}
+		usages = append(usages, u)
+	}
+	return usages, rows.Err()
+}
@@ -0,0 +1,115 @@
+package database
+
+import (
+	"github.com/google/uuid"
+)
+
+type TagCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// SetVideoTags replaces the tags on a video with names, creating any tags
+// that don't exist yet. It returns the video's tags after the update.
+func (c Client) SetVideoTags(videoID uuid.UUID, names []string) ([]string, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM video_tags WHERE video_id = ?`, videoID); err != nil {
+		return nil, err
+	}
+
+	for _, name := range names {
+		_, err := tx.Exec(`
+			INSERT INTO tags (id, created_at, name)
+			VALUES (?, CURRENT_TIMESTAMP, ?)
+			ON CONFLICT(name) DO NOTHING
+		`, uuid.New().String(), name)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = tx.Exec(`
+			INSERT OR IGNORE INTO video_tags (video_id, tag_id, created_at)
+			SELECT ?, id, CURRENT_TIMESTAMP FROM tags WHERE name = ?
+		`, videoID, name)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return c.GetVideoTags(videoID)
+}
+
+func (c Client) RemoveVideoTag(videoID uuid.UUID, name string) error {
+	query := `
+		DELETE FROM video_tags
+		WHERE video_id = ?
+		AND tag_id IN (SELECT id FROM tags WHERE name = ?)
+	`
+	_, err := c.db.Exec(query, videoID, name)
+	return err
+}
+
+func (c Client) GetVideoTags(videoID uuid.UUID) ([]string, error) {
+	query := `
+		SELECT t.name
+		FROM tags t
+		JOIN video_tags vt ON vt.tag_id = t.id
+		WHERE vt.video_id = ?
+		ORDER BY t.name
+	`
+	rows, err := c.db.Query(query, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		tags = append(tags, name)
+	}
+
+	return tags, rows.Err()
+}
+
+// GetTagCounts returns every tag used on the user's videos along with the
+// number of videos carrying it.
+func (c Client) GetTagCounts(userID uuid.UUID) ([]TagCount, error) {
+	query := `
+		SELECT t.name, COUNT(*)
+		FROM tags t
+		JOIN video_tags vt ON vt.tag_id = t.id
+		JOIN videos v ON v.id = vt.video_id
+		WHERE v.user_id = ?
+		GROUP BY t.name
+		ORDER BY t.name
+	`
+	rows, err := c.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []TagCount{}
+	for rows.Next() {
+		var tc TagCount
+		if err := rows.Scan(&tc.Name, &tc.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, tc)
+	}
+
+	return counts, rows.Err()
+}
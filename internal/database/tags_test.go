@@ -0,0 +1,57 @@
+package database
+
+import "testing"
+
+func TestListVideosByTags(t *testing.T) {
+	c := newTestClient(t)
+	user, err := c.CreateUser(CreateUserParams{Email: "alice@example.com", Password: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	videos := map[string][]string{
+		"both":      {"cats", "travel"},
+		"all three": {"cats", "travel", "food"},
+		"cats":      {"cats"},
+		"travel":    {"travel"},
+		"untagged":  nil,
+	}
+	for title, tags := range videos {
+		video, err := c.CreateVideo(CreateVideoParams{Title: title, UserID: user.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.SetVideoTags(video.ID, tags); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		tags []string
+		want []string
+	}{
+		{nil, []string{"all three", "both", "cats", "travel", "untagged"}},
+		{[]string{"cats"}, []string{"all three", "both", "cats"}},
+		{[]string{"cats", "travel"}, []string{"all three", "both"}},
+		{[]string{"cats", "travel", "food"}, []string{"all three"}},
+		{[]string{"cats", "dogs"}, nil},
+	}
+	for _, tt := range tests {
+		got, err := c.ListVideos(ListVideosParams{UserID: user.ID, Tags: tt.tags})
+		if err != nil {
+			t.Fatal(err)
+		}
+		titles := map[string]bool{}
+		for _, video := range got {
+			titles[video.Title] = true
+		}
+		if len(titles) != len(tt.want) {
+			t.Errorf("tags %q: got %d videos, want %q", tt.tags, len(got), tt.want)
+			continue
+		}
+		for _, title := range tt.want {
+			if !titles[title] {
+				t.Errorf("tags %q: missing %q", tt.tags, title)
+			}
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TOTPFactor is a user's authenticator app. It only counts as a second factor
+// once confirmed.
+type TOTPFactor struct {
+	UserID      uuid.UUID  `json:"user_id"`
+	CreatedAt   time.Time  `json:"created_at"`
+	Secret      string     `json:"-"`
+	ConfirmedAt *time.Time `json:"confirmed_at"`
+	// LastUsedStep is the TOTP time step of the last accepted code, codes
+	// for it or earlier steps are rejected.
+	LastUsedStep int64 `json:"-"`
+}
+
+// SetTOTPFactor starts enrolling a new authenticator, replacing any
+// unconfirmed one.
+func (c Client) SetTOTPFactor(userID uuid.UUID, secret string) error {
+	query := `
+		INSERT INTO totp_factors (user_id, created_at, secret)
+		VALUES (?, CURRENT_TIMESTAMP, ?)
+		ON CONFLICT(user_id) DO UPDATE SET
+			created_at = CURRENT_TIMESTAMP,
+			secret = excluded.secret,
+			confirmed_at = NULL,
+			last_used_step = 0
+	`
+	_, err := c.db.Exec(query, userID, secret)
+	return err
+}
+
+// GetTOTPFactor returns an empty TOTPFactor if the user has none.
+func (c Client) GetTOTPFactor(userID uuid.UUID) (TOTPFactor, error) {
+	query := `
+		SELECT user_id, created_at, secret, confirmed_at, last_used_step
+		FROM totp_factors
+		WHERE user_id = ?
+	`
+	var f TOTPFactor
+	err := c.db.QueryRow(query, userID).Scan(&f.UserID, &f.CreatedAt, &f.Secret, &f.ConfirmedAt, &f.LastUsedStep)
+	if errors.Is(err, sql.ErrNoRows) {
+		return TOTPFactor{}, nil
+	}
+	if err != nil {
+		return TOTPFactor{}, err
+	}
+	return f, nil
+}
+
+// ConfirmTOTPFactor turns on two-factor authentication for the user, having
+// accepted a code for step, and replaces their recovery codes.
+func (c Client) ConfirmTOTPFactor(userID uuid.UUID, step int64, recoveryCodeHashes []string) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE totp_factors
+		SET confirmed_at = CURRENT_TIMESTAMP, last_used_step = ?
+		WHERE user_id = ?
+	`, step, userID)
+	if err != nil {
+		return err
+	}
+	if err := replaceRecoveryCodes(tx, userID, recoveryCodeHashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UseTOTPStep records that a code for step was accepted. It returns false if a
+// code for that step or a later one already was, which means it's a replay.
+func (c Client) UseTOTPStep(userID uuid.UUID, step int64) (bool, error) {
+	result, err := c.db.Exec(`
+		UPDATE totp_factors
+		SET last_used_step = ?
+		WHERE user_id = ? AND last_used_step < ?
+	`, step, userID, step)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+// UseRecoveryCode uses up the user's recovery code with the given hash. It
+// returns false if there's no such unused code.
+func (c Client) UseRecoveryCode(userID uuid.UUID, codeHash string) (bool, error) {
+	result, err := c.db.Exec(`
+		UPDATE recovery_codes
+		SET used_at = CURRENT_TIMESTAMP
+		WHERE user_id = ? AND code_hash = ? AND used_at IS NULL
+	`, userID, codeHash)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+func (c Client) SetRecoveryCodes(userID uuid.UUID, codeHashes []string) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := replaceRecoveryCodes(tx, userID, codeHashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteTOTPFactor turns off two-factor authentication for the user.
+func (c Client) DeleteTOTPFactor(userID uuid.UUID) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM totp_factors WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	if err := replaceRecoveryCodes(tx, userID, nil); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func replaceRecoveryCodes(tx *sql.Tx, userID uuid.UUID, codeHashes []string) error {
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	for _, hash := range codeHashes {
+		_, err := tx.Exec(`
+			INSERT INTO recovery_codes (code_hash, user_id, created_at)
+			VALUES (?, ?, CURRENT_TIMESTAMP)
+		`, hash, userID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
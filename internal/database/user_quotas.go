@@ -0,0 +1,52 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UserQuota overrides the default quotas for one user's personal videos. Nil
+// fields use the default, zero means unlimited.
+type UserQuota struct {
+	MaxVideos          *int   `json:"max_videos"`
+	MaxStorageBytes    *int64 `json:"max_storage_bytes"`
+	MaxFileBytes       *int64 `json:"max_file_bytes"`
+	MaxDurationSeconds *int   `json:"max_duration_seconds"`
+}
+
+// GetUserQuota returns an empty UserQuota if the user has no overrides.
+func (c Client) GetUserQuota(userID uuid.UUID) (UserQuota, error) {
+	query := `
+		SELECT max_videos, max_storage_bytes, max_file_bytes, max_duration_seconds
+		FROM user_quotas
+		WHERE user_id = ?
+	`
+	var q UserQuota
+	err := c.db.QueryRow(query, userID).Scan(&q.MaxVideos, &q.MaxStorageBytes, &q.MaxFileBytes, &q.MaxDurationSeconds)
+	if errors.Is(err, sql.ErrNoRows) {
+		return UserQuota{}, nil
+	}
+	if err != nil {
+		return UserQuota{}, err
+	}
+	return q, nil
+}
+
+// SetUserQuota replaces the user's quota overrides.
+func (c Client) SetUserQuota(userID uuid.UUID, quota UserQuota) error {
+	query := `
+		INSERT INTO user_quotas (user_id, updated_at, max_videos, max_storage_bytes, max_file_bytes, max_duration_seconds)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET
+			updated_at = excluded.updated_at,
+			max_videos = excluded.max_videos,
+			max_storage_bytes = excluded.max_storage_bytes,
+			max_file_bytes = excluded.max_file_bytes,
+			max_duration_seconds = excluded.max_duration_seconds
+	`
+	_, err := c.db.Exec(query, userID, time.Now().UTC(), quota.MaxVideos, quota.MaxStorageBytes, quota.MaxFileBytes, quota.MaxDurationSeconds)
+	return err
+}
@@ -0,0 +1,92 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Purposes of the single use tokens we email to users.
+const (
+	UserTokenVerifyEmail   = "verify_email"
+	UserTokenResetPassword = "reset_password"
+)
+
+// UserToken is a single use token emailed to a user. Only its hash is
+// stored.
+type UserToken struct {
+	CreatedAt time.Time  `json:"created_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreateUserTokenParams
+}
+
+type CreateUserTokenParams struct {
+	TokenHash string    `json:"-"`
+	UserID    uuid.UUID `json:"user_id"`
+	Purpose   string    `json:"purpose"`
+	// Email is the address the token was sent to.
+	Email     string    `json:"email"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (c Client) CreateUserToken(params CreateUserTokenParams) error {
+	query := `
+		INSERT INTO user_tokens (token_hash, created_at, user_id, purpose, email, expires_at)
+		VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, params.TokenHash, params.UserID, params.Purpose, params.Email, params.ExpiresAt)
+	return err
+}
+
+// ConsumeUserToken uses up the unexpired token for purpose with the given
+// hash, along with every other outstanding token the user has for the same
+// purpose. It returns an empty UserToken if there is no such token or it has
+// already been used.
+func (c Client) ConsumeUserToken(tokenHash, purpose string) (UserToken, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return UserToken{}, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	var token UserToken
+	err = tx.QueryRow(`
+		SELECT token_hash, created_at, user_id, purpose, email, expires_at
+		FROM user_tokens
+		WHERE token_hash = ?
+		AND purpose = ?
+		AND used_at IS NULL
+		AND expires_at > ?
+	`, tokenHash, purpose, now).Scan(
+		&token.TokenHash,
+		&token.CreatedAt,
+		&token.UserID,
+		&token.Purpose,
+		&token.Email,
+		&token.ExpiresAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return UserToken{}, nil
+	}
+	if err != nil {
+		return UserToken{}, err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE user_tokens
+		SET used_at = ?
+		WHERE user_id = ? AND purpose = ? AND used_at IS NULL
+	`, now, token.UserID, purpose)
+	if err != nil {
+		return UserToken{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return UserToken{}, err
+	}
+
+	token.UsedAt = &now
+	return token, nil
+}
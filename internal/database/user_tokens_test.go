@@ -0,0 +1,70 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestClient(t *testing.T) Client {
+	t.Helper()
+	c, err := NewClient(filepath.Join(t.TempDir(), "tubely.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestConsumeUserToken(t *testing.T) {
+	c := newTestClient(t)
+	user, err := c.CreateUser(CreateUserParams{Email: "alice@example.com", Password: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	create := func(hash, purpose string, expiresAt time.Time) {
+		t.Helper()
+		err := c.CreateUserToken(CreateUserTokenParams{
+			TokenHash: hash,
+			UserID:    user.ID,
+			Purpose:   purpose,
+			Email:     user.Email,
+			ExpiresAt: expiresAt,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	later := time.Now().UTC().Add(time.Hour)
+	create("first", UserTokenResetPassword, later)
+	create("second", UserTokenResetPassword, later)
+	create("verify", UserTokenVerifyEmail, later)
+	create("expired", UserTokenVerifyEmail, time.Now().UTC().Add(-time.Minute))
+
+	tests := []struct {
+		name    string
+		hash    string
+		purpose string
+		want    bool
+	}{
+		{"wrong purpose", "first", UserTokenVerifyEmail, false},
+		{"valid", "first", UserTokenResetPassword, true},
+		{"used", "first", UserTokenResetPassword, false},
+		{"outstanding for the same purpose", "second", UserTokenResetPassword, false},
+		{"expired", "expired", UserTokenVerifyEmail, false},
+		{"other purpose still valid", "verify", UserTokenVerifyEmail, true},
+		{"unknown", "nope", UserTokenVerifyEmail, false},
+	}
+	for _, tt := range tests {
+		token, err := c.ConsumeUserToken(tt.hash, tt.purpose)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := token.TokenHash != ""; got != tt.want {
+			t.Errorf("%s: consumed %v, want %v", tt.name, got, tt.want)
+		}
+		if tt.want && (token.UserID != user.ID || token.UsedAt == nil) {
+			t.Errorf("%s: got token %+v", tt.name, token)
+		}
+	}
+}
@@ -3,32 +3,87 @@ package database
 import (
 	"database/sql"
 	"errors"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+const (
+	UserRoleUser  = "user"
+	UserRoleAdmin = "admin"
+)
+
 type User struct {
 	ID        uuid.UUID `json:"id"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+	// EmailVerifiedAt is nil until the user proves they receive mail at
+	// their address.
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	Role            string     `json:"role"`
+	// DisabledAt is set when an admin disables the account, which stops the
+	// user logging in or using any credential they already hold.
+	DisabledAt *time.Time `json:"disabled_at"`
 	CreateUserParams
 }
 
 type CreateUserParams struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Email string `json:"email"`
+	// Password is the argon2id hash of the user's password, empty for users
+	// who only log in through single sign-on.
+	Password string `json:"-"`
+}
+
+// userColumns are the columns scanUser expects, prefixed for a users table
+// aliased as u.
+const userColumns = `u.id, u.created_at, u.updated_at, u.email_verified_at, u.role, u.disabled_at, u.email, u.password`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanUser(row rowScanner) (User, error) {
+	var user User
+	var id string
+	err := row.Scan(&id, &user.CreatedAt, &user.UpdatedAt, &user.EmailVerifiedAt, &user.Role, &user.DisabledAt, &user.Email, &user.Password)
+	if err != nil {
+		return User{}, err
+	}
+	user.ID, err = uuid.Parse(id)
+	if err != nil {
+		return User{}, err
+	}
+	return user, nil
 }
 
 func (c Client) GetUsers() ([]User, error) {
-	query := `
-		SELECT
-			id,
-			email
-		FROM users
-	`
+	return c.SearchUsers(SearchUsersParams{})
+}
+
+type SearchUsersParams struct {
+	// Query, if set, restricts the results to users whose email contains it.
+	Query string
+	// Limit is the most users to return, zero means no limit.
+	Limit  int
+	Offset int
+}
 
-	rows, err := c.db.Query(query)
+// SearchUsers returns users ordered by email.
+func (c Client) SearchUsers(params SearchUsersParams) ([]User, error) {
+	query := `SELECT ` + userColumns + ` FROM users u`
+	args := []interface{}{}
+	if params.Query != "" {
+		query += ` WHERE u.email LIKE ? ESCAPE '\'`
+		args = append(args, "%"+escapeLike(params.Query)+"%")
+	}
+	query += ` ORDER BY u.email`
+	if params.Limit > 0 {
+		query += ` LIMIT ? OFFSET ?`
+		args = append(args, params.Limit, params.Offset)
+	}
+
+	rows, err := c.db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -36,65 +91,48 @@ func (c Client) GetUsers() ([]User, error) {
 
 	users := []User{}
 	for rows.Next() {
-		var user User
-		var id string
-		if err := rows.Scan(&id, &user.Email); err != nil {
-			return nil, err
-		}
-		user.ID, err = uuid.Parse(id)
+		user, err := scanUser(rows)
 		if err != nil {
 			return nil, err
 		}
 		users = append(users, user)
 	}
 
-	return users, nil
+	return users, rows.Err()
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
 }
 
 func (c Client) GetUserByEmail(email string) (User, error) {
-	query := `
-		SELECT id, created_at, updated_at, email, password
-		FROM users
-		WHERE email = ?
-	`
-	var user User
-	var id string
-	err := c.db.QueryRow(query, email).Scan(&id, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password)
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return User{}, nil
-		}
-		return User{}, err
+	query := `SELECT ` + userColumns + ` FROM users u WHERE u.email = ?`
+	user, err := scanUser(c.db.QueryRow(query, email))
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, nil
 	}
-	user.ID, err = uuid.Parse(id)
-	if err != nil {
-		return User{}, err
-	}
-	return user, nil
+	return user, err
 }
 
+// GetUserByRefreshToken returns the user the token was issued to, or nil if
+// the token doesn't exist, has been revoked or has expired.
 func (c Client) GetUserByRefreshToken(token string) (*User, error) {
 	query := `
-		SELECT u.id, u.email, u.created_at, u.updated_at, u.password
+		SELECT ` + userColumns + `
 		FROM users u
 		JOIN refresh_tokens rt ON u.id = rt.user_id
 		WHERE rt.token = ?
+		AND rt.revoked_at IS NULL
+		AND rt.expires_at > ?
 	`
 
-	var user User
-	var id string
-	err := c.db.QueryRow(query, token).Scan(&id, &user.Email, &user.CreatedAt, &user.UpdatedAt, &user.Password)
+	user, err := scanUser(c.db.QueryRow(query, token, time.Now().UTC()))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
 		}
 		return nil, err
 	}
-	user.ID, err = uuid.Parse(id)
-	if err != nil {
-		return nil, err
-	}
-
 	return &user, nil
 }
 
@@ -116,25 +154,173 @@ func (c Client) CreateUser(params CreateUserParams) (*User, error) {
 }
 
 func (c Client) GetUser(id uuid.UUID) (*User, error) {
-	query := `
-		SELECT id, created_at, updated_at, email, password
-		FROM users
-		WHERE id = ?
-	`
-	var user User
-	var idStr string
-	err := c.db.QueryRow(query, id.String()).Scan(&idStr, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password)
+	query := `SELECT ` + userColumns + ` FROM users u WHERE u.id = ?`
+	user, err := scanUser(c.db.QueryRow(query, id.String()))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
 		}
 		return nil, err
 	}
-	user.ID, err = uuid.Parse(idStr)
+	return &user, nil
+}
+
+// MarkEmailVerified records that the user has proven they own email. It does
+// nothing if the user's address has changed to something else since.
+func (c Client) MarkEmailVerified(id uuid.UUID, email string) error {
+	query := `
+		UPDATE users
+		SET email_verified_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND email = ? AND email_verified_at IS NULL
+	`
+	_, err := c.db.Exec(query, id.String(), email)
+	return err
+}
+
+func (c Client) UpdateUserPassword(id uuid.UUID, hashedPassword string) error {
+	query := `
+		UPDATE users
+		SET password = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	_, err := c.db.Exec(query, hashedPassword, id.String())
+	return err
+}
+
+func (c Client) SetUserRole(id uuid.UUID, role string) error {
+	query := `
+		UPDATE users
+		SET role = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	_, err := c.db.Exec(query, role, id.String())
+	return err
+}
+
+// SetUserDisabled disables or re-enables the user's account. Disabling also
+// revokes their refresh tokens.
+func (c Client) SetUserDisabled(id uuid.UUID, disabled bool) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if disabled {
+		_, err = tx.Exec(`
+			UPDATE users
+			SET disabled_at = COALESCE(disabled_at, CURRENT_TIMESTAMP), updated_at = CURRENT_TIMESTAMP
+			WHERE id = ?
+		`, id.String())
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`
+			UPDATE refresh_tokens
+			SET revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+			WHERE user_id = ? AND revoked_at IS NULL
+		`, id.String())
+	} else {
+		_, err = tx.Exec(`
+			UPDATE users
+			SET disabled_at = NULL, updated_at = CURRENT_TIMESTAMP
+			WHERE id = ?
+		`, id.String())
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UpdateUserEmail changes the user's email address, which then needs
+// verifying again.
+func (c Client) UpdateUserEmail(id uuid.UUID, email string) error {
+	query := `
+		UPDATE users
+		SET email = ?, email_verified_at = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	_, err := c.db.Exec(query, email, id.String())
+	return err
+}
+
+// DeleteUserAccount deletes the user along with everything that belongs to
+// them: their personal videos, playlists, sessions, API keys, linked
+// identities and second factors. The organizations in orgIDs are deleted too,
+// with their videos, and the user is removed from every other organization
+// and video they were a member of.
+func (c Client) DeleteUserAccount(id uuid.UUID, orgIDs []uuid.UUID) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	videoIDs, err := queryIDs(tx, `SELECT id FROM videos WHERE user_id = ? AND org_id IS NULL`, id)
+	if err != nil {
+		return err
+	}
+	for _, orgID := range orgIDs {
+		orgVideoIDs, err := queryIDs(tx, `SELECT id FROM videos WHERE org_id = ?`, orgID)
+		if err != nil {
+			return err
+		}
+		videoIDs = append(videoIDs, orgVideoIDs...)
+	}
+	for _, videoID := range videoIDs {
+		if err := deleteVideo(tx, videoID); err != nil {
+			return err
+		}
+	}
+
+	for _, orgID := range orgIDs {
+		if _, err := tx.Exec(`DELETE FROM org_members WHERE org_id = ?`, orgID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM orgs WHERE id = ?`, orgID); err != nil {
+			return err
+		}
+	}
+
+	statements := []string{
+		`DELETE FROM playlist_videos WHERE playlist_id IN (SELECT id FROM playlists WHERE user_id = ?)`,
+		`DELETE FROM playlists WHERE user_id = ?`,
+		`DELETE FROM video_collaborators WHERE user_id = ?`,
+		`DELETE FROM org_members WHERE user_id = ?`,
+		`DELETE FROM api_keys WHERE user_id = ?`,
+		`DELETE FROM refresh_tokens WHERE user_id = ?`,
+		`DELETE FROM user_identities WHERE user_id = ?`,
+		`DELETE FROM user_tokens WHERE user_id = ?`,
+		`DELETE FROM recovery_codes WHERE user_id = ?`,
+		`DELETE FROM totp_factors WHERE user_id = ?`,
+		`DELETE FROM user_quotas WHERE user_id = ?`,
+		`DELETE FROM users WHERE id = ?`,
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func queryIDs(tx *sql.Tx, query string, args ...interface{}) ([]uuid.UUID, error) {
+	rows, err := tx.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
-	return &user, nil
+	defer rows.Close()
+
+	ids := []uuid.UUID{}
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
 }
 
 func (c Client) DeleteUser(id uuid.UUID) error {
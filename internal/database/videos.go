@@ -3,24 +3,29 @@ package database
 import (
 	"database/sql"
 	"errors"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+var ErrVideoModified = errors.New("video was modified since it was last read")
+
 type Video struct {
 	ID           uuid.UUID `json:"id"`
 	CreatedAt    time.Time `json:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at"`
 	ThumbnailURL *string   `json:"thumbnail_url"`
 	VideoURL     *string   `json:"video_url"`
+	SizeBytes    int64     `json:"size_bytes"`
 	CreateVideoParams
 }
 
 type CreateVideoParams struct {
-	Title       string    `json:"title"`
-	Description string    `json:"description"`
-	UserID      uuid.UUID `json:"user_id"`
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	UserID      uuid.UUID  `json:"user_id"`
+	OrgID       *uuid.UUID `json:"org_id"`
 }
 
 func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
@@ -33,7 +38,9 @@ func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
 		description,
 		thumbnail_url,
 		video_url,
-		user_id
+		user_id,
+		org_id,
+		size_bytes
 	FROM videos
 	WHERE user_id = ?
 	ORDER BY created_at DESC
@@ -45,6 +52,72 @@ func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
 	}
 	defer rows.Close()
 
+	return scanVideos(rows)
+}
+
+type ListVideosParams struct {
+	// UserID selects the user's personal library. It is ignored if OrgID is
+	// set, in which case the organization's library is listed instead.
+	UserID uuid.UUID
+	OrgID  *uuid.UUID
+	// Tags, if set, restricts the list to videos carrying every one of them.
+	Tags []string
+}
+
+func (c Client) ListVideos(params ListVideosParams) ([]Video, error) {
+	query := `
+	SELECT
+		id,
+		created_at,
+		updated_at,
+		title,
+		description,
+		thumbnail_url,
+		video_url,
+		user_id,
+		org_id,
+		size_bytes
+	FROM videos
+	`
+	args := []interface{}{}
+	if params.OrgID != nil {
+		query += `WHERE org_id = ?`
+		args = append(args, *params.OrgID)
+	} else {
+		query += `WHERE user_id = ? AND org_id IS NULL`
+		args = append(args, params.UserID)
+	}
+
+	if len(params.Tags) > 0 {
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(params.Tags)), ",")
+		query += `
+	AND id IN (
+		SELECT vt.video_id
+		FROM video_tags vt
+		JOIN tags t ON t.id = vt.tag_id
+		WHERE t.name IN (` + placeholders + `)
+		GROUP BY vt.video_id
+		HAVING COUNT(DISTINCT t.id) = ?
+	)`
+		for _, tag := range params.Tags {
+			args = append(args, tag)
+		}
+		args = append(args, len(params.Tags))
+	}
+	query += `
+	ORDER BY created_at DESC
+	`
+
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanVideos(rows)
+}
+
+func scanVideos(rows *sql.Rows) ([]Video, error) {
 	videos := []Video{}
 	for rows.Next() {
 		var video Video
@@ -57,13 +130,15 @@ func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
 			&video.ThumbnailURL,
 			&video.VideoURL,
 			&video.UserID,
+			&video.OrgID,
+			&video.SizeBytes,
 		); err != nil {
 			return nil, err
 		}
 		videos = append(videos, video)
 	}
 
-	return videos, nil
+	return videos, rows.Err()
 }
 
 func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
@@ -75,10 +150,14 @@ func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 		updated_at,
 		title,
 		description,
-		user_id
-	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?)
+		user_id,
+		org_id
+	) VALUES (?, ?, ?, ?, ?, ?, ?)
 	`
-	_, err := c.db.Exec(query, id, params.Title, params.Description, params.UserID)
+	// Stamped here rather than with CURRENT_TIMESTAMP so updated_at is stored
+	// the way it's passed back to UpdateVideoIfUnmodified.
+	now := time.Now().UTC()
+	_, err := c.db.Exec(query, id, now, now, params.Title, params.Description, params.UserID, params.OrgID)
 	if err != nil {
 		return Video{}, err
 	}
@@ -96,7 +175,9 @@ func (c Client) GetVideo(id uuid.UUID) (Video, error) {
 		description,
 		thumbnail_url,
 		video_url,
-		user_id
+		user_id,
+		org_id,
+		size_bytes
 	FROM videos
 	WHERE id = ?
 	`
@@ -110,7 +191,9 @@ func (c Client) GetVideo(id uuid.UUID) (Video, error) {
 		&video.Description,
 		&video.ThumbnailURL,
 		&video.VideoURL,
-		&video.UserID)
+		&video.UserID,
+		&video.OrgID,
+		&video.SizeBytes)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return Video{}, nil
@@ -121,35 +204,159 @@ func (c Client) GetVideo(id uuid.UUID) (Video, error) {
 	return video, nil
 }
 
-func (c Client) UpdateVideo(video Video) error {
+// UpdateVideoFile points the video at a newly uploaded file, leaving the rest
+// of it alone so edits made during the upload aren't lost.
+func (c Client) UpdateVideoFile(id uuid.UUID, videoURL string, sizeBytes int64) (Video, error) {
+	query := `
+	UPDATE videos
+	SET
+		updated_at = ?,
+		video_url = ?,
+		size_bytes = ?
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, time.Now().UTC(), videoURL, sizeBytes, id)
+	if err != nil {
+		return Video{}, err
+	}
+
+	return c.GetVideo(id)
+}
+
+// UpdateVideoThumbnail points the video at a newly uploaded thumbnail, leaving
+// the rest of it alone.
+func (c Client) UpdateVideoThumbnail(id uuid.UUID, thumbnailURL string) (Video, error) {
 	query := `
 	UPDATE videos
 	SET
+		updated_at = ?,
+		thumbnail_url = ?
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, time.Now().UTC(), thumbnailURL, id)
+	if err != nil {
+		return Video{}, err
+	}
+
+	return c.GetVideo(id)
+}
+
+// GetVideosWithoutSize returns the videos with a file but no size_bytes,
+// which is how videos uploaded before sizes were recorded look.
+func (c Client) GetVideosWithoutSize() ([]Video, error) {
+	query := `
+	SELECT
+		id,
+		created_at,
+		updated_at,
+		title,
+		description,
+		thumbnail_url,
+		video_url,
+		user_id,
+		org_id,
+		size_bytes
+	FROM videos
+	WHERE video_url IS NOT NULL AND video_url != '' AND size_bytes = 0
+	ORDER BY created_at
+	`
+
+	rows, err := c.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanVideos(rows)
+}
+
+// SetVideoSize records the size of the video's file, unless a new upload has
+// recorded one since. It leaves updated_at alone, as the video hasn't changed.
+func (c Client) SetVideoSize(id uuid.UUID, sizeBytes int64) error {
+	query := `
+	UPDATE videos
+	SET size_bytes = ?
+	WHERE id = ? AND size_bytes = 0
+	`
+	_, err := c.db.Exec(query, sizeBytes, id)
+	return err
+}
+
+// UpdateVideoIfUnmodified saves every field of video, but only if the stored
+// updated_at still matches video.UpdatedAt. It returns
+// ErrVideoModified if someone else changed or deleted the video in the
+// meantime.
+func (c Client) UpdateVideoIfUnmodified(video Video) (Video, error) {
+	query := `
+	UPDATE videos
+	SET
+		updated_at = ?,
 		title = ?,
 		description = ?,
 		thumbnail_url = ?,
 		video_url = ?,
-		user_id = ?
-	WHERE id = ?
+		size_bytes = ?,
+		user_id = ?,
+		org_id = ?
+	WHERE id = ? AND updated_at = ?
 	`
-
-	_, err := c.db.Exec(
+	result, err := c.db.Exec(
 		query,
+		time.Now().UTC(),
 		video.Title,
 		video.Description,
-		&video.ThumbnailURL,
-		&video.VideoURL,
+		video.ThumbnailURL,
+		video.VideoURL,
+		video.SizeBytes,
 		video.UserID,
+		video.OrgID,
 		video.ID,
+		video.UpdatedAt,
 	)
-	return err
+	if err != nil {
+		return Video{}, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return Video{}, err
+	}
+	if n == 0 {
+		return Video{}, ErrVideoModified
+	}
+
+	return c.GetVideo(video.ID)
 }
 
 func (c Client) DeleteVideo(id uuid.UUID) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := deleteVideo(tx, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// deleteVideo deletes a video along with its tags, playlist entries and
+// collaborators.
+func deleteVideo(tx *sql.Tx, id uuid.UUID) error {
+	if _, err := tx.Exec(`DELETE FROM video_tags WHERE video_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM playlist_videos WHERE video_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM video_collaborators WHERE video_id = ?`, id); err != nil {
+		return err
+	}
+
 	query := `
 	DELETE FROM videos
 	WHERE id = ?
 	`
-	_, err := c.db.Exec(query, id)
+	_, err := tx.Exec(query, id)
 	return err
 }
@@ -0,0 +1,79 @@
+package database
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestUpdateVideoIfUnmodified(t *testing.T) {
+	c := newTestClient(t)
+	user, err := c.CreateUser(CreateUserParams{Email: "alice@example.com", Password: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	created, err := c.CreateVideo(CreateVideoParams{Title: "first", UserID: user.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	edit := created
+	edit.Title = "second"
+	updated, err := c.UpdateVideoIfUnmodified(edit)
+	if err != nil {
+		t.Fatalf("updating a freshly created video: %v", err)
+	}
+	if updated.Title != "second" || updated.UpdatedAt.Equal(created.UpdatedAt) {
+		t.Errorf("updated video = %+v", updated)
+	}
+
+	// A client still holding the first version can't overwrite the update.
+	stale := created
+	stale.Title = "stale"
+	if _, err := c.UpdateVideoIfUnmodified(stale); !errors.Is(err, ErrVideoModified) {
+		t.Errorf("stale update: got %v, want ErrVideoModified", err)
+	}
+	if got, _ := c.GetVideo(created.ID); got.Title != "second" {
+		t.Errorf("stale update changed the title to %q", got.Title)
+	}
+
+	updated.Title = "third"
+	if _, err := c.UpdateVideoIfUnmodified(updated); err != nil {
+		t.Errorf("updating the latest version: %v", err)
+	}
+
+	deleted := updated
+	if err := c.DeleteVideo(deleted.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.UpdateVideoIfUnmodified(deleted); !errors.Is(err, ErrVideoModified) {
+		t.Errorf("updating a deleted video: got %v, want ErrVideoModified", err)
+	}
+}
+
+func TestUpdateVideoIfUnmodifiedMigratesOldTimestamps(t *testing.T) {
+	c := newTestClient(t)
+	user, err := c.CreateUser(CreateUserParams{Email: "alice@example.com", Password: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	video, err := c.CreateVideo(CreateVideoParams{Title: "first", UserID: user.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// How videos were stamped before.
+	if _, err := c.db.Exec(`UPDATE videos SET updated_at = CURRENT_TIMESTAMP WHERE id = ?`, video.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.autoMigrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	video, err = c.GetVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	video.Title = "second"
+	if _, err := c.UpdateVideoIfUnmodified(video); err != nil {
+		t.Errorf("updating a video created before the migration: %v", err)
+	}
+}
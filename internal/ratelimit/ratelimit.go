@@ -0,0 +1,116 @@
+// Package ratelimit limits how often clients can make requests, using token
+// buckets.
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// Limit allows Burst requests at once, and Burst more every Period after
+// that. Each client has a bucket of up to Burst tokens that refills steadily
+// over Period, and every request takes a token.
+type Limit struct {
+	Burst  int
+	Period time.Duration
+}
+
+// Result is the outcome of taking a token.
+type Result struct {
+	Allowed bool
+	// Remaining is how many more requests would be allowed right now.
+	Remaining int
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is how long until the next request will be allowed, zero
+	// if one would be now.
+	RetryAfter time.Duration
+}
+
+// State is a bucket between requests.
+type State struct {
+	Tokens    float64
+	UpdatedAt time.Time
+}
+
+// Take takes a token from the bucket in state s at now, if there is one. It
+// returns the bucket's new state along with the result. A zero State is a
+// full bucket.
+func (l Limit) Take(s State, now time.Time) (State, Result) {
+	burst := float64(l.Burst)
+	perToken := l.Period / time.Duration(l.Burst)
+
+	tokens := burst
+	if !s.UpdatedAt.IsZero() {
+		elapsed := max(now.Sub(s.UpdatedAt), 0)
+		tokens = min(burst, s.Tokens+float64(elapsed)/float64(perToken))
+	}
+
+	result := Result{}
+	if tokens >= 1 {
+		tokens--
+		result.Allowed = true
+	}
+	result.Remaining = int(math.Floor(tokens))
+	result.Reset = time.Duration((burst - tokens) * float64(perToken))
+	if tokens < 1 {
+		result.RetryAfter = time.Duration((1 - tokens) * float64(perToken))
+	}
+	return State{Tokens: tokens, UpdatedAt: now}, result
+}
+
+// Store keeps a bucket for each client. MemoryStore only knows about the
+// requests its own server has seen, so servers behind a load balancer need a
+// Store backed by something they share, like Redis, which can use
+// Limit.Take on its stored State. Implementations must be safe for
+// concurrent use.
+type Store interface {
+	// Take takes a token from key's bucket under limit.
+	Take(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+// sweepInterval is how often MemoryStore forgets buckets that have filled
+// back up, which behave the same as ones it has never seen.
+const sweepInterval = time.Minute
+
+// MemoryStore is a Store for a single server.
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]memoryBucket
+	lastSweep time.Time
+	// now is overridden by tests.
+	now func() time.Time
+}
+
+type memoryBucket struct {
+	State
+	limit Limit
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets: map[string]memoryBucket{},
+		now:     time.Now,
+	}
+}
+
+func (s *MemoryStore) Take(ctx context.Context, key string, limit Limit) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if now.Sub(s.lastSweep) >= sweepInterval {
+		for k, b := range s.buckets {
+			if now.Sub(b.UpdatedAt) >= b.limit.Period {
+				delete(s.buckets, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	state, result := limit.Take(s.buckets[key].State, now)
+	s.buckets[key] = memoryBucket{State: state, limit: limit}
+	return result, nil
+}
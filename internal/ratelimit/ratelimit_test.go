@@ -0,0 +1,51 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreTake(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	now := start
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+	limit := Limit{Burst: 3, Period: 3 * time.Second}
+
+	tests := []struct {
+		at         time.Duration
+		key        string
+		allowed    bool
+		remaining  int
+		retryAfter time.Duration
+	}{
+		{0, "a", true, 2, 0},
+		{0, "a", true, 1, 0},
+		{0, "a", true, 0, time.Second},
+		{0, "a", false, 0, time.Second},
+		// Buckets are per key.
+		{0, "b", true, 2, 0},
+		{500 * time.Millisecond, "a", false, 0, 500 * time.Millisecond},
+		{time.Second, "a", true, 0, time.Second},
+		// A bucket doesn't fill past the burst.
+		{time.Hour, "a", true, 2, 0},
+	}
+	for i, tt := range tests {
+		now = start.Add(tt.at)
+		got, err := store.Take(context.Background(), tt.key, limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Allowed != tt.allowed || got.Remaining != tt.remaining || got.RetryAfter != tt.retryAfter {
+			t.Errorf("request %d: got %+v, want allowed %v, remaining %d, retry after %v", i, got, tt.allowed, tt.remaining, tt.retryAfter)
+		}
+	}
+
+	// Full buckets are forgotten.
+	now = start.Add(2 * time.Hour)
+	store.Take(context.Background(), "c", limit)
+	if len(store.buckets) != 1 {
+		t.Errorf("%d buckets after sweeping, want 1", len(store.buckets))
+	}
+}
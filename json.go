@@ -2,16 +2,17 @@ package main
 
 import (
 	"encoding/json"
-	"log"
 	"net/http"
 )
 
+// respondWithError responds with msg as a JSON error. err, if any, is logged
+// along with the request ID but not shown to the client.
 func respondWithError(w http.ResponseWriter, code int, msg string, err error) {
-	if err != nil {
-		log.Println(err)
-	}
+	logger := responseLogger(w)
 	if code > 499 {
-		log.Printf("Responding with 5XX error: %s", msg)
+		logger.Error("Responding with 5XX error", "status", code, "message", msg, "error", err)
+	} else if err != nil {
+		logger.Info("Responding with error", "status", code, "message", msg, "error", err)
 	}
 	type errorResponse struct {
 		Error string `json:"error"`
@@ -25,7 +26,7 @@ func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	dat, err := json.Marshal(payload)
 	if err != nil {
-		log.Printf("Error marshalling JSON: %s", err)
+		responseLogger(w).Error("Couldn't marshal JSON response", "error", err)
 		w.WriteHeader(500)
 		return
 	}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maxRequestIDLength is the longest X-Request-ID accepted from clients,
+// longer ones are replaced.
+const maxRequestIDLength = 128
+
+type logContextKey struct{}
+
+// requestLog is what logRequests knows about a request while it's handled.
+type requestLog struct {
+	logger *slog.Logger
+	userID *uuid.UUID
+}
+
+type loggingSettings struct {
+	// Level is debug, info, warn or error.
+	Level string `yaml:"level" env:"LOG_LEVEL" validate:"log_level"`
+}
+
+func parseLogLevel(value string) (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(value))
+	return level, err
+}
+
+// newLogger returns the JSON logger for the server.
+func newLogger(out io.Writer, settings loggingSettings) (*slog.Logger, error) {
+	level, err := parseLogLevel(settings.Level)
+	if err != nil {
+		return nil, fmt.Errorf("invalid log level %q", settings.Level)
+	}
+	return slog.New(slog.NewJSONHandler(out, &slog.HandlerOptions{Level: level})), nil
+}
+
+// logRequests wraps next so that every request gets a request ID, taken from
+// the X-Request-ID header if the client sent one, which is echoed back and
+// added to everything logged for the request, as is its trace ID if it's
+// traced. Once the response is written it's logged with its route, status,
+// latency, size and user.
+func (cfg *apiConfig) logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		requestID := r.Header.Get("X-Request-ID")
+		if !validRequestID(requestID) {
+			requestID = uuid.NewString()
+		}
+		w.Header().Set("X-Request-ID", requestID)
+
+		entry := &requestLog{logger: cfg.logger.With("request_id", requestID)}
+		writer := &accessLogWriter{ResponseWriter: w, status: http.StatusOK, entry: entry}
+		r = r.WithContext(context.WithValue(r.Context(), logContextKey{}, entry))
+		next.ServeHTTP(writer, r)
+
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			// The mux fills in the route on r as it routes it.
+			slog.String("route", r.Pattern),
+			slog.Int("status", writer.status),
+			slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.Int64("bytes", writer.bytes),
+			slog.String("ip", clientIP(r)),
+		}
+		if entry.userID != nil {
+			attrs = append(attrs, slog.String("user_id", entry.userID.String()))
+		}
+		entry.logger.LogAttrs(r.Context(), slog.LevelInfo, "request", attrs...)
+	})
+}
+
+// validRequestID reports whether a client's request ID is safe to log.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// requestLogger returns the logger for things to do with r, which includes
+// its request ID.
+func requestLogger(r *http.Request) *slog.Logger {
+	if entry, ok := r.Context().Value(logContextKey{}).(*requestLog); ok {
+		return entry.logger
+	}
+	return slog.Default()
+}
+
+// responseLogger is requestLogger for code that only has the response, like
+// respondWithError.
+func responseLogger(w http.ResponseWriter) *slog.Logger {
+	for {
+		if writer, ok := w.(*accessLogWriter); ok {
+			return writer.entry.logger
+		}
+		unwrapper, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return slog.Default()
+		}
+		w = unwrapper.Unwrap()
+	}
+}
+
+// logTrace adds the trace a request is part of to everything logged for it
+// from now on, including its access log.
+func logTrace(r *http.Request, sc trace.SpanContext) {
+	if !sc.IsValid() {
+		return
+	}
+	if entry, ok := r.Context().Value(logContextKey{}).(*requestLog); ok {
+		entry.logger = entry.logger.With("trace_id", sc.TraceID().String(), "span_id", sc.SpanID().String())
+	}
+}
+
+// logUser records who made a request for its access log.
+func logUser(r *http.Request, userID uuid.UUID) {
+	if entry, ok := r.Context().Value(logContextKey{}).(*requestLog); ok {
+		entry.userID = &userID
+	}
+}
+
+// accessLogWriter remembers the status code and size of the response written
+// through it.
+type accessLogWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+	entry  *requestLog
+}
+
+func (w *accessLogWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *accessLogWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *accessLogWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
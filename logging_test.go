@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLogRequests(t *testing.T) {
+	var out bytes.Buffer
+	logger, err := newLogger(&out, loggingSettings{Level: "info"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := apiConfig{logger: logger}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/videos/{videoID}", func(w http.ResponseWriter, r *http.Request) {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", errors.New("database is locked"))
+	})
+	handler := cfg.logRequests(mux)
+
+	tests := []struct {
+		name      string
+		requestID string
+		wantID    string
+	}{
+		{"propagated", "abc-123", "abc-123"},
+		// An empty wantID means a new ID should be generated.
+		{"generated", "", ""},
+		{"replaced", "bad id\n", ""},
+	}
+	for _, tt := range tests {
+		out.Reset()
+		req := httptest.NewRequest("GET", "/api/videos/42", nil)
+		if tt.requestID != "" {
+			req.Header.Set("X-Request-ID", tt.requestID)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		id := rec.Header().Get("X-Request-ID")
+		if tt.wantID != "" && id != tt.wantID {
+			t.Errorf("%s: X-Request-ID = %q, want %q", tt.name, id, tt.wantID)
+		}
+		if tt.wantID == "" && (id == "" || id == tt.requestID) {
+			t.Errorf("%s: X-Request-ID = %q, want a new one", tt.name, id)
+		}
+
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		if len(lines) != 2 {
+			t.Fatalf("%s: got %d log lines, want the error and the request", tt.name, len(lines))
+		}
+		for _, line := range lines {
+			var entry map[string]interface{}
+			if err := json.Unmarshal([]byte(line), &entry); err != nil {
+				t.Fatalf("%s: log line %q isn't JSON: %v", tt.name, line, err)
+			}
+			if entry["request_id"] != id {
+				t.Errorf("%s: log line %q doesn't have request ID %q", tt.name, line, id)
+			}
+		}
+		var access map[string]interface{}
+		json.Unmarshal([]byte(lines[1]), &access)
+		if access["route"] != "GET /api/videos/{videoID}" || access["status"] != float64(500) || access["bytes"] == float64(0) {
+			t.Errorf("%s: access log = %s", tt.name, lines[1])
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// loginThrottlePolicy decides how long logins for an account or from an IP
+// address are held off after failing. The first few failures are free, after
+// that each one doubles the wait, and enough of them lock the account or
+// address out entirely.
+type loginThrottlePolicy struct {
+	// FreeFailures is how many failures are allowed before backing off.
+	FreeFailures int `yaml:"free_failures" env:"LOGIN_FREE_FAILURES" validate:"positive"`
+	// BaseDelay is the wait after the first failure past FreeFailures.
+	BaseDelay time.Duration `yaml:"backoff_base" env:"LOGIN_BACKOFF_BASE" validate:"positive"`
+	// AccountLockoutFailures and IPLockoutFailures are how many failures lock
+	// an account or IP address out for LockoutDuration. IP addresses get more
+	// leeway since many users can share one.
+	AccountLockoutFailures int           `yaml:"account_lockout_failures" env:"LOGIN_ACCOUNT_LOCKOUT_FAILURES" validate:"positive"`
+	IPLockoutFailures      int           `yaml:"ip_lockout_failures" env:"LOGIN_IP_LOCKOUT_FAILURES" validate:"positive"`
+	LockoutDuration        time.Duration `yaml:"lockout_duration" env:"LOGIN_LOCKOUT_DURATION" validate:"positive"`
+	// Window is how long without failures it takes for the count to reset.
+	Window time.Duration `yaml:"failure_window" env:"LOGIN_FAILURE_WINDOW" validate:"positive"`
+}
+
+var defaultLoginThrottlePolicy = loginThrottlePolicy{
+	FreeFailures:           3,
+	BaseDelay:              time.Second,
+	AccountLockoutFailures: 10,
+	IPLockoutFailures:      50,
+	LockoutDuration:        15 * time.Minute,
+	Window:                 time.Hour,
+}
+
+// blockedUntil returns when the next login attempt is allowed given the
+// failures recorded so far, with lockoutFailures being the account or IP
+// threshold.
+func (p loginThrottlePolicy) blockedUntil(throttle database.LoginThrottle, lockoutFailures int) time.Time {
+	if throttle.Failures < p.FreeFailures {
+		return time.Time{}
+	}
+	if throttle.Failures >= lockoutFailures {
+		return throttle.LastFailureAt.Add(p.LockoutDuration)
+	}
+
+	delay := p.BaseDelay
+	for i := p.FreeFailures; i < throttle.Failures && delay < p.LockoutDuration; i++ {
+		delay *= 2
+	}
+	return throttle.LastFailureAt.Add(min(delay, p.LockoutDuration))
+}
+
+func loginThrottleKeys(email, ip string) (account, address string) {
+	return "account:" + strings.ToLower(strings.TrimSpace(email)), "ip:" + ip
+}
+
+// loginRetryAfter returns how long the client has to wait before trying to log
+// in to the account again, or zero if it may try now.
+func (cfg *apiConfig) loginRetryAfter(email, ip string) (time.Duration, error) {
+	accountKey, ipKey := loginThrottleKeys(email, ip)
+	account, err := cfg.db.GetLoginThrottle(accountKey)
+	if err != nil {
+		return 0, err
+	}
+	address, err := cfg.db.GetLoginThrottle(ipKey)
+	if err != nil {
+		return 0, err
+	}
+
+	until := cfg.loginThrottle.blockedUntil(account, cfg.loginThrottle.AccountLockoutFailures)
+	if ipUntil := cfg.loginThrottle.blockedUntil(address, cfg.loginThrottle.IPLockoutFailures); ipUntil.After(until) {
+		until = ipUntil
+	}
+	return max(time.Until(until), 0), nil
+}
+
+// recordLoginFailure counts a failed login against both the account and the
+// IP address and keeps an audit record of it.
+func (cfg *apiConfig) recordLoginFailure(params database.CreateLoginFailureParams) error {
+	err := cfg.db.CreateLoginFailure(params)
+	if err != nil {
+		return err
+	}
+	if params.Reason == database.LoginFailureThrottled {
+		// Attempts we turned away don't extend the wait, or an attacker could
+		// keep a user locked out forever.
+		return nil
+	}
+
+	accountKey, ipKey := loginThrottleKeys(params.Email, params.IP)
+	if _, err := cfg.db.RecordLoginFailure(accountKey, cfg.loginThrottle.Window); err != nil {
+		return err
+	}
+	_, err = cfg.db.RecordLoginFailure(ipKey, cfg.loginThrottle.Window)
+	return err
+}
+
+// recordLoginSuccess clears the account's failures. The IP address's are left
+// to expire, otherwise logging in to one account would reset the guessing
+// budget for every other account.
+func (cfg *apiConfig) recordLoginSuccess(email string) error {
+	accountKey, _ := loginThrottleKeys(email, "")
+	return cfg.db.ClearLoginThrottle(accountKey)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestLoginThrottleBlockedUntil(t *testing.T) {
+	policy := loginThrottlePolicy{
+		FreeFailures:           3,
+		BaseDelay:              time.Second,
+		AccountLockoutFailures: 10,
+		LockoutDuration:        time.Minute,
+	}
+	last := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, -1},
+		{2, -1},
+		{3, time.Second},
+		{4, 2 * time.Second},
+		{6, 8 * time.Second},
+		// Backoff is capped at the lockout duration.
+		{9, time.Minute},
+		{10, time.Minute},
+		{100, time.Minute},
+	}
+	for _, tt := range tests {
+		got := policy.blockedUntil(database.LoginThrottle{Failures: tt.failures, LastFailureAt: last}, policy.AccountLockoutFailures)
+		if tt.want < 0 {
+			if !got.IsZero() {
+				t.Errorf("%d failures: blocked until %v, want not blocked", tt.failures, got)
+			}
+			continue
+		}
+		if got.Sub(last) != tt.want {
+			t.Errorf("%d failures: blocked for %v, want %v", tt.failures, got.Sub(last), tt.want)
+		}
+	}
+}
@@ -2,22 +2,46 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mailer"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/oidc"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ratelimit"
 
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
 
-	"github.com/aws/aws-sdk-go-v2/config"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 )
 
 type apiConfig struct {
 	db               database.Client
-	jwtSecret        string
+	logger           *slog.Logger
+	jwtKeys          *auth.KeySet
+	oidc             *oidc.Provider
+	mailer           mailer.Mailer
+	tokens           tokenSettings
+	uploads          uploadSettings
+	loginThrottle    loginThrottlePolicy
+	defaultQuota     quota
+	rateLimits       map[string]ratelimit.Limit
+	rateLimiter      ratelimit.Store
+	metrics          *serverMetrics
+	metricsToken     string
+	jobs             *jobTracker
+	readiness        *readinessCache
 	platform         string
 	filepathRoot     string
 	assetsRoot       string
@@ -38,109 +62,282 @@ type apiConfig struct {
 func main() {
 	godotenv.Load(".env")
 
-	pathToDB := os.Getenv("DB_PATH")
-	if pathToDB == "" {
-		log.Fatal("DB_URL must be set")
+	// Arguments starting with a dash are settings for the server, like
+	// --config or --server.port, rather than a command.
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		var err error
+		switch os.Args[1] {
+		case "config":
+			err = runConfig(os.Args[2:])
+		case "rotate-keys":
+			err = runRotateKeys(os.Args[2:])
+		case "set-role":
+			err = runSetRole(os.Args[2:])
+		case "backfill-sizes":
+			err = runBackfillSizes(os.Args[2:])
+		default:
+			log.Fatalf("Unknown command %q", os.Args[1])
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
 
-	db, err := database.NewClient(pathToDB)
-	if err != nil {
-		log.Fatalf("Couldn't connect to database: %v", err)
+	settings, err := loadConfig("tubely", os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
 	}
-
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		log.Fatal("JWT_SECRET environment variable is not set")
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	platform := os.Getenv("PLATFORM")
-	if platform == "" {
-		log.Fatal("PLATFORM environment variable is not set")
+	logger, err := newLogger(os.Stdout, settings.Logging)
+	if err != nil {
+		log.Fatal(err)
 	}
+	// Anything still using the log package goes through the logger too.
+	slog.SetDefault(logger)
 
-	filepathRoot := os.Getenv("FILEPATH_ROOT")
-	if filepathRoot == "" {
-		log.Fatal("FILEPATH_ROOT environment variable is not set")
+	db, err := database.NewClient(settings.Database.Path)
+	if err != nil {
+		log.Fatalf("Couldn't connect to database: %v", err)
 	}
 
-	assetsRoot := os.Getenv("ASSETS_ROOT")
-	if assetsRoot == "" {
-		log.Fatal("ASSETS_ROOT environment variable is not set")
+	jwtKeysDir := settings.Auth.JWTKeysDir
+	jwtKeys, err := auth.LoadKeySet(jwtKeysDir, settings.Auth.JWTSecret)
+	if errors.Is(err, auth.ErrNoSigningKey) {
+		kid, genErr := auth.GenerateKey(jwtKeysDir, auth.AlgEdDSA, time.Now())
+		if genErr != nil {
+			log.Fatalf("Couldn't generate JWT signing key: %v", genErr)
+		}
+		logger.Info("Generated JWT signing key", "kid", kid, "dir", jwtKeysDir)
+		jwtKeys, err = auth.LoadKeySet(jwtKeysDir, settings.Auth.JWTSecret)
 	}
-
-	s3Bucket := os.Getenv("S3_BUCKET")
-	if s3Bucket == "" {
-		log.Fatal("S3_BUCKET environment variable is not set")
+	if err != nil {
+		log.Fatalf("Couldn't load JWT keys: %v", err)
 	}
 
-	s3Region := os.Getenv("S3_REGION")
-	if s3Region == "" {
-		log.Fatal("S3_REGION environment variable is not set")
+	var oidcProvider *oidc.Provider
+	if settings.OIDC.Issuer != "" {
+		oidcProvider = oidc.NewProvider(oidc.Config{
+			Issuer:       settings.OIDC.Issuer,
+			ClientID:     settings.OIDC.ClientID,
+			ClientSecret: settings.OIDC.ClientSecret,
+			RedirectURL:  settings.OIDC.RedirectURL,
+			HTTPClient:   &http.Client{Timeout: 10 * time.Second},
+		})
 	}
 
-	s3CfDistribution := os.Getenv("S3_CF_DISTRO")
-	if s3CfDistribution == "" {
-		log.Fatal("S3_CF_DISTRO environment variable is not set")
+	var mail mailer.Mailer
+	switch settings.Mail.Mailer {
+	case "smtp":
+		mail = mailer.SMTPMailer{
+			Addr:     settings.Mail.SMTPAddr,
+			Username: settings.Mail.SMTPUsername,
+			Password: settings.Mail.SMTPPassword,
+			From:     settings.Mail.From,
+		}
+	case "file":
+		mail = &mailer.FileMailer{Path: settings.Mail.File, From: settings.Mail.From}
+	case "log":
+		mail = &mailer.FileMailer{From: settings.Mail.From}
 	}
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		log.Fatal("PORT environment variable is not set")
+	tracerProvider, err := newTracerProvider(settings.Tracing, logger)
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	s3Cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(s3Region))
+	s3Cfg, err := awsconfig.LoadDefaultConfig(context.TODO(), awsconfig.WithRegion(settings.S3.Region))
 	if err != nil {
 		log.Fatalf("Couldn't create S3 config: %v", err)
 	}
+	s3Cfg.HTTPClient = tracedAWSClient{next: s3Cfg.HTTPClient}
 
 	s3Client := s3.NewFromConfig(s3Cfg)
 
 	cfg := apiConfig{
 		db:               db,
-		jwtSecret:        jwtSecret,
-		platform:         platform,
-		filepathRoot:     filepathRoot,
-		assetsRoot:       assetsRoot,
-		s3Bucket:         s3Bucket,
-		s3Region:         s3Region,
-		s3CfDistribution: s3CfDistribution,
-		port:             port,
+		logger:           logger,
+		jwtKeys:          jwtKeys,
+		oidc:             oidcProvider,
+		mailer:           mail,
+		tokens:           settings.Tokens,
+		uploads:          settings.Uploads,
+		loginThrottle:    settings.LoginThrottle,
+		defaultQuota:     settings.Quota.quota(),
+		rateLimits:       settings.RateLimits.limits(),
+		rateLimiter:      ratelimit.NewMemoryStore(),
+		metrics:          newServerMetrics(db),
+		metricsToken:     settings.Metrics.Token,
+		jobs:             newJobTracker(),
+		platform:         settings.Server.Platform,
+		filepathRoot:     settings.Server.FilepathRoot,
+		assetsRoot:       settings.Server.AssetsRoot,
+		s3Bucket:         settings.S3.Bucket,
+		s3Region:         settings.S3.Region,
+		s3CfDistribution: settings.S3.CFDistribution,
+		port:             settings.Server.Port,
 		s3Client:         s3Client,
 	}
 
+	cfg.readiness = newReadinessCache(cfg.readinessChecks())
+
 	err = cfg.ensureAssetsDir()
 	if err != nil {
 		log.Fatalf("Couldn't create assets directory: %v", err)
 	}
 
 	mux := http.NewServeMux()
-	appHandler := http.StripPrefix("/app", http.FileServer(http.Dir(filepathRoot)))
+	appHandler := http.StripPrefix("/app", http.FileServer(http.Dir(cfg.filepathRoot)))
 	mux.Handle("/app/", appHandler)
 
-	assetsHandler := http.StripPrefix("/assets", http.FileServer(http.Dir(assetsRoot)))
+	assetsHandler := http.StripPrefix("/assets", http.FileServer(http.Dir(cfg.assetsRoot)))
 	mux.Handle("/assets/", noCacheMiddleware(assetsHandler))
 
-	mux.HandleFunc("POST /api/login", cfg.handlerLogin)
-	mux.HandleFunc("POST /api/refresh", cfg.handlerRefresh)
-	mux.HandleFunc("POST /api/revoke", cfg.handlerRevoke)
+	mux.HandleFunc("GET /.well-known/jwks.json", cfg.handlerJWKS)
+	mux.HandleFunc("GET /metrics", cfg.handlerMetrics)
+	mux.HandleFunc("GET /healthz", cfg.handlerHealthz)
+	mux.HandleFunc("GET /readyz", cfg.handlerReadyz)
+
+	mux.Handle("POST /api/login", cfg.audited("auth.login", cfg.rateLimited(rateLimitAuth, http.HandlerFunc(cfg.handlerLogin))))
+	mux.Handle("POST /api/login/2fa", cfg.audited("auth.login_2fa", cfg.rateLimited(rateLimitAuth, http.HandlerFunc(cfg.handlerLoginTwoFactor))))
+	mux.HandleFunc("GET /api/oidc/login", cfg.handlerOIDCLogin)
+	mux.Handle("POST /api/oidc/link", cfg.audited("user.identity_link", cfg.requireAuth(auth.ScopeAccount, cfg.handlerOIDCLink)))
+	mux.Handle("GET /api/oidc/callback", cfg.audited("auth.oidc_login", http.HandlerFunc(cfg.handlerOIDCCallback)))
+	mux.Handle("POST /api/refresh", cfg.audited("auth.refresh", http.HandlerFunc(cfg.handlerRefresh)))
+	mux.Handle("POST /api/revoke", cfg.audited("auth.revoke", http.HandlerFunc(cfg.handlerRevoke)))
+
+	mux.Handle("GET /api/sessions", cfg.requireAuth(auth.ScopeAccount, cfg.handlerSessionsList))
+	mux.Handle("DELETE /api/sessions", cfg.audited("session.revoke_all", cfg.requireAuth(auth.ScopeAccount, cfg.handlerSessionsRevokeAll)))
+	mux.Handle("DELETE /api/sessions/{sessionID}", cfg.audited("session.revoke", cfg.requireAuth(auth.ScopeAccount, cfg.handlerSessionRevoke)))
+
+	mux.Handle("POST /api/api_keys", cfg.audited("api_key.create", cfg.requireAuth(auth.ScopeAccount, cfg.handlerAPIKeyCreate)))
+	mux.Handle("GET /api/api_keys", cfg.requireAuth(auth.ScopeAccount, cfg.handlerAPIKeysList))
+	mux.Handle("DELETE /api/api_keys/{keyID}", cfg.audited("api_key.revoke", cfg.requireAuth(auth.ScopeAccount, cfg.handlerAPIKeyRevoke)))
 
-	mux.HandleFunc("POST /api/users", cfg.handlerUsersCreate)
+	mux.Handle("POST /api/users", cfg.audited("user.create", cfg.rateLimited(rateLimitSignup, http.HandlerFunc(cfg.handlerUsersCreate))))
+	mux.Handle("GET /api/users/me", cfg.requireAuth(auth.ScopeAccount, cfg.handlerUserGet))
+	mux.Handle("PATCH /api/users/me", cfg.audited("user.update", cfg.requireAuth(auth.ScopeAccount, cfg.handlerUserUpdate)))
+	mux.Handle("DELETE /api/users/me", cfg.audited("user.delete", cfg.requireAuth(auth.ScopeAccount, cfg.handlerUserDelete)))
+	mux.Handle("POST /api/users/verify_email", cfg.audited("user.verify_email", cfg.rateLimited(rateLimitAuth, http.HandlerFunc(cfg.handlerVerifyEmail))))
+	mux.Handle("POST /api/users/verify_email/resend", cfg.audited("user.verify_email_resend", cfg.rateLimited(rateLimitAuth, cfg.requireAuth(auth.ScopeAccount, cfg.handlerVerifyEmailResend))))
+	mux.Handle("POST /api/password_reset/request", cfg.audited("user.password_reset_request", cfg.rateLimited(rateLimitAuth, http.HandlerFunc(cfg.handlerPasswordResetRequest))))
+	mux.Handle("POST /api/password_reset/confirm", cfg.audited("user.password_reset", cfg.rateLimited(rateLimitAuth, http.HandlerFunc(cfg.handlerPasswordResetConfirm))))
+	mux.Handle("POST /api/users/me/totp", cfg.audited("user.totp_enroll", cfg.requireAuth(auth.ScopeAccount, cfg.handlerTOTPEnroll)))
+	mux.Handle("POST /api/users/me/totp/confirm", cfg.audited("user.totp_confirm", cfg.requireAuth(auth.ScopeAccount, cfg.handlerTOTPConfirm)))
+	mux.Handle("DELETE /api/users/me/totp", cfg.audited("user.totp_delete", cfg.requireAuth(auth.ScopeAccount, cfg.handlerTOTPDelete)))
+	mux.Handle("POST /api/users/me/totp/recovery_codes", cfg.audited("user.recovery_codes_regenerate", cfg.requireAuth(auth.ScopeAccount, cfg.handlerRecoveryCodesRegenerate)))
 
-	mux.HandleFunc("POST /api/videos", cfg.handlerVideoMetaCreate)
-	mux.HandleFunc("POST /api/thumbnail_upload/{videoID}", cfg.handlerUploadThumbnail)
-	mux.HandleFunc("POST /api/video_upload/{videoID}", cfg.handlerUploadVideo)
-	mux.HandleFunc("GET /api/videos", cfg.handlerVideosRetrieve)
-	mux.HandleFunc("GET /api/videos/{videoID}", cfg.handlerVideoGet)
+	mux.Handle("POST /api/videos", cfg.audited("video.create", cfg.requireAuth(auth.ScopeVideosWrite, cfg.handlerVideoMetaCreate)))
+	mux.Handle("POST /api/thumbnail_upload/{videoID}", cfg.audited("video.thumbnail_upload", cfg.uploadJob(cfg.rateLimited(rateLimitUpload, cfg.requireAuth(auth.ScopeVideosWrite, requireVerifiedEmail(cfg.handlerUploadThumbnail))))))
+	mux.Handle("POST /api/video_upload/{videoID}", cfg.audited("video.upload", cfg.uploadJob(cfg.rateLimited(rateLimitUpload, cfg.requireAuth(auth.ScopeVideosWrite, requireVerifiedEmail(cfg.handlerUploadVideo))))))
+	mux.Handle("GET /api/videos", cfg.requireAuth(auth.ScopeVideosRead, cfg.handlerVideosRetrieve))
+	mux.Handle("GET /api/videos/{videoID}", cfg.requireAuth(auth.ScopeVideosRead, cfg.handlerVideoGet))
+	mux.Handle("PATCH /api/videos/{videoID}", cfg.audited("video.update", cfg.requireAuth(auth.ScopeVideosWrite, cfg.handlerVideoMetaUpdate)))
 	// mux.HandleFunc("GET /api/thumbnails/{videoID}", cfg.handlerThumbnailGet)
-	mux.HandleFunc("DELETE /api/videos/{videoID}", cfg.handlerVideoMetaDelete)
+	mux.Handle("DELETE /api/videos/{videoID}", cfg.audited("video.delete", cfg.requireAuth(auth.ScopeVideosWrite, cfg.handlerVideoMetaDelete)))
+	mux.Handle("PUT /api/videos/{videoID}/tags", cfg.audited("video.tags_set", cfg.requireAuth(auth.ScopeVideosWrite, cfg.handlerVideoTagsSet)))
+	mux.Handle("DELETE /api/videos/{videoID}/tags/{tag}", cfg.audited("video.tag_delete", cfg.requireAuth(auth.ScopeVideosWrite, cfg.handlerVideoTagDelete)))
+	mux.Handle("GET /api/usage", cfg.requireAuth(auth.ScopeVideosRead, cfg.handlerUsage))
+	mux.Handle("GET /api/tags", cfg.requireAuth(auth.ScopeVideosRead, cfg.handlerTagsList))
 
-	mux.HandleFunc("POST /admin/reset", cfg.handlerReset)
+	mux.Handle("POST /api/orgs", cfg.requireAuth(auth.ScopeAccount, cfg.handlerOrgCreate))
+	mux.Handle("GET /api/orgs", cfg.requireAuth(auth.ScopeVideosRead, cfg.handlerOrgsRetrieve))
+	mux.Handle("GET /api/orgs/{orgID}", cfg.requireAuth(auth.ScopeVideosRead, cfg.handlerOrgGet))
+	mux.Handle("PATCH /api/orgs/{orgID}", cfg.requireAuth(auth.ScopeAccount, cfg.handlerOrgUpdate))
+	mux.Handle("POST /api/orgs/{orgID}/members", cfg.requireAuth(auth.ScopeAccount, cfg.handlerOrgMemberAdd))
+	mux.Handle("DELETE /api/orgs/{orgID}/members/{userID}", cfg.requireAuth(auth.ScopeAccount, cfg.handlerOrgMemberRemove))
+
+	mux.Handle("GET /api/videos/shared", cfg.requireAuth(auth.ScopeVideosRead, cfg.handlerVideosShared))
+	mux.Handle("GET /api/videos/{videoID}/collaborators", cfg.requireAuth(auth.ScopeVideosRead, cfg.handlerVideoCollaboratorsList))
+	mux.Handle("POST /api/videos/{videoID}/collaborators", cfg.audited("video.collaborator_invite", cfg.requireAuth(auth.ScopeVideosWrite, cfg.handlerVideoCollaboratorInvite)))
+	mux.Handle("DELETE /api/videos/{videoID}/collaborators/{userID}", cfg.audited("video.collaborator_revoke", cfg.requireAuth(auth.ScopeVideosWrite, cfg.handlerVideoCollaboratorRevoke)))
+
+	mux.Handle("POST /api/playlists", cfg.requireAuth(auth.ScopeVideosWrite, cfg.handlerPlaylistCreate))
+	mux.Handle("GET /api/playlists", cfg.requireAuth(auth.ScopeVideosRead, cfg.handlerPlaylistsRetrieve))
+	mux.HandleFunc("GET /api/playlists/{playlistID}", cfg.handlerPlaylistGet)
+	mux.Handle("PATCH /api/playlists/{playlistID}", cfg.requireAuth(auth.ScopeVideosWrite, cfg.handlerPlaylistUpdate))
+	mux.Handle("DELETE /api/playlists/{playlistID}", cfg.requireAuth(auth.ScopeVideosWrite, cfg.handlerPlaylistDelete))
+	mux.Handle("POST /api/playlists/{playlistID}/videos", cfg.requireAuth(auth.ScopeVideosWrite, cfg.handlerPlaylistVideoAdd))
+	mux.Handle("PUT /api/playlists/{playlistID}/videos", cfg.requireAuth(auth.ScopeVideosWrite, cfg.handlerPlaylistReorder))
+	mux.Handle("POST /api/playlists/{playlistID}/videos/{videoID}/move", cfg.requireAuth(auth.ScopeVideosWrite, cfg.handlerPlaylistVideoMove))
+	mux.Handle("DELETE /api/playlists/{playlistID}/videos/{videoID}", cfg.requireAuth(auth.ScopeVideosWrite, cfg.handlerPlaylistVideoRemove))
+
+	mux.Handle("POST /admin/reset", cfg.audited("admin.reset", http.HandlerFunc(cfg.handlerReset)))
+	mux.Handle("GET /admin/users", cfg.audited("admin.user.search", cfg.requireAuth(auth.ScopeAccount, requireAdmin(cfg.handlerAdminUsersList))))
+	mux.Handle("GET /admin/users/{userID}", cfg.audited("admin.user.view", cfg.requireAuth(auth.ScopeAccount, requireAdmin(cfg.handlerAdminUserGet))))
+	mux.Handle("PATCH /admin/users/{userID}", cfg.audited("admin.user.update", cfg.requireAuth(auth.ScopeAccount, requireAdmin(cfg.handlerAdminUserUpdate))))
+	mux.Handle("PATCH /admin/orgs/{orgID}", cfg.audited("admin.org.update", cfg.requireAuth(auth.ScopeAccount, requireAdmin(cfg.handlerAdminOrgUpdate))))
+	mux.Handle("GET /admin/videos/{videoID}", cfg.audited("admin.video.view", cfg.requireAuth(auth.ScopeAccount, requireAdmin(cfg.handlerAdminVideoGet))))
+	mux.Handle("DELETE /admin/videos/{videoID}", cfg.audited("admin.video.delete", cfg.requireAuth(auth.ScopeAccount, requireAdmin(cfg.handlerAdminVideoDelete))))
+	mux.Handle("GET /admin/storage", cfg.audited("admin.storage.view", cfg.requireAuth(auth.ScopeAccount, requireAdmin(cfg.handlerAdminStorage))))
+	mux.Handle("GET /admin/audit_events", cfg.audited("admin.audit_events.view", cfg.requireAuth(auth.ScopeAccount, requireAdmin(cfg.handlerAdminAuditEvents))))
 
 	srv := &http.Server{
-		Addr:    ":" + port,
-		Handler: mux,
+		Addr:    ":" + cfg.port,
+		Handler: cfg.logRequests(cfg.traceRequests(cfg.measureRequests(cfg.rateLimitedAPI(mux)))),
 	}
 
-	log.Printf("Serving on: http://localhost:%s/app/\n", port)
-	log.Fatal(srv.ListenAndServe())
+	stopped, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.ListenAndServe()
+	}()
+	logger.Info("Serving", "url", "http://localhost:"+cfg.port+"/app/")
+
+	select {
+	case err := <-serveErr:
+		log.Fatal(err)
+	case <-stopped.Done():
+	}
+	// A second signal kills the server straight away.
+	stop()
+
+	shutdownTimeout := settings.Server.ShutdownTimeout
+	shutdownDelay := settings.Server.ShutdownDelay
+	logger.Info("Shutting down", "delay", shutdownDelay.String(), "timeout", shutdownTimeout.String())
+	// From here on /readyz fails and new uploads are turned away. Keep
+	// serving everything else until load balancers have noticed.
+	cfg.jobs.stop()
+	time.Sleep(shutdownDelay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		// Closing the connections cancels the requests still running, which
+		// kills their ffmpeg and ffprobe processes.
+		logger.Warn("Gave up waiting for requests", "error", err)
+		srv.Close()
+	}
+	if err := cfg.jobs.wait(ctx); err != nil {
+		// Close doesn't wait for handlers to return, so give the cancelled
+		// uploads a moment to remove their files and stop using the
+		// database. Jobs still running after that are abandoned: their
+		// temporary files are removed and the database closed under them.
+		logger.Warn("Gave up waiting for uploads", "error", err)
+		srv.Close()
+		graceCtx, cancel := context.WithTimeout(context.Background(), abandonedJobsGrace)
+		defer cancel()
+		if err := cfg.jobs.wait(graceCtx); err != nil {
+			logger.Warn("Abandoning jobs still running", "error", err)
+		}
+	}
+	if n := cfg.jobs.removeTempFiles(); n > 0 {
+		logger.Info("Removed temporary files of unfinished uploads", "count", n)
+	}
+
+	if tracerProvider != nil {
+		flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := tracerProvider.Shutdown(flushCtx); err != nil {
+			logger.Warn("Couldn't export the last traces", "error", err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		logger.Error("Couldn't close database", "error", err)
+	}
+	logger.Info("Stopped")
 }
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// newTestAPIConfig returns a config with a fresh database and signing key,
+// enough for handlers that authenticate users.
+func newTestAPIConfig(t *testing.T) *apiConfig {
+	t.Helper()
+	dir := t.TempDir()
+	db, err := database.NewClient(filepath.Join(dir, "tubely.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := auth.GenerateKey(dir, auth.AlgEdDSA, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := auth.LoadKeySet(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &apiConfig{
+		db:      db,
+		jwtKeys: keys,
+		tokens:  defaultConfig().Tokens,
+		jobs:    newJobTracker(),
+	}
+}
+
+func testAccessToken(t *testing.T, cfg *apiConfig, userID uuid.UUID, scopes []string) string {
+	t.Helper()
+	token, err := auth.MakeJWT(userID, cfg.jwtKeys, time.Hour, scopes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func testUser(t *testing.T, cfg *apiConfig, email string) *database.User {
+	t.Helper()
+	user, err := cfg.db.CreateUser(database.CreateUserParams{Email: email, Password: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// testAPIKey creates an API key for the user, returning the key to send.
+func testAPIKey(t *testing.T, cfg *apiConfig, userID uuid.UUID, scopes []string, expiresAt *time.Time) string {
+	t.Helper()
+	key, err := auth.MakeAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = cfg.db.CreateAPIKey(database.CreateAPIKeyParams{
+		UserID:    userID,
+		Name:      "test",
+		KeyHash:   auth.HashAPIKey(key),
+		KeyPrefix: auth.APIKeyHint(key),
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// testRefreshToken starts a session for the user, returning its refresh
+// token.
+func testRefreshToken(t *testing.T, cfg *apiConfig, userID uuid.UUID, expiresAt time.Time) string {
+	t.Helper()
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = cfg.db.CreateRefreshToken(database.CreateRefreshTokenParams{
+		Token:     token,
+		UserID:    userID,
+		ExpiresAt: expiresAt,
+		Scopes:    auth.UserScopes,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// serverMetrics are the metrics served at /metrics.
+type serverMetrics struct {
+	registry *prometheus.Registry
+
+	httpRequestDuration *prometheus.HistogramVec
+	uploadSize          *prometheus.HistogramVec
+	uploadStageDuration *prometheus.HistogramVec
+	storageOperations   *prometheus.CounterVec
+	storageDuration     *prometheus.HistogramVec
+	// videoJobs counts videos being processed. Processing happens while the
+	// uploader waits, so this is also how many jobs are queued.
+	videoJobs prometheus.Gauge
+}
+
+func newServerMetrics(db database.Client) *serverMetrics {
+	reg := prometheus.NewRegistry()
+	m := &serverMetrics{
+		registry: reg,
+		httpRequestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "tubely_http_request_duration_seconds",
+			Help:    "How long HTTP requests took to handle, by route.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "status"}),
+		uploadSize: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "tubely_upload_size_bytes",
+			Help:    "Size of uploaded files.",
+			Buckets: prometheus.ExponentialBuckets(64<<10, 4, 10),
+		}, []string{"kind"}),
+		uploadStageDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "tubely_upload_stage_duration_seconds",
+			Help:    "How long each stage of processing an uploaded video took.",
+			Buckets: []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600},
+		}, []string{"stage", "result"}),
+		storageOperations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "tubely_storage_operations_total",
+			Help: "Operations on stored files.",
+		}, []string{"backend", "operation", "result"}),
+		storageDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "tubely_storage_operation_duration_seconds",
+			Help:    "How long operations on stored files took.",
+			Buckets: []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300},
+		}, []string{"backend", "operation"}),
+		videoJobs: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "tubely_video_jobs",
+			Help: "Uploaded videos being processed.",
+		}),
+	}
+	reg.MustRegister(
+		m.httpRequestDuration,
+		m.uploadSize,
+		m.uploadStageDuration,
+		m.storageOperations,
+		m.storageDuration,
+		m.videoJobs,
+		collectors.NewDBStatsCollector(db.DB(), "tubely"),
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// observeUploadStage records how long a stage of processing an uploaded video
+// took since start, and whether it failed.
+func (m *serverMetrics) observeUploadStage(stage string, start time.Time, err error) {
+	m.uploadStageDuration.WithLabelValues(stage, metricResult(err)).Observe(time.Since(start).Seconds())
+}
+
+// observeStorage records an operation on a stored file started at start.
+func (m *serverMetrics) observeStorage(backend, operation string, start time.Time, err error) {
+	m.storageOperations.WithLabelValues(backend, operation, metricResult(err)).Inc()
+	m.storageDuration.WithLabelValues(backend, operation).Observe(time.Since(start).Seconds())
+}
+
+func metricResult(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "success"
+}
+
+// measureRequests wraps next to record how long each request took by route.
+func (cfg *apiConfig) measureRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		// The mux fills in the route, which includes the method, on r as it
+		// routes it. Requests that didn't match share a label so clients
+		// can't make up new ones.
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		cfg.metrics.httpRequestDuration.WithLabelValues(route, strconv.Itoa(recorder.status)).Observe(time.Since(start).Seconds())
+	})
+}
+
+// handlerMetrics serves the metrics for Prometheus. If METRICS_TOKEN is set
+// it has to be given as a bearer token.
+func (cfg *apiConfig) handlerMetrics(w http.ResponseWriter, r *http.Request) {
+	if cfg.metricsToken != "" && !cfg.hasMetricsToken(r) {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't authenticate request", nil)
+		return
+	}
+	promhttp.HandlerFor(cfg.metrics.registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
+
+// hasMetricsToken reports whether the request carries METRICS_TOKEN as a
+// bearer token. It's always false if no token is configured.
+func (cfg *apiConfig) hasMetricsToken(r *http.Request) bool {
+	if cfg.metricsToken == "" {
+		return false
+	}
+	token, err := auth.GetBearerToken(r.Header)
+	return err == nil && subtle.ConstantTimeCompare([]byte(token), []byte(cfg.metricsToken)) == 1
+}
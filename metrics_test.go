@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestHandlerMetrics(t *testing.T) {
+	db, err := database.NewClient(filepath.Join(t.TempDir(), "tubely.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	cfg := &apiConfig{metrics: newServerMetrics(db), metricsToken: "scrape"}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/videos/{videoID}", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("GET /metrics", cfg.handlerMetrics)
+	handler := cfg.measureRequests(mux)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/videos/1", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/nowhere", nil))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("scrape without the token got %d, want 401", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	req.Header.Set("Authorization", "Bearer scrape")
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("scrape got %d", rec.Code)
+	}
+	for _, want := range []string{
+		`tubely_http_request_duration_seconds_count{route="GET /api/videos/{videoID}",status="200"} 1`,
+		`tubely_http_request_duration_seconds_count{route="unmatched",status="404"} 1`,
+		`go_sql_open_connections{db_name="tubely"}`,
+	} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("metrics don't contain %q", want)
+		}
+	}
+}
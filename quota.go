@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// quota limits a user's personal library and every upload they make. Zero
+// means unlimited. Organization videos count towards the organization's own
+// quotas instead, but the file size and duration limits still apply.
+type quota struct {
+	MaxVideos          int   `json:"max_videos"`
+	MaxStorageBytes    int64 `json:"max_storage_bytes"`
+	MaxFileBytes       int64 `json:"max_file_bytes"`
+	MaxDurationSeconds int   `json:"max_duration_seconds"`
+}
+
+// quotaSettings configure the default quota, which admins can override per
+// user.
+type quotaSettings struct {
+	MaxVideos       int           `yaml:"max_videos" env:"QUOTA_MAX_VIDEOS" validate:"nonnegative"`
+	MaxStorageBytes int64         `yaml:"max_storage_bytes" env:"QUOTA_MAX_STORAGE_BYTES" validate:"nonnegative"`
+	MaxFileBytes    int64         `yaml:"max_file_bytes" env:"QUOTA_MAX_FILE_BYTES" validate:"nonnegative"`
+	MaxDuration     time.Duration `yaml:"max_duration" env:"QUOTA_MAX_DURATION" validate:"nonnegative"`
+}
+
+// defaultQuotaSettings only limit uploads to the 10 GiB that used to be
+// hard-coded.
+var defaultQuotaSettings = quotaSettings{
+	MaxFileBytes: 10 << 30,
+}
+
+func (s quotaSettings) quota() quota {
+	return quota{
+		MaxVideos:          s.MaxVideos,
+		MaxStorageBytes:    s.MaxStorageBytes,
+		MaxFileBytes:       s.MaxFileBytes,
+		MaxDurationSeconds: int(s.MaxDuration / time.Second),
+	}
+}
+
+// withOverrides returns q with the limits the user has of their own.
+func (q quota) withOverrides(overrides database.UserQuota) quota {
+	if overrides.MaxVideos != nil {
+		q.MaxVideos = *overrides.MaxVideos
+	}
+	if overrides.MaxStorageBytes != nil {
+		q.MaxStorageBytes = *overrides.MaxStorageBytes
+	}
+	if overrides.MaxFileBytes != nil {
+		q.MaxFileBytes = *overrides.MaxFileBytes
+	}
+	if overrides.MaxDurationSeconds != nil {
+		q.MaxDurationSeconds = *overrides.MaxDurationSeconds
+	}
+	return q
+}
+
+// exceeded checks whether adding extraVideos videos and extraBytes bytes to
+// usage would go over the quota. If so, it returns a message describing which
+// limit.
+func (q quota) exceeded(usage database.StorageUsage, extraVideos int, extraBytes int64) string {
+	if q.MaxVideos > 0 && extraVideos > 0 && usage.VideoCount+extraVideos > q.MaxVideos {
+		return fmt.Sprintf("Video quota of %d videos exceeded", q.MaxVideos)
+	}
+	if q.MaxStorageBytes > 0 && extraBytes > 0 && usage.StorageBytes+extraBytes > q.MaxStorageBytes {
+		return fmt.Sprintf("Storage quota of %d bytes exceeded", q.MaxStorageBytes)
+	}
+	return ""
+}
+
+// userQuota returns the quota that applies to the user.
+func (cfg *apiConfig) userQuota(userID uuid.UUID) (quota, error) {
+	overrides, err := cfg.db.GetUserQuota(userID)
+	if err != nil {
+		return quota{}, err
+	}
+	return cfg.defaultQuota.withOverrides(overrides), nil
+}
+
+// checkUserQuota checks whether adding extraVideos videos and extraBytes bytes
+// would take the user's personal library over one of their quotas. If so, it
+// returns a message describing which one.
+func (cfg *apiConfig) checkUserQuota(userID uuid.UUID, extraVideos int, extraBytes int64) (string, error) {
+	q, err := cfg.userQuota(userID)
+	if err != nil {
+		return "", err
+	}
+	usage, err := cfg.db.GetUserStorageUsage(userID)
+	if err != nil {
+		return "", err
+	}
+	return q.exceeded(usage, extraVideos, extraBytes), nil
+}
+
+// checkVideoQuota checks whether replacing video's file with one of newBytes
+// bytes would take whoever the video counts towards over their storage quota:
+// its organization if it has one, otherwise its owner, who may not be the one
+// uploading.
+func (cfg *apiConfig) checkVideoQuota(video database.Video, newBytes int64) (string, error) {
+	if video.OrgID != nil {
+		return cfg.checkOrgQuota(*video.OrgID, 0, newBytes-video.SizeBytes)
+	}
+	return cfg.checkUserQuota(video.UserID, 0, newBytes-video.SizeBytes)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestQuotaExceeded(t *testing.T) {
+	zero := 0
+	q := quota{MaxVideos: 3, MaxStorageBytes: 1000}.withOverrides(database.UserQuota{MaxVideos: &zero})
+	if q.MaxVideos != 0 || q.MaxStorageBytes != 1000 {
+		t.Fatalf("overridden quota = %+v, want unlimited videos and 1000 bytes", q)
+	}
+	q.MaxVideos = 3
+
+	tests := []struct {
+		name        string
+		usage       database.StorageUsage
+		extraVideos int
+		extraBytes  int64
+		want        bool
+	}{
+		{"room left", database.StorageUsage{VideoCount: 2, StorageBytes: 500}, 1, 500, false},
+		{"too many videos", database.StorageUsage{VideoCount: 3}, 1, 0, true},
+		{"too many bytes", database.StorageUsage{StorageBytes: 900}, 0, 101, true},
+		// Shrinking a video is fine even when already over the quota.
+		{"replaced with smaller file", database.StorageUsage{VideoCount: 5, StorageBytes: 2000}, 0, -100, false},
+	}
+	for _, tt := range tests {
+		got := q.exceeded(tt.usage, tt.extraVideos, tt.extraBytes) != ""
+		if got != tt.want {
+			t.Errorf("%s: exceeded = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	if (quota{}).exceeded(database.StorageUsage{VideoCount: 1 << 20, StorageBytes: 1 << 40}, 1, 1) != "" {
+		t.Error("zero quota should be unlimited")
+	}
+}
+
+func TestCheckVideoQuota(t *testing.T) {
+	cfg := newTestAPIConfig(t)
+	cfg.defaultQuota = quota{MaxStorageBytes: 100}
+	owner := testUser(t, cfg, "owner@example.com")
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "Demo", UserID: owner.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if video, err = cfg.db.UpdateVideoFile(video.ID, "https://example.com/demo.mp4", 80); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the difference from the file being replaced counts.
+	tests := []struct {
+		newBytes int64
+		want     bool
+	}{
+		{100, false},
+		{120, true},
+		{10, false},
+	}
+	for _, tt := range tests {
+		exceeded, err := cfg.checkVideoQuota(video, tt.newBytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := exceeded != ""; got != tt.want {
+			t.Errorf("replacing 80 bytes with %d: exceeded = %q, want exceeded %v", tt.newBytes, exceeded, tt.want)
+		}
+	}
+
+	// Organization videos count towards the organization instead.
+	org, err := cfg.db.CreateOrg("Acme", owner.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	video.OrgID = &org.ID
+	if exceeded, err := cfg.checkVideoQuota(video, 1000); err != nil || exceeded != "" {
+		t.Errorf("organization video without an organization quota: %q, %v", exceeded, err)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ratelimit"
+)
+
+// Rate limit policies. Every API request counts towards rateLimitAPI, and
+// routes that are expensive or worth abusing count towards a stricter policy
+// too.
+const (
+	rateLimitAPI    = "api"
+	rateLimitSignup = "signup"
+	rateLimitAuth   = "auth"
+	rateLimitUpload = "upload"
+)
+
+// rateLimitSettings are the limits of the policies, like "10/1m" for bursts of
+// 10 requests refilling over a minute. "off" turns a policy off.
+type rateLimitSettings struct {
+	API    string `yaml:"api" env:"RATE_LIMIT_API" validate:"rate_limit"`
+	Signup string `yaml:"signup" env:"RATE_LIMIT_SIGNUP" validate:"rate_limit"`
+	Auth   string `yaml:"auth" env:"RATE_LIMIT_AUTH" validate:"rate_limit"`
+	Upload string `yaml:"upload" env:"RATE_LIMIT_UPLOAD" validate:"rate_limit"`
+}
+
+var defaultRateLimitSettings = rateLimitSettings{
+	API:    "300/1m",
+	Signup: "5/1h",
+	Auth:   "20/1m",
+	Upload: "30/1h",
+}
+
+// limits returns the limit of each policy. The settings must have been
+// validated.
+func (s rateLimitSettings) limits() map[string]ratelimit.Limit {
+	limits := map[string]ratelimit.Limit{}
+	for policy, value := range map[string]string{
+		rateLimitAPI:    s.API,
+		rateLimitSignup: s.Signup,
+		rateLimitAuth:   s.Auth,
+		rateLimitUpload: s.Upload,
+	} {
+		limits[policy], _ = parseRateLimit(value)
+	}
+	return limits
+}
+
+// parseRateLimit parses a limit like "10/1m". "off" is the zero Limit.
+func parseRateLimit(value string) (ratelimit.Limit, error) {
+	if value == "off" {
+		return ratelimit.Limit{}, nil
+	}
+	burst, period, ok := strings.Cut(value, "/")
+	if !ok {
+		return ratelimit.Limit{}, fmt.Errorf("no period in rate limit %q", value)
+	}
+	n, err := strconv.Atoi(burst)
+	if err != nil || n < 1 {
+		return ratelimit.Limit{}, fmt.Errorf("bad request count in rate limit %q", value)
+	}
+	d, err := time.ParseDuration(period)
+	if err != nil || d <= 0 {
+		return ratelimit.Limit{}, fmt.Errorf("bad period in rate limit %q", value)
+	}
+	return ratelimit.Limit{Burst: n, Period: d}, nil
+}
+
+// rateLimited wraps next so that each client can only make as many requests
+// as the policy allows. Clients are told where they stand with RateLimit-*
+// headers, and get a 429 when they run out.
+func (cfg *apiConfig) rateLimited(policy string, next http.Handler) http.Handler {
+	limit, ok := cfg.rateLimits[policy]
+	if !ok {
+		panic("unknown rate limit policy " + policy)
+	}
+	if limit.Burst == 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := policy + ":" + cfg.rateLimitKey(r)
+		result, err := cfg.rateLimiter.Take(r.Context(), key, limit)
+		if err != nil {
+			// Better to let requests through than to go down with the store.
+			requestLogger(r).Error("Couldn't check rate limit", "key", key, "error", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		header := w.Header()
+		header.Set("RateLimit-Limit", strconv.Itoa(limit.Burst))
+		header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		header.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+		header.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", limit.Burst, ceilSeconds(limit.Period)))
+		if !result.Allowed {
+			header.Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+			respondWithError(w, http.StatusTooManyRequests, "Too many requests, try again later", nil)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateLimitedAPI applies the general API policy to the API and admin routes,
+// leaving the app and its assets alone.
+func (cfg *apiConfig) rateLimitedAPI(next http.Handler) http.Handler {
+	limited := cfg.rateLimited(rateLimitAPI, next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/api/") || strings.HasPrefix(r.URL.Path, "/admin/") {
+			limited.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateLimitKey identifies the client making a request: the user for a valid
+// access token or API key, and otherwise the IP address. Made-up credentials
+// count towards the IP address, so they can't be used to get fresh buckets.
+func (cfg *apiConfig) rateLimitKey(r *http.Request) string {
+	if key, err := auth.GetAPIKey(r.Header); err == nil {
+		if apiKey, err := cfg.activeAPIKey(key); err == nil {
+			return "user:" + apiKey.UserID.String()
+		}
+	} else if token, err := auth.GetBearerToken(r.Header); err == nil {
+		if userID, _, err := auth.ValidateJWT(token, cfg.jwtKeys); err == nil {
+			return "user:" + userID.String()
+		}
+	}
+	return "ip:" + clientIP(r)
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ratelimit"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    ratelimit.Limit
+		wantErr bool
+	}{
+		{"10/1m", ratelimit.Limit{Burst: 10, Period: time.Minute}, false},
+		{"300/90s", ratelimit.Limit{Burst: 300, Period: 90 * time.Second}, false},
+		{"off", ratelimit.Limit{}, false},
+		{"10", ratelimit.Limit{}, true},
+		{"0/1m", ratelimit.Limit{}, true},
+		{"10/0s", ratelimit.Limit{}, true},
+		{"ten/1m", ratelimit.Limit{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseRateLimit(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseRateLimit(%q) = %+v, %v, want %+v, error %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestRateLimitKey(t *testing.T) {
+	cfg := newTestAPIConfig(t)
+	user := testUser(t, cfg, "alice@example.com")
+	expiredAt := time.Now().UTC().Add(-time.Minute)
+	revoked := testAPIKey(t, cfg, user.ID, auth.APIKeyScopes, nil)
+	revokedKey, _ := cfg.db.GetAPIKeyByHash(auth.HashAPIKey(revoked))
+	if err := cfg.db.RevokeAPIKey(revokedKey.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	byUser := "user:" + user.ID.String()
+	tests := []struct {
+		name          string
+		authorization string
+		want          string
+	}{
+		{"anonymous", "", "ip:192.0.2.1"},
+		{"access token", "Bearer " + testAccessToken(t, cfg, user.ID, nil), byUser},
+		{"made-up token", "Bearer nope", "ip:192.0.2.1"},
+		{"API key", "ApiKey " + testAPIKey(t, cfg, user.ID, auth.APIKeyScopes, nil), byUser},
+		{"expired API key", "ApiKey " + testAPIKey(t, cfg, user.ID, auth.APIKeyScopes, &expiredAt), "ip:192.0.2.1"},
+		{"revoked API key", "ApiKey " + revoked, "ip:192.0.2.1"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/api/videos", nil)
+		if tt.authorization != "" {
+			req.Header.Set("Authorization", tt.authorization)
+		}
+		if got := cfg.rateLimitKey(req); got != tt.want {
+			t.Errorf("%s: rateLimitKey = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,15 @@
+package main
+
+import (
+	"net"
+	"net/http"
+)
+
+// clientIP returns the address the request came from, without the port.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+)
+
+// runRotateKeys implements `tubely rotate-keys`. It generates a new signing
+// key and deletes keys that stopped signing long enough ago that every token
+// they signed has expired. Running servers pick up the new key on restart. It
+// reads the same settings as the server.
+func runRotateKeys(args []string) error {
+	flags := flag.NewFlagSet("rotate-keys", flag.ContinueOnError)
+	alg := flags.String("alg", auth.AlgEdDSA, "signing algorithm for the new key, EdDSA or RS256")
+	retain := flags.Duration("retain", 0, "how long to keep accepting tokens signed with replaced keys, at least and by default as long as tokens last")
+	settings, err := loadCommandConfig(flags, args, os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return fmt.Errorf("unexpected argument %q", flags.Arg(0))
+	}
+	retention, err := keyRetention(*retain, settings.Tokens)
+	if err != nil {
+		return err
+	}
+	keysDir := settings.Auth.JWTKeysDir
+
+	now := time.Now()
+	kid, err := auth.GenerateKey(keysDir, *alg, now)
+	if err != nil {
+		return fmt.Errorf("couldn't generate key: %w", err)
+	}
+	fmt.Printf("Generated signing key %s\n", kid)
+
+	pruned, err := auth.PruneKeys(keysDir, retention, now)
+	if err != nil {
+		return fmt.Errorf("couldn't prune old keys: %w", err)
+	}
+	for _, kid := range pruned {
+		fmt.Printf("Deleted retired key %s\n", kid)
+	}
+	return nil
+}
+
+// keyRetention returns how long replaced keys are kept: retain, or if it's
+// zero the lifetime of the longest-lived tokens they sign. Keeping them for
+// less would invalidate tokens that haven't expired yet.
+func keyRetention(retain time.Duration, tokens tokenSettings) (time.Duration, error) {
+	longest := max(tokens.AccessTTL, tokens.RefreshedAccessTTL, tokens.TwoFactorChallengeTTL)
+	if retain == 0 {
+		return longest, nil
+	}
+	if retain < longest {
+		return 0, fmt.Errorf("--retain %s is shorter than tokens signed with the keys last (%s)", retain, longest)
+	}
+	return retain, nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKeyRetention(t *testing.T) {
+	tokens := defaultConfig().Tokens
+	tokens.AccessTTL = 24 * time.Hour
+	tokens.RefreshedAccessTTL = time.Hour
+
+	tests := []struct {
+		retain  time.Duration
+		want    time.Duration
+		wantErr bool
+	}{
+		{0, 24 * time.Hour, false},
+		{48 * time.Hour, 48 * time.Hour, false},
+		{24 * time.Hour, 24 * time.Hour, false},
+		{23 * time.Hour, 0, true},
+	}
+	for _, tt := range tests {
+		got, err := keyRetention(tt.retain, tokens)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("keyRetention(%s) = %s, %v, want %s, error %v", tt.retain, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// runSetRole implements `tubely set-role <email> <role>`, which is how the
+// first admin gets made. After that, admins can promote others through the
+// admin API. It reads the same settings as the server.
+func runSetRole(args []string) error {
+	flags := flag.NewFlagSet("set-role", flag.ContinueOnError)
+	settings, err := loadCommandConfig(flags, args, os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		return errors.New("usage: tubely set-role [--config file] [--setting value ...] <email> user|admin")
+	}
+	email, role := flags.Arg(0), flags.Arg(1)
+	if role != database.UserRoleUser && role != database.UserRoleAdmin {
+		return fmt.Errorf("role must be %q or %q", database.UserRoleUser, database.UserRoleAdmin)
+	}
+
+	db, err := database.NewClient(settings.Database.Path)
+	if err != nil {
+		return fmt.Errorf("couldn't connect to database: %w", err)
+	}
+	defer db.Close()
+
+	user, err := db.GetUserByEmail(email)
+	if err != nil {
+		return fmt.Errorf("couldn't look up user: %w", err)
+	}
+	if user.ID == uuid.Nil {
+		return fmt.Errorf("no user with email %s", email)
+	}
+	err = db.SetUserRole(user.ID, role)
+	if err != nil {
+		return fmt.Errorf("couldn't set role: %w", err)
+	}
+	fmt.Printf("%s now has the %s role\n", email, role)
+	return nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// defaultShutdownTimeout is how long a stopped server waits for requests and
+// uploads in progress before giving up on them.
+const defaultShutdownTimeout = 30 * time.Second
+
+// abandonedJobsGrace is how long a stopped server waits, after giving up on
+// requests and closing their connections, for the uploads they belonged to to
+// clean up.
+const abandonedJobsGrace = 5 * time.Second
+
+// jobTracker keeps track of the uploads being processed, work done in the
+// background like sending emails, and the temporary files uploads are
+// processed in, so that a server being stopped can turn new uploads away, wait
+// for the jobs in progress and delete the files of any it had to give up on.
+type jobTracker struct {
+	mu        sync.Mutex
+	stopping  bool
+	running   sync.WaitGroup
+	tempFiles map[string]bool
+}
+
+func newJobTracker() *jobTracker {
+	return &jobTracker{tempFiles: map[string]bool{}}
+}
+
+// start registers a new job, unless the server is shutting down, in which
+// case it returns false. Call done when the job is finished.
+func (t *jobTracker) start() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.stopping {
+		return false
+	}
+	t.running.Add(1)
+	return true
+}
+
+func (t *jobTracker) done() {
+	t.running.Done()
+}
+
+// stop stops new jobs from starting.
+func (t *jobTracker) stop() {
+	t.mu.Lock()
+	t.stopping = true
+	t.mu.Unlock()
+}
+
+func (t *jobTracker) isStopping() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.stopping
+}
+
+// wait waits for the jobs in progress to finish, or for ctx to be done.
+func (t *jobTracker) wait(ctx context.Context) error {
+	finished := make(chan struct{})
+	go func() {
+		t.running.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// createTemp creates a temporary file like os.CreateTemp does, and remembers
+// it until it's removed with removeTemp.
+func (t *jobTracker) createTemp(pattern string) (*os.File, error) {
+	f, err := os.CreateTemp("", pattern)
+	if err != nil {
+		return nil, err
+	}
+	t.trackTemp(f.Name())
+	return f, nil
+}
+
+// trackTemp remembers a temporary file that something else is going to
+// create, like the output of ffmpeg.
+func (t *jobTracker) trackTemp(path string) {
+	t.mu.Lock()
+	t.tempFiles[path] = true
+	t.mu.Unlock()
+}
+
+// removeTemp removes a temporary file, if it exists, and forgets it.
+func (t *jobTracker) removeTemp(path string) {
+	os.Remove(path)
+	t.mu.Lock()
+	delete(t.tempFiles, path)
+	t.mu.Unlock()
+}
+
+// removeTempFiles removes the temporary files left by jobs that didn't
+// finish, returning how many there were.
+func (t *jobTracker) removeTempFiles() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := 0
+	for path := range t.tempFiles {
+		if err := os.Remove(path); err == nil {
+			n++
+		}
+		delete(t.tempFiles, path)
+	}
+	return n
+}
+
+// uploadJob wraps an upload handler so that each upload counts as a job,
+// which the server waits for when it's stopped. Uploads started while it's
+// shutting down are turned away.
+func (cfg *apiConfig) uploadJob(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !cfg.jobs.start() {
+			// Another instance should be taking uploads by then.
+			w.Header().Set("Retry-After", "10")
+			respondWithError(w, http.StatusServiceUnavailable, "Server is shutting down, try again later", nil)
+			return
+		}
+		defer cfg.jobs.done()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// inBackground runs f in a goroutine as a job, which the server waits for when
+// it's stopped. If the server is already shutting down it runs f straight
+// away instead.
+func (cfg *apiConfig) inBackground(f func()) {
+	if !cfg.jobs.start() {
+		f()
+		return
+	}
+	go func() {
+		defer cfg.jobs.done()
+		f()
+	}()
+}
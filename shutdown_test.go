@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestJobTrackerDrain(t *testing.T) {
+	cfg := apiConfig{jobs: newJobTracker()}
+	started := make(chan string)
+	release := make(chan struct{})
+	handler := cfg.uploadJob(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tmp, err := cfg.jobs.createTemp("tubely-test-*")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		tmp.Close()
+		started <- tmp.Name()
+		<-release
+	}))
+
+	// An upload in progress when the server is stopped is waited for.
+	finished := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/video_upload/1", nil))
+		close(finished)
+	}()
+	tempPath := <-started
+	cfg.jobs.stop()
+
+	// New uploads are turned away.
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("POST", "/api/video_upload/2", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("upload while stopping got %d, want 503", rec.Code)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := cfg.jobs.wait(ctx); err == nil {
+		t.Error("wait returned before the upload finished")
+	}
+
+	// Files of uploads that were given up on are removed.
+	if n := cfg.jobs.removeTempFiles(); n != 1 {
+		t.Errorf("removed %d temp files, want 1", n)
+	}
+	if _, err := os.Stat(tempPath); !os.IsNotExist(err) {
+		t.Errorf("temp file still there: %v", err)
+	}
+
+	close(release)
+	<-finished
+	if err := cfg.jobs.wait(context.Background()); err != nil {
+		t.Errorf("wait after the upload finished: %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// deleteVideoFiles deletes a video's file from the bucket and its thumbnail
+// from the assets directory. Files that are already gone, or that live
+// somewhere we didn't put them, are skipped.
+func (cfg *apiConfig) deleteVideoFiles(ctx context.Context, video database.Video) error {
+	if video.VideoURL != nil {
+		if key, ok := cfg.videoObjectKey(*video.VideoURL); ok {
+			start := time.Now()
+			spanCtx, span := startStorageSpan(ctx, "s3", "delete", key)
+			_, err := cfg.s3Client.DeleteObject(spanCtx, &s3.DeleteObjectInput{
+				Bucket: &cfg.s3Bucket,
+				Key:    &key,
+			})
+			endSpan(span, err)
+			cfg.metrics.observeStorage("s3", "delete", start, err)
+			if err != nil {
+				return fmt.Errorf("couldn't delete video %s from the bucket: %w", video.ID, err)
+			}
+		}
+	}
+
+	if video.ThumbnailURL != nil {
+		if thumbnailPath, ok := cfg.thumbnailPath(*video.ThumbnailURL); ok {
+			start := time.Now()
+			_, span := startStorageSpan(ctx, "local", "delete", filepath.Base(thumbnailPath))
+			err := os.Remove(thumbnailPath)
+			if errors.Is(err, os.ErrNotExist) {
+				err = nil
+			}
+			endSpan(span, err)
+			cfg.metrics.observeStorage("local", "delete", start, err)
+			if err != nil {
+				return fmt.Errorf("couldn't delete thumbnail of video %s: %w", video.ID, err)
+			}
+		}
+	}
+	return nil
+}
+
+// videoObjectKey returns the bucket key of a video URL from
+// handlerUploadVideo.
+func (cfg *apiConfig) videoObjectKey(videoURL string) (string, bool) {
+	key, ok := strings.CutPrefix(videoURL, fmt.Sprintf("https://%s/", cfg.s3CfDistribution))
+	if !ok || key == "" {
+		return "", false
+	}
+	return key, true
+}
+
+// thumbnailPath returns where the thumbnail at a URL from
+// handlerUploadThumbnail is stored.
+func (cfg *apiConfig) thumbnailPath(thumbnailURL string) (string, bool) {
+	u, err := url.Parse(thumbnailURL)
+	if err != nil {
+		return "", false
+	}
+	name, ok := strings.CutPrefix(u.Path, "/assets/")
+	if !ok || name == "" || path.Base(name) != name {
+		return "", false
+	}
+	return filepath.Join(cfg.assetsRoot, name), true
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestStorageLocations(t *testing.T) {
+	cfg := apiConfig{
+		assetsRoot:       "/srv/assets",
+		s3CfDistribution: "d111.cloudfront.net",
+	}
+
+	keys := []struct {
+		url    string
+		want   string
+		wantOK bool
+	}{
+		{"https://d111.cloudfront.net/landscape/abc.mp4", "landscape/abc.mp4", true},
+		{"https://d111.cloudfront.net/", "", false},
+		{"https://elsewhere.example.com/landscape/abc.mp4", "", false},
+	}
+	for _, tt := range keys {
+		got, ok := cfg.videoObjectKey(tt.url)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("videoObjectKey(%q) = %q, %v, want %q, %v", tt.url, got, ok, tt.want, tt.wantOK)
+		}
+	}
+
+	thumbnails := []struct {
+		url    string
+		want   string
+		wantOK bool
+	}{
+		{"http://localhost:8091/assets/abc.png", filepath.Join("/srv/assets", "abc.png"), true},
+		{"http://localhost:8091/assets/", "", false},
+		// Nothing outside the assets directory is ever deleted.
+		{"http://localhost:8091/assets/../main.go", "", false},
+		{"http://localhost:8091/assets/%2e%2e%2fmain.go", "", false},
+		{"http://localhost:8091/assets/sub/abc.png", "", false},
+		{"http://localhost:8091/other/abc.png", "", false},
+	}
+	for _, tt := range thumbnails {
+		got, ok := cfg.thumbnailPath(tt.url)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("thumbnailPath(%q) = %q, %v, want %q, %v", tt.url, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.40.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer starts the spans the server records itself. Until newTracerProvider
+// installs a provider its spans do nothing.
+var tracer = otel.Tracer("github.com/bootdotdev/learn-file-storage-s3-golang-starter")
+
+// tracingSettings configure exporting spans to an OpenTelemetry collector,
+// and work like the OTEL_* environment variables of the OpenTelemetry SDKs.
+// Sampling is set with OTEL_TRACES_SAMPLER and OTEL_TRACES_SAMPLER_ARG, which
+// the SDK reads itself.
+type tracingSettings struct {
+	// Endpoint is the collector's base URL, like "http://localhost:4318".
+	// TracesEndpoint is only needed if the traces endpoint isn't the usual
+	// /v1/traces under it.
+	Endpoint       string `yaml:"endpoint" env:"OTEL_EXPORTER_OTLP_ENDPOINT"`
+	TracesEndpoint string `yaml:"traces_endpoint" env:"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"`
+	Headers        string `yaml:"headers" env:"OTEL_EXPORTER_OTLP_HEADERS" secret:"true" validate:"headers"`
+	ServiceName    string `yaml:"service_name" env:"OTEL_SERVICE_NAME" validate:"required"`
+}
+
+// newTracerProvider installs a tracer provider exporting spans to the
+// collector over OTLP/HTTP, and W3C trace context propagation. Without an
+// endpoint it returns nil and nothing is traced.
+func newTracerProvider(settings tracingSettings, logger *slog.Logger) (*sdktrace.TracerProvider, error) {
+	endpoint := settings.TracesEndpoint
+	if endpoint == "" && settings.Endpoint != "" {
+		endpoint = strings.TrimSuffix(settings.Endpoint, "/") + "/v1/traces"
+	}
+	if endpoint == "" {
+		return nil, nil
+	}
+
+	headers, err := parseOTLPHeaders(settings.Headers)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tracing headers: %w", err)
+	}
+	exporter, err := otlptracehttp.New(context.Background(),
+		otlptracehttp.WithEndpointURL(endpoint),
+		otlptracehttp.WithHeaders(headers),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create trace exporter: %w", err)
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(semconv.ServiceName(settings.ServiceName)))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't describe the service for tracing: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		logger.Warn("Couldn't export traces", "error", err)
+	}))
+	return provider, nil
+}
+
+// parseOTLPHeaders parses headers in the OTEL_EXPORTER_OTLP_HEADERS format,
+// like "api-key=secret,x-tenant=tubely".
+func parseOTLPHeaders(value string) (map[string]string, error) {
+	headers := map[string]string{}
+	for _, pair := range strings.Split(value, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(pair, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("header %q isn't name=value", pair)
+		}
+		headers[name] = strings.TrimSpace(value)
+	}
+	return headers, nil
+}
+
+type routeContextKey struct{}
+
+// traceRequests wraps next so that every request is traced by otelhttp,
+// continuing the caller's trace if it sent a traceparent header. Spans are
+// named after the route, and the trace ID is logged with the request.
+func (cfg *apiConfig) traceRequests(next http.Handler) http.Handler {
+	traced := otelhttp.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logTrace(r, trace.SpanContextFromContext(r.Context()))
+		next.ServeHTTP(w, r)
+		if route, ok := r.Context().Value(routeContextKey{}).(*string); ok {
+			*route = r.Pattern
+		}
+	}), "http.server", otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+		if r.Pattern != "" {
+			return r.Pattern
+		}
+		return r.Method
+	}))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var route string
+		traced.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), routeContextKey{}, &route)))
+		// The mux fills in the route, which includes the method, on the
+		// request it's given as it routes it. Pass it on to the middleware
+		// outside this one too.
+		r.Pattern = route
+	})
+}
+
+// tracedAWSClient traces the requests an AWS SDK client sends with otelhttp,
+// passing the trace on to the service, and leaves sending them to the SDK's
+// own client.
+type tracedAWSClient struct {
+	next aws.HTTPClient
+}
+
+func (c tracedAWSClient) Do(r *http.Request) (*http.Response, error) {
+	return otelhttp.NewTransport(roundTripperFunc(c.next.Do)).RoundTrip(r)
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// startStorageSpan starts the span for an operation on a stored file, like
+// putting a video in the bucket. End it with endSpan.
+func startStorageSpan(ctx context.Context, backend, operation, key string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, backend+" "+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("storage.backend", backend),
+			attribute.String("storage.operation", operation),
+			attribute.String("storage.key", key),
+		),
+	)
+}
+
+// endSpan ends span, marking it failed if err isn't nil.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
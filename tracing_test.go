@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTraceRequests(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer otel.SetTracerProvider(sdktrace.NewTracerProvider())
+
+	var logs bytes.Buffer
+	cfg := &apiConfig{logger: slog.New(slog.NewJSONHandler(&logs, nil))}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/videos/{videoID}", func(w http.ResponseWriter, r *http.Request) {})
+	handler := cfg.logRequests(cfg.traceRequests(mux))
+
+	req := httptest.NewRequest("GET", "/api/videos/1", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+	span := spans[0]
+	if span.Name != "GET /api/videos/{videoID}" {
+		t.Errorf("span named %q, want the route", span.Name)
+	}
+	if got := span.SpanContext.TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("span in trace %s, want the caller's", got)
+	}
+	if got := span.Parent.SpanID().String(); got != "00f067aa0ba902b7" {
+		t.Errorf("span's parent is %s, want the caller's span", got)
+	}
+
+	for _, want := range []string{
+		`"trace_id":"4bf92f3577b34da6a3ce929d0e0e4736"`,
+		`"route":"GET /api/videos/{videoID}"`,
+	} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("access log doesn't contain %s: %s", want, logs.String())
+		}
+	}
+}
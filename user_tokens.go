@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mailer"
+)
+
+// sendUserToken emails user a new single use token for purpose. body gets the
+// token and returns the message text.
+func (cfg *apiConfig) sendUserToken(ctx context.Context, user database.User, purpose string, ttl time.Duration, subject string, body func(token string) string) error {
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		return err
+	}
+
+	err = cfg.db.CreateUserToken(database.CreateUserTokenParams{
+		TokenHash: auth.HashToken(token),
+		UserID:    user.ID,
+		Purpose:   purpose,
+		Email:     user.Email,
+		ExpiresAt: time.Now().UTC().Add(ttl),
+	})
+	if err != nil {
+		return err
+	}
+
+	return cfg.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: subject,
+		Body:    body(token),
+	})
+}
+
+func (cfg *apiConfig) sendVerificationEmail(ctx context.Context, user database.User) error {
+	return cfg.sendUserToken(ctx, user, database.UserTokenVerifyEmail, cfg.tokens.EmailVerificationTTL,
+		"Verify your Tubely email address",
+		func(token string) string {
+			return "Welcome to Tubely!\n\n" +
+				"To verify your email address, submit this token to POST /api/users/verify_email:\n\n" +
+				token + "\n\n" +
+				"It expires in " + expiresIn(cfg.tokens.EmailVerificationTTL) + ". If you didn't sign up for Tubely, you can ignore this email.\n"
+		})
+}
+
+func (cfg *apiConfig) sendPasswordResetEmail(ctx context.Context, user database.User) error {
+	return cfg.sendUserToken(ctx, user, database.UserTokenResetPassword, cfg.tokens.PasswordResetTTL,
+		"Reset your Tubely password",
+		func(token string) string {
+			return "Someone asked to reset the password of your Tubely account.\n\n" +
+				"To choose a new password, submit this token to POST /api/password_reset/confirm:\n\n" +
+				token + "\n\n" +
+				"It expires in " + expiresIn(cfg.tokens.PasswordResetTTL) + " and can only be used once. If it wasn't you, you can ignore this email.\n"
+		})
+}
+
+// expiresIn describes a token lifetime for an email, like "48 hours".
+func expiresIn(ttl time.Duration) string {
+	switch {
+	case ttl == time.Hour:
+		return "an hour"
+	case ttl > time.Hour && ttl%time.Hour == 0:
+		return fmt.Sprintf("%d hours", ttl/time.Hour)
+	case ttl == time.Minute:
+		return "a minute"
+	case ttl > time.Minute && ttl%time.Minute == 0:
+		return fmt.Sprintf("%d minutes", ttl/time.Minute)
+	default:
+		return ttl.String()
+	}
+}
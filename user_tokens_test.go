@@ -0,0 +1,24 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExpiresIn(t *testing.T) {
+	tests := []struct {
+		ttl  time.Duration
+		want string
+	}{
+		{time.Hour, "an hour"},
+		{48 * time.Hour, "48 hours"},
+		{time.Minute, "a minute"},
+		{90 * time.Minute, "90 minutes"},
+		{90 * time.Second, "1m30s"},
+	}
+	for _, tt := range tests {
+		if got := expiresIn(tt.ttl); got != tt.want {
+			t.Errorf("expiresIn(%s) = %q, want %q", tt.ttl, got, tt.want)
+		}
+	}
+}
@@ -2,12 +2,17 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"math"
 	"os/exec"
 	"strconv"
 	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type video struct {
@@ -88,32 +93,56 @@ type video struct {
 
 // processVideoForFastStart uses ffmpeg to process the video file at filePath
 // so that it is optimized for fast start (i.e., the moov atom is at the beginning).
-// It returns the path to the processed file.
-func processVideoForFastStart(filePath string) (string, error) {
+// The processed file is written to outputPath. ffmpeg is killed if ctx is
+// cancelled.
+func processVideoForFastStart(ctx context.Context, filePath, outputPath string) error {
 	// Use ffmpeg to process the video for fast start
-	outputPath := filePath + ".processing"
-	cmd := exec.Command("ffmpeg", "-i", filePath, "-movflags", "faststart", "-f", "mp4", "-c", "copy", outputPath)
+	cmd := exec.CommandContext(ctx, "ffmpeg", "-i", filePath, "-movflags", "faststart", "-f", "mp4", "-c", "copy", outputPath)
 	var errOut bytes.Buffer
 	cmd.Stderr = &errOut
-	err := cmd.Run()
+	err := runTraced(ctx, cmd)
 	if err != nil {
 		// Include stderr content to make debugging easier (missing ffprobe, bad file, etc.)
 		stderr := strings.TrimSpace(errOut.String())
 		if stderr == "" {
-			return "", fmt.Errorf("ffprobe failed: %w", err)
+			return fmt.Errorf("ffprobe failed: %w", err)
 		}
-		return "", fmt.Errorf("ffprobe failed: %w: %s", err, stderr)
+		return fmt.Errorf("ffprobe failed: %w: %s", err, stderr)
 	}
-	return outputPath, nil
+	return nil
 
 }
-func getVideoAspectRatio(filePath string) (string, error) {
+
+// getVideoDuration uses ffprobe to find out how long the video at filePath
+// plays for. ffprobe is killed if ctx is cancelled.
+func getVideoDuration(ctx context.Context, filePath string) (time.Duration, error) {
+	cmd := exec.CommandContext(ctx, "ffprobe", "-v", "error", "-show_entries", "format=duration", "-of", "default=noprint_wrappers=1:nokey=1", filePath)
+	var out, errOut bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &errOut
+	err := runTraced(ctx, cmd)
+	if err != nil {
+		stderr := strings.TrimSpace(errOut.String())
+		if stderr == "" {
+			return 0, fmt.Errorf("ffprobe failed: %w", err)
+		}
+		return 0, fmt.Errorf("ffprobe failed: %w: %s", err, stderr)
+	}
+
+	seconds, err := strconv.ParseFloat(strings.TrimSpace(out.String()), 64)
+	if err != nil {
+		return 0, fmt.Errorf("ffprobe returned no duration for %s: %w", filePath, err)
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+func getVideoAspectRatio(ctx context.Context, filePath string) (string, error) {
 	// Use ffprobe to get video metadata
-	cmd := exec.Command("ffprobe", "-v", "error", "-print_format", "json", "-show_streams", filePath)
+	cmd := exec.CommandContext(ctx, "ffprobe", "-v", "error", "-print_format", "json", "-show_streams", filePath)
 	var out, errOut bytes.Buffer
 	cmd.Stdout = &out
 	cmd.Stderr = &errOut
-	err := cmd.Run()
+	err := runTraced(ctx, cmd)
 	if err != nil {
 		// Include stderr content to make debugging easier (missing ffprobe, bad file, etc.)
 		stderr := strings.TrimSpace(errOut.String())
@@ -132,6 +161,20 @@ func getVideoAspectRatio(filePath string) (string, error) {
 	return parseVideoAspectFromJSON(out.Bytes())
 }
 
+// runTraced runs cmd in a span named after the program, so that slow ffmpeg
+// and ffprobe runs show up in the request's trace.
+func runTraced(ctx context.Context, cmd *exec.Cmd) error {
+	_, span := tracer.Start(ctx, "exec "+cmd.Args[0],
+		trace.WithAttributes(attribute.StringSlice("process.command_args", cmd.Args)),
+	)
+	err := cmd.Run()
+	if cmd.ProcessState != nil {
+		span.SetAttributes(attribute.Int("process.exit.code", cmd.ProcessState.ExitCode()))
+	}
+	endSpan(span, err)
+	return err
+}
+
 // parseVideoAspectFromJSON extracts the primary video stream from ffprobe JSON
 // and decides whether the video is "landscape", "portrait" or "other".
 //
@@ -1,6 +1,9 @@
 package main
 
-import "testing"
+import (
+	"context"
+	"testing"
+)
 
 func TestParseVideoAspectFromJSON(t *testing.T) {
 	cases := []struct {
@@ -86,7 +89,7 @@ func TestParseVideoAspectFromJSON_invalidJSON(t *testing.T) {
 // so this test simply asserts we get an error for a non-existent path and that the error
 // contains a helpful message.
 func TestGetVideoAspectRatio_missingFile(t *testing.T) {
-	_, err := getVideoAspectRatio("/path/that/does/not/exist.mp4")
+	_, err := getVideoAspectRatio(context.Background(), "/path/that/does/not/exist.mp4")
 	if err == nil {
 		t.Fatalf("expected error when running ffprobe on missing file")
 	}